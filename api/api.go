@@ -0,0 +1,337 @@
+// Package api defines the request and response bodies of the versioned
+// Differ API, served under /v1.
+//
+// Successful responses wrap their payload in a "data" field, and failed ones
+// describe the failure in an "error" field holding an Error. Images and
+// releases are represented by types.Image and types.Release.
+package api
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vanilla-os/differ/diff"
+	"github.com/vanilla-os/differ/types"
+)
+
+// Error codes, derived from the HTTP status of the response.
+const (
+	CodeInvalidRequest  = "invalid_request"
+	CodeUnauthorized    = "unauthorized"
+	CodeForbidden       = "forbidden"
+	CodeNotFound        = "not_found"
+	CodeConflict        = "conflict"
+	CodePolicyViolation = "policy_violation"
+	CodeInternal        = "internal_error"
+)
+
+// Error describes why a request failed.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Violations lists the rules broken by a release refused by the publish
+	// policy of its image.
+	Violations []types.PolicyViolation `json:"violations,omitempty"`
+}
+
+// ErrorCode returns the error code matching an HTTP status.
+func ErrorCode(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodePolicyViolation
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+
+	return CodeInvalidRequest
+}
+
+// Status reports whether the server is up.
+type Status struct {
+	Status string `json:"status"`
+}
+
+// NewImage describes an image to be created.
+type NewImage struct {
+	Name       string                `json:"name" binding:"required"`
+	URL        string                `json:"url" binding:"required"`
+	Comparator string                `json:"comparator,omitempty"`
+	Retention  types.RetentionPolicy `json:"retention"`
+	Policy     types.PublishPolicy   `json:"policy"`
+}
+
+// NewRelease describes a release to be created, along with its optional
+// metadata. A zero Date is replaced by the current time. Override publishes
+// the release even if it violates the publish policy of the image, which
+// requires admin rights.
+type NewRelease struct {
+	Digest string    `json:"digest" binding:"required"`
+	Date   time.Time `json:"date"`
+	types.ReleaseMetadata
+	Packages []types.Package `json:"packages" binding:"required"`
+	Override bool            `json:"override,omitempty"`
+}
+
+// SetTag points a tag at a release.
+type SetTag struct {
+	Digest string `json:"digest" binding:"required"`
+}
+
+// NewWebhook describes a webhook to be registered. Image restricts it to the
+// events of one image, and Events to some of types.WebhookEvents.
+type NewWebhook struct {
+	URL    string   `json:"url" binding:"required"`
+	Secret string   `json:"secret" binding:"required"`
+	Image  string   `json:"image,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// WebhookEvent is the payload sent to webhooks. Releases are sent without
+// their packages, and only with release events, along with the previous
+// non-yanked release and the diff from it. The first release of an image has
+// no previous release, and is diffed against an empty one.
+type WebhookEvent struct {
+	Event    string         `json:"event"`
+	Date     time.Time      `json:"date"`
+	Image    types.Image    `json:"image"`
+	Release  *types.Release `json:"release,omitempty"`
+	Previous *types.Release `json:"previous,omitempty"`
+	Diff     *Diff          `json:"diff,omitempty"`
+}
+
+// ActivityEvent is the data of the events streamed by /events. Releases are
+// sent without their packages. Tag events carry the tag, which may have been
+// created, and the digest it previously pointed to.
+type ActivityEvent struct {
+	Event    string         `json:"event"`
+	Date     time.Time      `json:"date"`
+	Image    string         `json:"image"`
+	Release  *types.Release `json:"release,omitempty"`
+	Tag      *types.Tag     `json:"tag,omitempty"`
+	Previous string         `json:"previous,omitempty"`
+}
+
+// Diff is the difference in packages between two releases.
+type Diff struct {
+	OldDigest  string             `json:"old_digest"`
+	NewDigest  string             `json:"new_digest"`
+	Added      []diff.PackageDiff `json:"added"`
+	Upgraded   []diff.PackageDiff `json:"upgraded"`
+	Downgraded []diff.PackageDiff `json:"downgraded"`
+	Removed    []diff.PackageDiff `json:"removed"`
+	// Relicensed lists the packages whose license changed, whether or not
+	// their version changed too.
+	Relicensed []types.LicenseChange `json:"relicensed"`
+	// Size is the difference in installed size between the releases, along
+	// with the packages whose size changed.
+	Size types.SizeDiff `json:"size"`
+	// Vulnerabilities lists the vulnerabilities fixed and introduced by the
+	// changes, when a vulnerability database was imported.
+	Vulnerabilities *types.VulnerabilityChanges `json:"vulnerabilities,omitempty"`
+	// PulledIn explains the added packages with the dependency chains that
+	// pulled them in, when requested.
+	PulledIn []types.DependencyChain `json:"pulled_in,omitempty"`
+}
+
+// Orders of the packages of a diff.
+const (
+	SortName = "name"
+	SortSize = "size"
+)
+
+// DiffOptions selects how a diff is presented, see Diff.Apply.
+type DiffOptions struct {
+	Sort    string        // SortName (the default) or SortSize
+	Hide    []diff.Change // version changes to leave out, see Diff.Hide
+	Explain bool          // explain the added packages with PulledIn
+}
+
+// NewDiff diffs the packages of oldRelease against those of newRelease,
+// comparing versions with compare, along with their licenses and installed
+// sizes. The result only depends on both releases and can be cached, unlike
+// the vulnerabilities set by core.DiffReleases.
+func NewDiff(oldRelease, newRelease *types.Release, compare diff.Comparator) *Diff {
+	result := &Diff{OldDigest: oldRelease.Digest, NewDigest: newRelease.Digest}
+	result.Added, result.Upgraded, result.Downgraded, result.Removed = oldRelease.DiffPackages(newRelease, compare)
+	result.Relicensed = oldRelease.DiffLicenses(newRelease)
+	result.Size = oldRelease.DiffSizes(newRelease)
+
+	return result
+}
+
+// Apply sorts, filters and explains the diff of oldRelease to newRelease as
+// selected by options. Vulnerabilities must be set first, as done by
+// core.DiffReleases, so that hiding changes keeps security fixes.
+func (d *Diff) Apply(oldRelease, newRelease *types.Release, options DiffOptions) {
+	d.Sort(options.Sort)
+	d.Hide(options.Hide)
+	if options.Explain {
+		d.PulledIn = oldRelease.ExplainAdded(newRelease)
+	}
+}
+
+// Sort orders the added, upgraded, downgraded and removed packages, as well
+// as those whose size changed, by name or, for SortSize, by decreasing size
+// change. Packages without known size change come last, by name.
+func (d *Diff) Sort(order string) {
+	byName := func(a, b diff.PackageDiff) int { return strings.Compare(a.Name, b.Name) }
+	for _, pkgs := range [][]diff.PackageDiff{d.Added, d.Upgraded, d.Downgraded, d.Removed} {
+		slices.SortFunc(pkgs, byName)
+	}
+	if order != SortSize {
+		slices.SortFunc(d.Size.Packages, func(a, b types.SizeChange) int { return strings.Compare(a.Name, b.Name) })
+		return
+	}
+
+	types.SortBySizeChange(d.Size.Packages)
+	rank := make(map[string]int, len(d.Size.Packages))
+	for i, change := range d.Size.Packages {
+		rank[change.Name] = i + 1
+	}
+	for _, pkgs := range [][]diff.PackageDiff{d.Added, d.Upgraded, d.Downgraded, d.Removed} {
+		slices.SortStableFunc(pkgs, func(a, b diff.PackageDiff) int {
+			if rank[a.Name] == 0 || rank[b.Name] == 0 {
+				return cmp.Compare(rank[b.Name], rank[a.Name])
+			}
+			return cmp.Compare(rank[a.Name], rank[b.Name])
+		})
+	}
+}
+
+// ParseChanges parses a comma-separated list of version changes, such as
+// "revision,rebuild".
+func ParseChanges(list string) ([]diff.Change, error) {
+	changes := []diff.Change{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		change, err := diff.ParseChange(name)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// Hide removes the upgraded and downgraded packages whose version change is
+// one of changes, unless they fix vulnerabilities.
+func (d *Diff) Hide(changes []diff.Change) {
+	if len(changes) == 0 {
+		return
+	}
+	hidden := func(pkg diff.PackageDiff) bool {
+		return !pkg.Security && slices.Contains(changes, pkg.Change)
+	}
+	d.Upgraded = slices.DeleteFunc(d.Upgraded, hidden)
+	d.Downgraded = slices.DeleteFunc(d.Downgraded, hidden)
+}
+
+// MarkSecurityFixes flags the upgraded and downgraded packages fixing at
+// least one of the vulnerabilities of the diff.
+func (d *Diff) MarkSecurityFixes() {
+	if d.Vulnerabilities == nil {
+		return
+	}
+	fixed := map[string]bool{}
+	for _, match := range d.Vulnerabilities.Fixed {
+		fixed[match.Package] = true
+	}
+	for _, pkgs := range [][]diff.PackageDiff{d.Upgraded, d.Downgraded} {
+		for i := range pkgs {
+			pkgs[i].Security = fixed[pkgs[i].Name]
+		}
+	}
+}
+
+// ChangedPackages returns the previous versions of the packages changed or
+// removed by the diff, and the new versions of those changed or added, as
+// expected by types.DiffVulnerabilities.
+func (d *Diff) ChangedPackages() (oldPackages, newPackages []types.Package) {
+	for _, pkg := range slices.Concat(d.Upgraded, d.Downgraded, d.Removed) {
+		oldPackages = append(oldPackages, types.Package{Name: pkg.Name, Version: pkg.PreviousVersion})
+	}
+	for _, pkg := range slices.Concat(d.Upgraded, d.Downgraded, d.Added) {
+		newPackages = append(newPackages, types.Package{Name: pkg.Name, Version: pkg.NewVersion})
+	}
+
+	return oldPackages, newPackages
+}
+
+// VulnerabilitySummary lists the vulnerabilities affecting the packages of a
+// release. Total and BySeverity count distinct vulnerabilities, those without
+// severity being counted as "unknown".
+type VulnerabilitySummary struct {
+	Digest string `json:"digest"`
+	// Database describes the imported vulnerability database, and is left
+	// out when none was imported.
+	Database        *types.VulnerabilityImport `json:"database,omitempty"`
+	Total           int                        `json:"total"`
+	BySeverity      map[string]int             `json:"by_severity"`
+	Vulnerabilities []types.VulnerabilityMatch `json:"vulnerabilities"`
+}
+
+// LicenseSummary is the distribution of licenses among the packages of a
+// release, Packages being their total number.
+type LicenseSummary struct {
+	Digest   string               `json:"digest"`
+	Packages int                  `json:"packages"`
+	Licenses []types.LicenseCount `json:"licenses"`
+}
+
+// ReverseDependencies lists the packages of a release depending on Package.
+type ReverseDependencies struct {
+	Digest     string            `json:"digest"`
+	Package    string            `json:"package"`
+	Dependents []types.Dependent `json:"dependents"`
+}
+
+// ReleaseFiles holds the file manifests of a release, by package, replacing
+// the previous ones.
+type ReleaseFiles struct {
+	Manifests []types.PackageFiles `json:"manifests" binding:"required"`
+}
+
+// FilesSummary describes the file manifests stored for a release.
+type FilesSummary struct {
+	Digest   string `json:"digest"`
+	Packages int    `json:"packages"`
+	Files    int    `json:"files"`
+}
+
+// FileDiff is the difference in files between two releases, restricted to
+// the paths starting with Prefix.
+type FileDiff struct {
+	OldDigest string `json:"old_digest"`
+	NewDigest string `json:"new_digest"`
+	Prefix    string `json:"prefix,omitempty"`
+	types.FileDiff
+}
+
+// GCResult summarizes a garbage collection run.
+type GCResult struct {
+	DeletedReleases  []string `json:"deleted_releases"`
+	DeletedPackages  int64    `json:"deleted_packages"`
+	DeletedManifests int64    `json:"deleted_manifests"`
+}
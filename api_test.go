@@ -0,0 +1,244 @@
+package main
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/vanilla-os/differ/types"
+)
+
+// TestV1Contract exercises every operation of the versioned API and
+// validates each response against the OpenAPI document served by the API
+// itself, failing on undocumented statuses or bodies not matching their
+// schema.
+func TestV1Contract(t *testing.T) {
+	t.Parallel()
+	server, router := newTestServer(t)
+	ctx := context.Background()
+
+	w := doRequest(router, http.MethodGet, "/v1/openapi.json", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("openapi.json returned status %d", w.Code)
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Validate(ctx); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	specRouter, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Changelogs are opaque text, as plain text is
+	openapi3filter.RegisterBodyDecoder("text/markdown", openapi3filter.FileBodyDecoder)
+	openapi3filter.RegisterBodyDecoder("text/html", openapi3filter.FileBodyDecoder)
+
+	// Webhooks are delivered to a local receiver
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+	webhook := fmt.Sprintf(`{"url":"%s","secret":"s3cret"}`, receiver.URL)
+
+	// Diffs are annotated with the vulnerabilities they fix
+	vulns := []types.Vulnerability{
+		{ID: "CVE-2024-0001", Severity: "high", Ranges: []types.AffectedRange{{Package: "apt", Fixed: "2.7.4"}}},
+		{ID: "CVE-2024-0002", Ranges: []types.AffectedRange{{Package: "bash"}}},
+	}
+	if err := types.ReplaceVulnerabilities(server.DB, &types.VulnerabilityImport{Format: "osv", ImportedAt: time.Now()}, vulns); err != nil {
+		t.Fatal(err)
+	}
+
+	release := func(digest, apt string) string {
+		return fmt.Sprintf(`{"digest":"%s","date":"2024-01-01T00:00:00Z","version":"%s","notes":"Ships apt %[2]s","packages":[{"name":"apt","version":"%[2]s","arch":"amd64","license":"GPL-2.0-or-later","installed_size":4194304,"depends":["bash"]},{"name":"bash","version":"5.2"}]}`, digest, apt)
+	}
+	// files returns manifests whose /etc/apt/sources.list has the given mode
+	// and content digest, made of the repeated character
+	files := func(mode int, digest string) string {
+		return fmt.Sprintf(`{"manifests":[{"package":"apt","files":[{"path":"/etc/apt/sources.list","mode":%d,"sha256":"%s"},{"path":"/usr/bin/apt","mode":33261,"sha256":"%s"}]}]}`,
+			0o100000|mode, strings.Repeat(digest, 64), strings.Repeat("c", 64))
+	}
+	steps := []struct {
+		method, path, body string
+		anonymous          bool
+		status             int
+	}{
+		{http.MethodGet, "/v1/status", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images", "", false, http.StatusOK},
+		{http.MethodPost, "/v1/webhooks", webhook, false, http.StatusCreated},
+		{http.MethodPost, "/v1/webhooks", webhook, true, http.StatusUnauthorized},
+		{http.MethodPost, "/v1/webhooks", `{"url":"ftp://example.com","secret":"s3cret"}`, false, http.StatusBadRequest},
+		{http.MethodPost, "/v1/webhooks", `{"url":"https://example.com","secret":"s3cret","image":"missing"}`, false, http.StatusNotFound},
+		{http.MethodPost, "/v1/images", `{"name":"contract","url":"https://example.com/contract"}`, false, http.StatusCreated},
+		{http.MethodPost, "/v1/images", `{"name":"contract","url":"https://example.com/contract"}`, false, http.StatusConflict},
+		{http.MethodPost, "/v1/images", `{"name":"other"}`, false, http.StatusBadRequest},
+		{http.MethodPost, "/v1/images", `{"name":"other","url":"https://example.com/other"}`, true, http.StatusUnauthorized},
+		{http.MethodGet, "/v1/images", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images?prefix=con&sort=-name&limit=1", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images?sort=url", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/images/contract", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/missing", "", false, http.StatusNotFound},
+		{http.MethodPatch, "/v1/images/contract", `{"retention":{"keep_last":5}}`, false, http.StatusOK},
+		{http.MethodPatch, "/v1/images/contract", `{"comparator":"bogus"}`, false, http.StatusBadRequest},
+		{http.MethodPost, "/v1/images/contract/releases", release("sha256:a", "2.7.3"), false, http.StatusCreated},
+		{http.MethodPost, "/v1/images/contract/releases", release("sha256:b", "2.7.4"), false, http.StatusCreated},
+		{http.MethodPost, "/v1/images/contract/releases", release("sha256:a", "2.7.3"), false, http.StatusConflict},
+		{http.MethodPost, "/v1/images/contract/releases", release("sha256:c", "2.7.4"), false, http.StatusConflict},
+		{http.MethodPost, "/v1/images/missing/releases", release("sha256:c", "2.7.3"), false, http.StatusNotFound},
+		{http.MethodPatch, "/v1/images/contract", `{"policy":{"forbidden_downgrades":["apt"],"required_packages":["bash"]}}`, false, http.StatusOK},
+		{http.MethodPatch, "/v1/images/contract", `{"policy":{"required_packages":["["]}}`, false, http.StatusBadRequest},
+		{http.MethodPost, "/v1/images/contract/releases", release("sha256:c", "2.7.2"), false, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/v1/images/contract/releases", strings.Replace(release("sha256:c", "2.7.2"), "{", `{"override":true,`, 1), false, http.StatusCreated},
+		{http.MethodPatch, "/v1/images/contract", `{"policy":{}}`, false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/versions/2.7.3", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/versions/9.9.9", "", false, http.StatusNotFound},
+		{http.MethodPatch, "/v1/images/contract/releases/sha256:a", `{"version":"2.7.4"}`, false, http.StatusConflict},
+		{http.MethodGet, "/v1/images/contract/releases", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/releases?sort=date&limit=1&since=2023-12-01T00:00:00Z", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/releases?cursor=bogus", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/images/contract/releases?since=yesterday", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/images/missing/releases", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/releases/latest", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/releases/sha256:a", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/releases/sha256:missing", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/releases/sha256:a/vulnerabilities", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/releases/sha256:missing/vulnerabilities", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/releases/sha256:a/licenses", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/releases/sha256:missing/licenses", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/releases/sha256:a/packages/bash/reverse-dependencies", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/releases/sha256:a/packages/missing/reverse-dependencies", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/diff/files?from=sha256:a&to=sha256:b", "", false, http.StatusNotFound},
+		{http.MethodPut, "/v1/images/contract/releases/sha256:a/files", files(0o644, "a"), false, http.StatusOK},
+		{http.MethodPut, "/v1/images/contract/releases/sha256:b/files", files(0o600, "b"), false, http.StatusOK},
+		{http.MethodPut, "/v1/images/contract/releases/sha256:b/files", files(0o600, "b"), true, http.StatusUnauthorized},
+		{http.MethodPut, "/v1/images/contract/releases/sha256:b/files", `{"manifests":[{"package":"apt","files":[{"path":"etc/apt","mode":33188}]}]}`, false, http.StatusBadRequest},
+		{http.MethodPut, "/v1/images/contract/releases/sha256:missing/files", files(0o644, "a"), false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/diff/files?from=sha256:a&to=sha256:b&prefix=/etc/", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/diff/files?from=sha256:a", "", false, http.StatusBadRequest},
+		{http.MethodPut, "/v1/images/contract/tags/stable", `{"digest":"sha256:a"}`, false, http.StatusOK},
+		{http.MethodPut, "/v1/images/contract/tags/testing", `{"digest":"sha256:b"}`, false, http.StatusOK},
+		{http.MethodPut, "/v1/images/contract/tags/stable", `{"digest":"sha256:b"}`, true, http.StatusUnauthorized},
+		{http.MethodPut, "/v1/images/contract/tags/latest", `{"digest":"sha256:b"}`, false, http.StatusBadRequest},
+		{http.MethodPut, "/v1/images/contract/tags/stable", `{"digest":"sha256:missing"}`, false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/tags", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/tags/stable", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/tags/nightly", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/tags/stable/history", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/tags/nightly/history", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/releases/stable", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/releases/latest?channel=testing", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/releases/latest?channel=nightly", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/diff?from=stable&to=testing", "", false, http.StatusOK},
+		{http.MethodDelete, "/v1/images/contract/tags/testing", "", false, http.StatusNoContent},
+		{http.MethodDelete, "/v1/images/contract/tags/testing", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/diff?from=latest&to=latest", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&explain=true", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&explain=maybe", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&sort=size", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&sort=weight", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&hide=revision,rebuild", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&hide=security", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&format=markdown&highlight=major", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&format=html", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&format=text", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&format=pdf", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:a&to=latest&highlight=minor", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/images/contract/diff?from=sha256:missing&to=latest", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/packages?name=apt", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/packages?name=a*&match=glob&image=contract&limit=1", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/packages?name=apt&match=regex", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/packages", "", false, http.StatusBadRequest},
+		{http.MethodGet, "/v1/images/contract/packages/apt/history", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/packages/missing/history", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/images/missing/packages/apt/history", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/webhooks", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/webhooks", "", true, http.StatusUnauthorized},
+		{http.MethodGet, "/v1/webhooks/1", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/webhooks/2", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/webhooks/bogus", "", false, http.StatusNotFound},
+		{http.MethodGet, "/v1/webhooks/1/deliveries?limit=2", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/webhooks/1/deliveries/2", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/webhooks/1/deliveries/99", "", false, http.StatusNotFound},
+		{http.MethodPost, "/v1/webhooks/1/deliveries/2/redeliver", "", false, http.StatusAccepted},
+		{http.MethodPost, "/v1/webhooks/1/deliveries/99/redeliver", "", false, http.StatusNotFound},
+		{http.MethodPatch, "/v1/images/contract/releases/sha256:b", `{"yanked":true}`, false, http.StatusOK},
+		{http.MethodPost, "/v1/gc", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/images/contract/releases/sha256:b", "", false, http.StatusOK},
+		{http.MethodDelete, "/v1/images/contract/releases/sha256:b", "", false, http.StatusNoContent},
+		{http.MethodDelete, "/v1/images/contract/releases/sha256:b", "", false, http.StatusNotFound},
+		{http.MethodDelete, "/v1/webhooks/1", "", false, http.StatusNoContent},
+		{http.MethodDelete, "/v1/webhooks/1", "", false, http.StatusNotFound},
+		{http.MethodDelete, "/v1/images/contract", "", false, http.StatusNoContent},
+		{http.MethodDelete, "/v1/images/contract", "", false, http.StatusNotFound},
+	}
+
+	exercised := map[string]bool{}
+	for _, step := range steps {
+		name := step.method + " " + step.path
+		req := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		if step.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if !step.anonymous {
+			req.SetBasicAuth("admin", "admin")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != step.status {
+			t.Fatalf("%s: expected status %d, got %d: %s", name, step.status, w.Code, w.Body.String())
+		}
+
+		route, pathParams, err := specRouter.FindRoute(req)
+		if err != nil {
+			t.Fatalf("%s: not documented: %v", name, err)
+		}
+		err = openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: pathParams,
+				Route:      route,
+			},
+			Status:  w.Code,
+			Header:  w.Header(),
+			Body:    io.NopCloser(bytes.NewReader(w.Body.Bytes())),
+			Options: &openapi3filter.Options{IncludeResponseStatus: true},
+		})
+		if err != nil {
+			t.Fatalf("%s: response does not match the specification: %v\n%s", name, err, w.Body.String())
+		}
+		if w.Code < 300 {
+			exercised[route.Operation.OperationID] = true
+		}
+	}
+
+	for path, item := range doc.Paths.Map() {
+		for method, op := range item.Operations() {
+			if !exercised[op.OperationID] {
+				t.Errorf("%s %s (%s) was not exercised", method, path, op.OperationID)
+			}
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/vanilla-os/differ/client"
+	"github.com/vanilla-os/differ/core"
+	"github.com/vanilla-os/differ/diff"
+	"github.com/vanilla-os/differ/types"
+	"gorm.io/gorm"
+)
+
+// backend is where CLI commands read and write images and releases, either
+// a database or a remote Differ server.
+type backend interface {
+	ListImages() ([]types.Image, error)
+	GetImage(name string) (*types.Image, error)
+	AddImage(image *types.Image) (*types.Image, error)
+	UpdateImage(name string, update types.ImageUpdate) (*types.Image, error)
+	// AddRelease checks the release against the publish policy of the image,
+	// unless override is set.
+	AddRelease(imageName string, release *types.Release, override bool) (*types.Release, error)
+	// GetRelease accepts a digest or a symbolic reference such as types.LatestRef.
+	GetRelease(imageName, ref string) (*types.Release, error)
+	// Diff explains the added packages with their dependency chains when
+	// explain is set.
+	Diff(imageName, oldRef, newRef string, options client.DiffOptions) (*client.Diff, error)
+	ReverseDependencies(imageName, ref, name string) (*client.ReverseDependencies, error)
+	// SetReleaseFiles replaces the file manifests of the release with the
+	// given digest.
+	SetReleaseFiles(imageName, digest string, manifests []types.PackageFiles) (*client.FilesSummary, error)
+	DiffFiles(imageName, oldRef, newRef, prefix string) (*client.FileDiff, error)
+}
+
+// bindBackendFlags registers the flags selecting a backend on fs. Commands
+// operate on a remote server when -url or DIFFER_URL is set, and directly on
+// the configured database otherwise.
+func bindBackendFlags(fs *flag.FlagSet) func() (backend, error) {
+	remoteURL := fs.String("url", os.Getenv("DIFFER_URL"), "`URL` of a remote Differ server")
+	user := fs.String("user", os.Getenv("DIFFER_USER"), "user for authenticating against the remote server")
+	password := fs.String("password", os.Getenv("DIFFER_PASSWORD"), "password for authenticating against the remote server")
+	loadDSN := bindDSNFlag(fs)
+
+	return func() (backend, error) {
+		if *remoteURL != "" {
+			c, err := client.New(*remoteURL, client.WithBasicAuth(*user, *password))
+			if err != nil {
+				return nil, err
+			}
+			return &remoteBackend{client: c}, nil
+		}
+
+		dsn, err := loadDSN()
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration:\n%v", err)
+		}
+		db, err := core.OpenStorage(dsn)
+		if err != nil {
+			return nil, err
+		}
+
+		return &localBackend{db: db, comparators: diff.DefaultComparators()}, nil
+	}
+}
+
+// localBackend operates directly on a database.
+type localBackend struct {
+	db          *gorm.DB
+	comparators diff.Comparators
+}
+
+func (lb *localBackend) ListImages() ([]types.Image, error) {
+	return types.GetImages(lb.db)
+}
+
+func (lb *localBackend) GetImage(name string) (*types.Image, error) {
+	image, err := types.GetImageByName(lb.db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
+
+func (lb *localBackend) AddImage(image *types.Image) (*types.Image, error) {
+	if _, err := lb.comparators.Get(image.Comparator); err != nil {
+		return nil, err
+	}
+	if err := image.Policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return image, types.NewImage(lb.db, image)
+}
+
+func (lb *localBackend) UpdateImage(name string, update types.ImageUpdate) (*types.Image, error) {
+	image, err := types.FindImage(lb.db, name)
+	if err != nil {
+		return nil, err
+	}
+	if update.Comparator != nil {
+		if _, err := lb.comparators.Get(*update.Comparator); err != nil {
+			return nil, err
+		}
+	}
+	if update.Policy != nil {
+		if err := update.Policy.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := image.Update(lb.db, update); err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		name = *update.Name
+	}
+	return lb.GetImage(name)
+}
+
+// AddRelease lets anyone override publish policies, since direct access to
+// the database bypasses authentication anyway.
+func (lb *localBackend) AddRelease(imageName string, release *types.Release, override bool) (*types.Release, error) {
+	image, err := lb.GetImage(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	release.ImageID = image.ID
+	if !override {
+		compare, err := lb.comparators.Get(image.Comparator)
+		if err != nil {
+			return nil, err
+		}
+		if err := image.CheckPolicy(lb.db, release, compare); err != nil {
+			return nil, err
+		}
+	}
+
+	return image.NewRelease(lb.db, release)
+}
+
+func (lb *localBackend) GetRelease(imageName, ref string) (*types.Release, error) {
+	image, err := lb.GetImage(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	return image.ResolveRelease(lb.db, ref)
+}
+
+func (lb *localBackend) Diff(imageName, oldRef, newRef string, options client.DiffOptions) (*client.Diff, error) {
+	image, err := lb.GetImage(imageName)
+	if err != nil {
+		return nil, err
+	}
+	oldRelease, err := image.ResolveRelease(lb.db, oldRef)
+	if err != nil {
+		return nil, err
+	}
+	newRelease, err := image.ResolveRelease(lb.db, newRef)
+	if err != nil {
+		return nil, err
+	}
+	compare, err := lb.comparators.Get(image.Comparator)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := core.DiffReleases(lb.db, nil, oldRelease, newRelease, compare)
+	if err != nil {
+		return nil, err
+	}
+	result.Apply(oldRelease, newRelease, options)
+
+	return result, nil
+}
+
+func (lb *localBackend) ReverseDependencies(imageName, ref, name string) (*client.ReverseDependencies, error) {
+	release, err := lb.GetRelease(imageName, ref)
+	if err != nil {
+		return nil, err
+	}
+	dependents, err := release.ReverseDependencies(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.ReverseDependencies{Digest: release.Digest, Package: name, Dependents: dependents}, nil
+}
+
+func (lb *localBackend) SetReleaseFiles(imageName, digest string, manifests []types.PackageFiles) (*client.FilesSummary, error) {
+	image, err := lb.GetImage(imageName)
+	if err != nil {
+		return nil, err
+	}
+	release, err := image.GetReleaseByDigest(lb.db, digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := release.SetFiles(lb.db, manifests); err != nil {
+		return nil, err
+	}
+
+	summary := client.FilesSummary{Digest: release.Digest, Packages: len(manifests)}
+	for _, manifest := range manifests {
+		summary.Files += len(manifest.Files)
+	}
+	return &summary, nil
+}
+
+func (lb *localBackend) DiffFiles(imageName, oldRef, newRef, prefix string) (*client.FileDiff, error) {
+	oldRelease, err := lb.GetRelease(imageName, oldRef)
+	if err != nil {
+		return nil, err
+	}
+	newRelease, err := lb.GetRelease(imageName, newRef)
+	if err != nil {
+		return nil, err
+	}
+	result, err := oldRelease.DiffFiles(lb.db, newRelease, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.FileDiff{OldDigest: oldRelease.Digest, NewDigest: newRelease.Digest, Prefix: prefix, FileDiff: *result}, nil
+}
+
+// remoteBackend operates on a Differ server through its HTTP API.
+type remoteBackend struct {
+	client *client.Client
+}
+
+func (rb *remoteBackend) ListImages() ([]types.Image, error) {
+	return rb.client.ListImages(context.Background())
+}
+
+func (rb *remoteBackend) GetImage(name string) (*types.Image, error) {
+	image, err := rb.client.GetImage(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	query := types.ReleaseQuery{Limit: types.MaxPageSize}
+	for {
+		releases, next, err := rb.client.ListReleases(context.Background(), name, query)
+		if err != nil {
+			return nil, err
+		}
+		image.Releases = append(image.Releases, releases...)
+		if next == "" {
+			return image, nil
+		}
+		query.Cursor = next
+	}
+}
+
+func (rb *remoteBackend) AddImage(image *types.Image) (*types.Image, error) {
+	return rb.client.AddImage(context.Background(), client.NewImageRequest{
+		Name:       image.Name,
+		URL:        image.URL,
+		Comparator: image.Comparator,
+		Retention:  image.Retention,
+		Policy:     image.Policy,
+	})
+}
+
+func (rb *remoteBackend) UpdateImage(name string, update types.ImageUpdate) (*types.Image, error) {
+	if _, err := rb.client.UpdateImage(context.Background(), name, update); err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		name = *update.Name
+	}
+	return rb.GetImage(name)
+}
+
+func (rb *remoteBackend) AddRelease(imageName string, release *types.Release, override bool) (*types.Release, error) {
+	return rb.client.AddRelease(context.Background(), imageName, client.NewReleaseRequest{
+		Digest:          release.Digest,
+		Date:            release.Date,
+		ReleaseMetadata: release.ReleaseMetadata,
+		Packages:        release.Packages,
+		Override:        override,
+	})
+}
+
+func (rb *remoteBackend) GetRelease(imageName, ref string) (*types.Release, error) {
+	var release *types.Release
+	var err error
+	if ref == types.LatestRef {
+		release, err = rb.client.GetLatestRelease(context.Background(), imageName)
+	} else {
+		release, err = rb.client.GetRelease(context.Background(), imageName, ref)
+	}
+	if err == nil && release == nil {
+		err = fmt.Errorf("image %s has no releases", imageName)
+	}
+
+	return release, err
+}
+
+func (rb *remoteBackend) Diff(imageName, oldRef, newRef string, options client.DiffOptions) (*client.Diff, error) {
+	return rb.client.DiffWith(context.Background(), imageName, oldRef, newRef, options)
+}
+
+func (rb *remoteBackend) ReverseDependencies(imageName, ref, name string) (*client.ReverseDependencies, error) {
+	return rb.client.ReverseDependencies(context.Background(), imageName, ref, name)
+}
+
+func (rb *remoteBackend) SetReleaseFiles(imageName, digest string, manifests []types.PackageFiles) (*client.FilesSummary, error) {
+	return rb.client.SetReleaseFiles(context.Background(), imageName, digest, manifests)
+}
+
+func (rb *remoteBackend) DiffFiles(imageName, oldRef, newRef, prefix string) (*client.FileDiff, error) {
+	return rb.client.DiffFiles(context.Background(), imageName, oldRef, newRef, prefix)
+}
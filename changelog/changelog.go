@@ -0,0 +1,229 @@
+// Package changelog renders the diff between two releases as a changelog
+// meant for humans, in Markdown, HTML or plain text.
+//
+// Changelogs are rendered with Go templates. The default ones are embedded in
+// the binary and can be replaced by operators, see NewRenderer.
+package changelog
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/diff"
+	"github.com/vanilla-os/differ/types"
+)
+
+// Format is an output format of changelogs.
+type Format string
+
+const (
+	Markdown Format = "markdown"
+	HTML     Format = "html"
+	Text     Format = "text"
+)
+
+// Formats lists every supported format.
+var Formats = []Format{Markdown, HTML, Text}
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	format := Format(name)
+	if !slices.Contains(Formats, format) {
+		return "", fmt.Errorf("unknown changelog format %q, expected markdown, html or text", name)
+	}
+
+	return format, nil
+}
+
+// ContentType returns the media type of changelogs rendered in the format.
+func (f Format) ContentType() string {
+	switch f {
+	case Markdown:
+		return "text/markdown; charset=utf-8"
+	case HTML:
+		return "text/html; charset=utf-8"
+	}
+
+	return "text/plain; charset=utf-8"
+}
+
+// Change is a package that changed between two releases. OldVersion is empty
+// for added packages, and NewVersion for removed ones.
+type Change struct {
+	Name       string
+	OldVersion string
+	NewVersion string
+	// Major is set, when highlighting is enabled, for upgrades and
+	// downgrades changing the epoch or major version of the package.
+	Major bool
+}
+
+// Group holds the changes of one kind, sorted by package name.
+type Group struct {
+	Kind    string // added, upgraded, downgraded or removed
+	Title   string
+	Changes []Change
+}
+
+// Changelog is the data passed to templates.
+type Changelog struct {
+	Image string
+	// Old is nil for the first release of an image, diffed against nothing.
+	Old *types.Release
+	New *types.Release
+	// Groups always holds, in order, the added, upgraded, downgraded and
+	// removed packages, even when there are none.
+	Groups []Group
+	// Relicensed lists the packages whose license changed.
+	Relicensed []types.LicenseChange
+	Highlight  bool
+	// Vulnerabilities lists the vulnerabilities fixed and introduced by the
+	// changes, and is nil when no vulnerability database was imported.
+	Vulnerabilities *types.VulnerabilityChanges
+}
+
+// New returns the changelog of result, the diff of image from the old to the
+// new release, old being nil for the first release. Major version bumps are
+// flagged when highlight is set.
+func New(image string, old, new *types.Release, result *api.Diff, highlight bool) *Changelog {
+	changelog := &Changelog{Image: image, Old: old, New: new, Relicensed: result.Relicensed, Highlight: highlight, Vulnerabilities: result.Vulnerabilities}
+	kinds := []struct {
+		kind, title string
+		pkgs        []diff.PackageDiff
+	}{
+		{"added", "Added", result.Added},
+		{"upgraded", "Upgraded", result.Upgraded},
+		{"downgraded", "Downgraded", result.Downgraded},
+		{"removed", "Removed", result.Removed},
+	}
+	for _, kind := range kinds {
+		group := Group{Kind: kind.kind, Title: kind.title, Changes: make([]Change, 0, len(kind.pkgs))}
+		for _, pkg := range kind.pkgs {
+			change := Change{Name: pkg.Name, OldVersion: pkg.PreviousVersion, NewVersion: pkg.NewVersion}
+			if highlight && change.OldVersion != "" && change.NewVersion != "" {
+				change.Major = diff.MajorBump(change.OldVersion, change.NewVersion)
+			}
+			group.Changes = append(group.Changes, change)
+		}
+		slices.SortFunc(group.Changes, func(a, b Change) int { return strings.Compare(a.Name, b.Name) })
+		changelog.Groups = append(changelog.Groups, group)
+	}
+
+	return changelog
+}
+
+// Total returns the number of changed packages.
+func (c *Changelog) Total() int {
+	total := 0
+	for _, group := range c.Groups {
+		total += len(group.Changes)
+	}
+
+	return total
+}
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// funcs are the functions available to templates besides the builtin ones.
+var funcs = map[string]any{
+	"label": Label,
+	// short abbreviates a digest to the first 12 characters of its hash
+	"short": shortDigest,
+	// date formats a time as an ISO 8601 date
+	"date": func(t time.Time) string {
+		return t.Format(time.DateOnly)
+	},
+}
+
+// Label names a release by its version, or its short digest if unset.
+func Label(release *types.Release) string {
+	if release.Version != "" {
+		return release.Version
+	}
+
+	return shortDigest(release.Digest)
+}
+
+func shortDigest(digest string) string {
+	_, hash, found := strings.Cut(digest, ":")
+	if !found {
+		hash = digest
+	}
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+
+	return hash
+}
+
+// executor is the interface shared by text and HTML templates.
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// Renderer renders changelogs in every format.
+type Renderer struct {
+	templates map[Format]executor
+}
+
+// NewRenderer parses the changelog templates. Templates found in dir, if not
+// empty, replace the default ones and are named after their format, such as
+// markdown.tmpl. HTML templates escape their output as html/template does.
+func NewRenderer(dir string) (*Renderer, error) {
+	r := &Renderer{templates: map[Format]executor{}}
+	for _, format := range Formats {
+		name := string(format) + ".tmpl"
+		content, err := defaultTemplates.ReadFile("templates/" + name)
+		if err != nil {
+			return nil, err
+		}
+		if dir != "" {
+			override, err := os.ReadFile(filepath.Join(dir, name))
+			switch {
+			case err == nil:
+				content = override
+			case !errors.Is(err, os.ErrNotExist):
+				return nil, err
+			}
+		}
+
+		if format == HTML {
+			r.templates[format], err = htmltemplate.New(name).Funcs(funcs).Parse(string(content))
+		} else {
+			r.templates[format], err = texttemplate.New(name).Funcs(funcs).Parse(string(content))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid changelog template %s: %v", name, err)
+		}
+	}
+
+	return r, nil
+}
+
+// Render writes changelog to w in the given format.
+func (r *Renderer) Render(w io.Writer, format Format, changelog *Changelog) error {
+	tmpl, ok := r.templates[format]
+	if !ok {
+		return fmt.Errorf("unknown changelog format %q", format)
+	}
+
+	return tmpl.Execute(w, changelog)
+}
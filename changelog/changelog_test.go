@@ -0,0 +1,132 @@
+package changelog
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/diff"
+	"github.com/vanilla-os/differ/types"
+)
+
+func newTestChangelog(highlight bool) *Changelog {
+	old := &types.Release{Digest: "sha256:0123456789abcdef", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	new := &types.Release{Digest: "sha256:fedcba9876543210", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}
+	new.Version = "2.0"
+	result := &api.Diff{
+		Added:      []diff.PackageDiff{{Name: "libstdc++6", NewVersion: "14.1-1"}},
+		Upgraded:   []diff.PackageDiff{{Name: "xdg-desktop-portal", NewVersion: "46.2-1", PreviousVersion: "44.2-4"}, {Name: "apt", NewVersion: "2.7.4", PreviousVersion: "2.7.3"}},
+		Downgraded: []diff.PackageDiff{},
+		Removed:    []diff.PackageDiff{{Name: "<script>", PreviousVersion: "1"}},
+	}
+
+	return New("pico", old, new, result, highlight)
+}
+
+func render(t *testing.T, r *Renderer, format Format, changelog *Changelog) string {
+	t.Helper()
+	var out strings.Builder
+	if err := r.Render(&out, format, changelog); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.String()
+}
+
+func TestRender(t *testing.T) {
+	r, err := NewRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "## pico 2.0\n\n" +
+		"Changes from 0123456789ab (2024-01-01) to 2.0 (2024-02-01), 4 packages in total.\n\n" +
+		"### Added (1)\n\n- `libstdc++6` 14.1-1\n\n" +
+		"### Upgraded (2)\n\n- `apt` 2.7.3 → 2.7.4\n- **`xdg-desktop-portal`** 44.2-4 → 46.2-1 (major)\n\n" +
+		"### Removed (1)\n\n- `<script>` 1\n"
+	if markdown := render(t, r, Markdown, newTestChangelog(true)); markdown != expected {
+		t.Errorf("unexpected Markdown changelog:\n%s", markdown)
+	}
+
+	if text := render(t, r, Text, newTestChangelog(false)); strings.Contains(text, "[major]") || !strings.Contains(text, "Upgraded (2):\n  apt 2.7.3 -> 2.7.4\n") {
+		t.Errorf("unexpected plain text changelog:\n%s", text)
+	}
+
+	first := newTestChangelog(false)
+	first.Old = nil
+	if text := render(t, r, Text, first); !strings.Contains(text, "First release, published 2024-02-01, 4 packages in total.") {
+		t.Errorf("unexpected changelog of a first release:\n%s", text)
+	}
+
+	html := render(t, r, HTML, newTestChangelog(true))
+	if strings.Contains(html, "<script>") || !strings.Contains(html, `<li class="major">`) {
+		t.Errorf("unexpected HTML changelog:\n%s", html)
+	}
+
+	relicensed := newTestChangelog(false)
+	relicensed.Relicensed = []types.LicenseChange{
+		{Name: "apt", PreviousVersion: "2.7.3", NewVersion: "2.7.4", PreviousLicense: "GPL-2.0-or-later", NewLicense: "GPL-3.0-only"},
+		{Name: "bash", PreviousVersion: "5.2", NewVersion: "5.2", PreviousLicense: "GPL-3.0-or-later", NewLicense: "LicenseRef-proprietary"},
+	}
+	if markdown := render(t, r, Markdown, relicensed); !strings.HasSuffix(markdown, "\n\n### License changes (2)\n\n- `apt` 2.7.3 → 2.7.4: GPL-2.0-or-later → GPL-3.0-only\n- `bash` 5.2: GPL-3.0-or-later → LicenseRef-proprietary\n") {
+		t.Errorf("unexpected license changes in Markdown changelog:\n%s", markdown)
+	}
+	if text := render(t, r, Text, relicensed); !strings.Contains(text, "License changes (2):\n  apt 2.7.3 -> 2.7.4: GPL-2.0-or-later -> GPL-3.0-only\n") {
+		t.Errorf("unexpected license changes in plain text changelog:\n%s", text)
+	}
+
+	vulnerable := newTestChangelog(false)
+	vulnerable.Vulnerabilities = &types.VulnerabilityChanges{
+		Fixed:      []types.VulnerabilityMatch{{ID: "CVE-2024-0001", Severity: "high", Package: "apt", Version: "2.7.3", Summary: "Signature bypass"}},
+		Introduced: []types.VulnerabilityMatch{},
+	}
+	markdown := render(t, r, Markdown, vulnerable)
+	if !strings.HasSuffix(markdown, "\n\n### Fixed vulnerabilities (1)\n\n- **CVE-2024-0001** (high) in `apt` 2.7.3: Signature bypass\n") {
+		t.Errorf("unexpected vulnerabilities in Markdown changelog:\n%s", markdown)
+	}
+	if html := render(t, r, HTML, vulnerable); !strings.Contains(html, "<li><strong>CVE-2024-0001</strong> (high) in <code>apt</code> 2.7.3: Signature bypass</li>") || strings.Contains(html, "Introduced") {
+		t.Errorf("unexpected vulnerabilities in HTML changelog:\n%s", html)
+	}
+}
+
+func TestRendererOverride(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "text.tmpl"), []byte("{{.Image}}: {{.Total}} changes"), 0o644)
+
+	r, err := NewRenderer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := render(t, r, Text, newTestChangelog(false)); text != "pico: 4 changes" {
+		t.Errorf("override was not used, got %q", text)
+	}
+	if markdown := render(t, r, Markdown, newTestChangelog(false)); !strings.HasPrefix(markdown, "## pico 2.0") {
+		t.Errorf("default template was not kept, got %q", markdown)
+	}
+
+	os.WriteFile(filepath.Join(dir, "html.tmpl"), []byte("{{.Image"), 0o644)
+	if _, err := NewRenderer(dir); err == nil {
+		t.Error("invalid template should be rejected")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range Formats {
+		if parsed, err := ParseFormat(string(format)); err != nil || parsed != format {
+			t.Errorf("ParseFormat(%q) returned %q (%v)", format, parsed, err)
+		}
+	}
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Error("unknown format should be rejected")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a node of the CLI command tree. Leaf commands have a run
+// function, while the others only dispatch to their subcommands.
+type command struct {
+	name        string
+	usage       string
+	summary     string
+	run         func(args []string) error
+	subcommands []*command
+}
+
+// errUsage is returned by commands called with wrong arguments, after their
+// usage has been printed. Unlike flag.ErrHelp, it makes the program fail.
+var errUsage = errors.New("wrong usage")
+
+func (cmd *command) find(name string) *command {
+	for _, sub := range cmd.subcommands {
+		if sub.name == name {
+			return sub
+		}
+	}
+
+	return nil
+}
+
+func (cmd *command) printUsage(path string) {
+	if cmd.usage != "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s\n", path, cmd.usage)
+	} else {
+		fmt.Fprintf(os.Stderr, "Usage: %s <command> [arguments]\n", path)
+	}
+	if cmd.summary != "" {
+		fmt.Fprintf(os.Stderr, "\n%s\n", cmd.summary)
+	}
+
+	if len(cmd.subcommands) > 0 {
+		fmt.Fprintln(os.Stderr, "\nCommands:")
+		w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+		for _, sub := range cmd.subcommands {
+			fmt.Fprintf(w, "  %s\t%s\n", sub.name, sub.summary)
+		}
+		w.Flush()
+	}
+}
+
+// execute runs the command selected by args, path being the names of the
+// commands already consumed.
+func (cmd *command) execute(path string, args []string) error {
+	if len(cmd.subcommands) > 0 {
+		if len(args) == 0 {
+			cmd.printUsage(path)
+			return errUsage
+		}
+		if args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+			cmd.printUsage(path)
+			return flag.ErrHelp
+		}
+
+		sub := cmd.find(args[0])
+		if sub == nil {
+			cmd.printUsage(path)
+			return fmt.Errorf("unknown command %q", strings.Join([]string{path, args[0]}, " "))
+		}
+
+		return sub.execute(path+" "+sub.name, args[1:])
+	}
+
+	return cmd.run(args)
+}
+
+// newFlagSet returns a flag set whose usage describes cmd.
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s\n\nFlags:\n", name, usage)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parseArgs parses args into fs, allowing flags after positional arguments,
+// and asserts the number of positional arguments is exactly want.
+func parseArgs(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if want >= 0 && len(positional) != want {
+		fs.Usage()
+		return nil, errUsage
+	}
+
+	return positional, nil
+}
+
+// printJSON writes v to w as indented JSON.
+func printJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,173 @@
+package main
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vanilla-os/differ/client"
+	"github.com/vanilla-os/differ/core"
+	"github.com/vanilla-os/differ/diff"
+	"github.com/vanilla-os/differ/types"
+)
+
+func TestIsLegacyServeArg(t *testing.T) {
+	cases := map[string]bool{
+		"serve":                 false,
+		"image":                 false,
+		"help":                  false,
+		"bogus":                 false,
+		"-config":               true,
+		"differ.db":             true,
+		"/var/lib/differ.db":    true,
+		"postgres://localhost/": true,
+	}
+
+	for arg, expected := range cases {
+		if isLegacyServeArg(arg) != expected {
+			t.Errorf("isLegacyServeArg(%q) should be %t", arg, expected)
+		}
+	}
+}
+
+func TestCLILocal(t *testing.T) {
+	dir := t.TempDir()
+	dsn := filepath.Join(dir, "cli.db")
+	packages := filepath.Join(dir, "packages.json")
+	os.WriteFile(packages, []byte(`[{"name":"apt","version":"2.7.3","installed_size":4194304}]`), 0o644)
+	notes := filepath.Join(dir, "notes.md")
+	os.WriteFile(notes, []byte("# 1.0\n\nFirst release.\n"), 0o644)
+	sbom := filepath.Join(dir, "sbom.json")
+	os.WriteFile(sbom, []byte(`{"bomFormat":"CycloneDX","components":[{"name":"apt","version":"2.7.4","purl":"pkg:deb/debian/apt@2.7.4","licenses":[{"expression":"GPL-2.0-or-later"}]}]}`), 0o644)
+	tracker := filepath.Join(dir, "tracker.json")
+	os.WriteFile(tracker, []byte(`{"apt":{"CVE-2024-0001":{"releases":{"bookworm":{"status":"open","urgency":"low"}}}}}`), 0o644)
+	manifests := filepath.Join(dir, "manifests.json")
+	os.WriteFile(manifests, []byte(`[{"package":"apt","files":[{"path":"/usr/bin/apt","mode":33261}]}]`), 0o644)
+	policy := filepath.Join(dir, "policy.yaml")
+	os.WriteFile(policy, []byte("required_packages: [bash]\n"), 0o644)
+
+	steps := [][]string{
+		{"db", "migrate", "-dsn", dsn},
+		{"user", "add", "admin", "-password", "admin", "-dsn", dsn},
+		{"image", "add", "cli", "https://example.com/cli", "-dsn", dsn},
+		{"release", "add", "cli", "sha256:a", "-packages", packages, "-date", "2024-01-01T00:00:00Z", "-version", "1.0", "-notes", notes, "-dsn", dsn},
+		{"release", "latest", "cli", "-dsn", dsn, "-json"},
+		{"release", "show", "cli", "sha256:a", "-dsn", dsn},
+		{"vuln", "import", "-format", "debian", "-release", "bookworm", tracker, "-dsn", dsn},
+		{"release", "add", "cli", "sha256:sbom", "-packages", sbom, "-dsn", dsn},
+		{"diff", "cli", "sha256:a", "latest", "-dsn", dsn},
+		{"diff", "cli", "sha256:a", "latest", "-explain", "-dsn", dsn},
+		{"diff", "cli", "sha256:a", "latest", "-sort", "size", "-dsn", dsn},
+		{"diff", "cli", "sha256:a", "latest", "-hide", "revision,rebuild", "-dsn", dsn},
+		{"release", "rdepends", "cli", "latest", "apt", "-dsn", dsn},
+		{"release", "files", "cli", "sha256:a", "-manifests", manifests, "-dsn", dsn},
+		{"release", "files", "cli", "sha256:sbom", "-manifests", manifests, "-dsn", dsn},
+		{"diff", "cli", "sha256:a", "latest", "-files", "-prefix", "/usr/", "-dsn", dsn},
+		{"image", "policy", "cli", policy, "-dsn", dsn},
+		{"image", "show", "cli", "-dsn", dsn},
+		{"release", "add", "cli", "sha256:override", "-packages", packages, "-override", "-dsn", dsn},
+	}
+	for _, args := range steps {
+		if err := commands.execute("differ", args); err != nil {
+			t.Fatalf("differ %v failed: %v", args, err)
+		}
+	}
+
+	failing := [][]string{
+		{"user", "add", "admin", "-password", "admin", "-dsn", dsn},
+		{"release", "show", "cli", "sha256:missing", "-dsn", dsn},
+		{"diff", "cli", "sha256:a", "-dsn", dsn},
+		{"diff", "cli", "sha256:a", "latest", "-sort", "weight", "-dsn", dsn},
+		{"diff", "cli", "sha256:a", "latest", "-hide", "security", "-dsn", dsn},
+		{"release", "rdepends", "cli", "latest", "missing", "-dsn", dsn},
+		{"release", "files", "cli", "sha256:a", "-dsn", dsn},
+		{"release", "files", "cli", "sha256:a", "-manifests", manifests, "-root", dir, "-dsn", dsn},
+		{"vuln", "import", "-release", "bookworm", tracker, "-dsn", dsn},
+		{"release", "add", "cli", "sha256:b", "-packages", packages, "-version", "1.0", "-dsn", dsn},
+		{"release", "add", "cli", "sha256:b", "-packages", packages, "-notes", filepath.Join(dir, "missing.md"), "-dsn", dsn},
+		{"release", "add", "cli", "sha256:b", "-packages", packages, "-dsn", dsn},
+		{"image", "policy", "cli", packages, "-dsn", dsn},
+	}
+	for _, args := range failing {
+		if err := commands.execute("differ", args); err == nil {
+			t.Fatalf("differ %v should have failed", args)
+		}
+	}
+
+	// Local edits are validated like those made through the server
+	db, err := core.OpenStorage(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &localBackend{db: db, comparators: diff.DefaultComparators()}
+	comparator := "bogus"
+	if _, err := local.UpdateImage("cli", types.ImageUpdate{Comparator: &comparator}); err == nil {
+		t.Error("expected an unknown comparator to be refused")
+	}
+
+	// Asking for help succeeds, while a wrong usage fails
+	if err := commands.execute("differ", []string{"image", "-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected help to be reported as flag.ErrHelp, got %v", err)
+	}
+	for _, args := range [][]string{{"image"}, {"image", "add"}} {
+		if err := commands.execute("differ", args); !errors.Is(err, errUsage) {
+			t.Errorf("expected differ %v to be a wrong usage, got %v", args, err)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	t.Parallel()
+	_, router := newTestServer(t)
+	httpServer := httptest.NewServer(router)
+	defer httpServer.Close()
+
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "var/lib/dpkg"), 0o755)
+	os.MkdirAll(filepath.Join(root, "usr/lib/modules/6.8.0-1"), 0o755)
+	os.WriteFile(filepath.Join(root, "var/lib/dpkg/status"), []byte("Package: apt\nStatus: install ok installed\nArchitecture: amd64\nVersion: 2.7.3\n"), 0o644)
+
+	auth := []string{"-url", httpServer.URL, "-user", "admin", "-password", "admin"}
+	steps := [][]string{
+		append([]string{"image", "add", "publish", "https://example.com/publish"}, auth...),
+		{"publish", "publish", "-root", root, "-dry-run"},
+		append([]string{"publish", "publish", "-root", root, "-digest", "sha256:a", "-channel", "stable", "-version", "1.0"}, auth...),
+	}
+	for _, args := range steps {
+		if err := commands.execute("differ", args); err != nil {
+			t.Fatalf("differ %v failed: %v", args, err)
+		}
+	}
+
+	c, _ := client.New(httpServer.URL)
+	release, err := c.GetChannelRelease(context.Background(), "publish", "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.Digest != "sha256:a" || release.Version != "1.0" || release.Kernel != "6.8.0-1" || len(release.Packages) != 1 || release.Packages[0].Arch != "amd64" {
+		t.Errorf("unexpected published release %+v", release)
+	}
+
+	failing := [][]string{
+		{"publish", "publish", "-root", root},
+		append([]string{"publish", "publish", "-root", t.TempDir()}, auth...),
+		append([]string{"publish", "publish", "-root", root, "-manager", "rpm"}, auth...),
+		append([]string{"publish", "publish", "-root", root, "-channel", "sha256:a"}, auth...),
+	}
+	for _, args := range failing {
+		if err := commands.execute("differ", args); err == nil {
+			t.Fatalf("differ %v should have failed", args)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package client is a Go client for the Differ API.
+//
+// Every endpoint of the versioned API is wrapped by a method of Client taking
+// a context, which cancels the request and any pending retry when done.
+// Responses reuse the types of the server, namely types.Image, types.Release
+// and the bodies of the api package.
+package client
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/types"
+)
+
+// Version is the version of the client, sent in the User-Agent header.
+const Version = "1.0.0"
+
+// APIError is returned when the server answers with a non-successful status.
+type APIError struct {
+	StatusCode int
+	Code       string // one of the api.Code constants
+	Message    string
+	// Violations lists the rules broken by a release refused by the
+	// publish policy of its image.
+	Violations []types.PolicyViolation
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("differ: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("differ: %s (%d)", e.Message, e.StatusCode)
+}
+
+// Client talks to a single Differ server. It is safe for concurrent use.
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	user         string
+	password     string
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// Option customizes a Client.
+type Option func(*Client)
+
+// WithBasicAuth authenticates every request, which is required by endpoints
+// that modify images and releases.
+func WithBasicAuth(user, password string) Option {
+	return func(c *Client) {
+		c.user, c.password = user, password
+	}
+}
+
+// WithHTTPClient replaces http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a request failing with a 5xx status or a
+// transport error is retried, waiting backoff before the first retry and
+// doubling the wait after each one. Defaults to 3 retries and 200ms. Only
+// idempotent requests are retried, as the server may have applied a failed
+// POST or PATCH, such as the creation of a release.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries, c.retryBackoff = maxRetries, backoff
+	}
+}
+
+// New returns a client for the Differ server at baseURL.
+func New(baseURL string, options ...Option) (*Client, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("differ: unsupported URL scheme %q", parsed.Scheme)
+	}
+
+	c := &Client{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		httpClient:   http.DefaultClient,
+		maxRetries:   3,
+		retryBackoff: 200 * time.Millisecond,
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	return c, nil
+}
+
+// idempotent reports whether sending a request with method several times has
+// the same effect as sending it once.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// do sends a request with an optional JSON body, retrying idempotent requests
+// on server errors, and decodes the JSON response into out if not nil. A
+// *[]byte out receives the response as is.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var content []byte
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	maxRetries := c.maxRetries
+	if !idempotent(method) {
+		maxRetries = 0
+	}
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, content)
+		retryable := err != nil || resp.StatusCode >= 500
+		if !retryable || attempt >= maxRetries || ctx.Err() != nil {
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			return decodeResponse(resp, out)
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, content []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "differ-client/"+Version)
+	if content != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	if resp.StatusCode >= 300 {
+		apiError := &APIError{StatusCode: resp.StatusCode}
+		var errorBody struct {
+			Error api.Error `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&errorBody) == nil {
+			apiError.Code, apiError.Message = errorBody.Error.Code, errorBody.Error.Message
+			apiError.Violations = errorBody.Error.Violations
+		}
+		return apiError
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		var err error
+		*raw, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("differ: invalid response: %v", err)
+	}
+	return nil
+}
+
+// imagePath returns the escaped path of an image endpoint.
+func imagePath(image string, elems ...string) string {
+	path := "/v1/images/" + url.PathEscape(image)
+	for _, elem := range elems {
+		path += "/" + url.PathEscape(elem)
+	}
+
+	return path
+}
+
+// webhookPath returns the escaped path of a webhook endpoint.
+func webhookPath(id uint, elems ...string) string {
+	path := "/v1/webhooks/" + strconv.FormatUint(uint64(id), 10)
+	for _, elem := range elems {
+		path += "/" + url.PathEscape(elem)
+	}
+
+	return path
+}
@@ -0,0 +1,103 @@
+package client
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data":{"status":"ok"}}`))
+	}))
+	defer server.Close()
+
+	c, _ := New(server.URL, WithRetries(3, time.Millisecond))
+	if err := c.Status(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls.Load())
+	}
+
+	calls.Store(0)
+	c, _ = New(server.URL, WithRetries(1, time.Millisecond))
+	var apiError *APIError
+	if err := c.Status(context.Background()); !errors.As(err, &apiError) || apiError.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected error after exhausting retries, got %v", err)
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"code":"not_found","message":"no image found with name x"}}`))
+	}))
+	defer server.Close()
+
+	c, _ := New(server.URL, WithRetries(3, time.Millisecond))
+	_, err := c.GetImage(context.Background(), "x")
+	var apiError *APIError
+	if !errors.As(err, &apiError) || apiError.Code != "not_found" || apiError.Message != "no image found with name x" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("client errors must not be retried, got %d attempts", calls.Load())
+	}
+}
+
+func TestNoRetryOnNonIdempotent(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	// The release may have been created before the failure
+	c, _ := New(server.URL, WithRetries(3, time.Millisecond))
+	var apiError *APIError
+	if _, err := c.AddRelease(context.Background(), "x", NewReleaseRequest{Digest: "sha256:a"}); !errors.As(err, &apiError) || apiError.StatusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("POST requests must not be retried, got %d attempts", calls.Load())
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	c, _ := New(server.URL, WithRetries(10, time.Second))
+	start := time.Now()
+	if err := c.Status(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("cancellation did not interrupt the retry backoff")
+	}
+}
@@ -0,0 +1,396 @@
+package client
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/changelog"
+	"github.com/vanilla-os/differ/types"
+)
+
+// Request and response bodies specific to the API, defined by the api
+// package.
+type (
+	NewImageRequest      = api.NewImage
+	NewReleaseRequest    = api.NewRelease
+	Diff                 = api.Diff
+	DiffOptions          = api.DiffOptions
+	GCResult             = api.GCResult
+	NewWebhookRequest    = api.NewWebhook
+	VulnerabilitySummary = api.VulnerabilitySummary
+	LicenseSummary       = api.LicenseSummary
+	ReverseDependencies  = api.ReverseDependencies
+	FilesSummary         = api.FilesSummary
+	FileDiff             = api.FileDiff
+)
+
+// envelope is the wrapper of every successful response.
+type envelope[T any] struct {
+	Data T `json:"data"`
+}
+
+// page is the wrapper of paginated listings.
+type page[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// withQuery appends the non-empty values of query to path.
+func withQuery(path string, query url.Values) string {
+	for key, values := range query {
+		if len(values) == 0 || values[0] == "" {
+			delete(query, key)
+		}
+	}
+	if len(query) == 0 {
+		return path
+	}
+
+	return path + "?" + query.Encode()
+}
+
+func pageValues(sort string, limit int, cursor string) url.Values {
+	query := url.Values{"sort": {sort}, "cursor": {cursor}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	return query
+}
+
+// Status checks whether the server is up.
+func (c *Client) Status(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/v1/status", nil, nil)
+}
+
+// ListImages returns every image, fetching as many pages as needed.
+func (c *Client) ListImages(ctx context.Context) ([]types.Image, error) {
+	images := []types.Image{}
+	query := types.ImageQuery{Limit: types.MaxPageSize}
+	for {
+		page, next, err := c.ListImagesPage(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, page...)
+		if next == "" {
+			return images, nil
+		}
+		query.Cursor = next
+	}
+}
+
+// ListImagesPage returns the page of images selected by query, and the
+// cursor of the next page, empty on the last one.
+func (c *Client) ListImagesPage(ctx context.Context, query types.ImageQuery) ([]types.Image, string, error) {
+	values := pageValues(query.Sort, query.Limit, query.Cursor)
+	values.Set("prefix", query.Prefix)
+
+	var resp page[types.Image]
+	err := c.do(ctx, http.MethodGet, withQuery("/v1/images", values), nil, &resp)
+	return resp.Data, resp.NextCursor, err
+}
+
+// GetImage returns an image, without its releases, which are listed by
+// ListReleases.
+func (c *Client) GetImage(ctx context.Context, name string) (*types.Image, error) {
+	var resp envelope[*types.Image]
+	err := c.do(ctx, http.MethodGet, imagePath(name), nil, &resp)
+	return resp.Data, err
+}
+
+func (c *Client) AddImage(ctx context.Context, image NewImageRequest) (*types.Image, error) {
+	var resp envelope[*types.Image]
+	err := c.do(ctx, http.MethodPost, "/v1/images", image, &resp)
+	return resp.Data, err
+}
+
+func (c *Client) UpdateImage(ctx context.Context, name string, update types.ImageUpdate) (*types.Image, error) {
+	var resp envelope[*types.Image]
+	err := c.do(ctx, http.MethodPatch, imagePath(name), update, &resp)
+	return resp.Data, err
+}
+
+// DeleteImage permanently deletes an image and all of its releases.
+func (c *Client) DeleteImage(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, imagePath(name), nil, nil)
+}
+
+// ListReleases returns the page of releases of an image selected by query,
+// without their packages, and the cursor of the next page, empty on the last
+// one.
+func (c *Client) ListReleases(ctx context.Context, image string, query types.ReleaseQuery) ([]types.Release, string, error) {
+	values := pageValues(query.Sort, query.Limit, query.Cursor)
+	if !query.Since.IsZero() {
+		values.Set("since", query.Since.Format(time.RFC3339Nano))
+	}
+	if !query.Until.IsZero() {
+		values.Set("until", query.Until.Format(time.RFC3339Nano))
+	}
+
+	var resp page[types.Release]
+	err := c.do(ctx, http.MethodGet, withQuery(imagePath(image, "releases"), values), nil, &resp)
+	return resp.Data, resp.NextCursor, err
+}
+
+// GetLatestRelease returns the most recent non-yanked release of an image,
+// failing with a 404 APIError if it has none.
+func (c *Client) GetLatestRelease(ctx context.Context, image string) (*types.Release, error) {
+	var resp envelope[*types.Release]
+	err := c.do(ctx, http.MethodGet, imagePath(image, "releases", types.LatestRef), nil, &resp)
+	return resp.Data, err
+}
+
+// GetChannelRelease returns the release of an image tagged channel, failing
+// with a 404 APIError if there is no such tag.
+func (c *Client) GetChannelRelease(ctx context.Context, image, channel string) (*types.Release, error) {
+	var resp envelope[*types.Release]
+	path := withQuery(imagePath(image, "releases", types.LatestRef), url.Values{"channel": {channel}})
+	err := c.do(ctx, http.MethodGet, path, nil, &resp)
+	return resp.Data, err
+}
+
+func (c *Client) GetRelease(ctx context.Context, image, digest string) (*types.Release, error) {
+	var resp envelope[*types.Release]
+	err := c.do(ctx, http.MethodGet, imagePath(image, "releases", digest), nil, &resp)
+	return resp.Data, err
+}
+
+// GetReleaseByVersion returns the release of an image with the given version
+// label.
+func (c *Client) GetReleaseByVersion(ctx context.Context, image, version string) (*types.Release, error) {
+	var resp envelope[*types.Release]
+	err := c.do(ctx, http.MethodGet, imagePath(image, "versions", version), nil, &resp)
+	return resp.Data, err
+}
+
+func (c *Client) AddRelease(ctx context.Context, image string, release NewReleaseRequest) (*types.Release, error) {
+	var resp envelope[*types.Release]
+	err := c.do(ctx, http.MethodPost, imagePath(image, "releases"), release, &resp)
+	return resp.Data, err
+}
+
+// UpdateRelease edits or yanks a release.
+func (c *Client) UpdateRelease(ctx context.Context, image, digest string, update types.ReleaseUpdate) (*types.Release, error) {
+	var resp envelope[*types.Release]
+	err := c.do(ctx, http.MethodPatch, imagePath(image, "releases", digest), update, &resp)
+	return resp.Data, err
+}
+
+// DeleteRelease permanently deletes a release.
+func (c *Client) DeleteRelease(ctx context.Context, image, digest string) error {
+	return c.do(ctx, http.MethodDelete, imagePath(image, "releases", digest), nil, nil)
+}
+
+// ReleaseVulnerabilities returns the known vulnerabilities affecting the
+// packages of a release, given by digest, tag or as types.LatestRef.
+func (c *Client) ReleaseVulnerabilities(ctx context.Context, image, ref string) (*VulnerabilitySummary, error) {
+	var resp envelope[*VulnerabilitySummary]
+	err := c.do(ctx, http.MethodGet, imagePath(image, "releases", ref, "vulnerabilities"), nil, &resp)
+	return resp.Data, err
+}
+
+// ReleaseLicenses returns how many packages of a release are under each
+// license, the release being given by digest, tag or as types.LatestRef.
+func (c *Client) ReleaseLicenses(ctx context.Context, image, ref string) (*LicenseSummary, error) {
+	var resp envelope[*LicenseSummary]
+	err := c.do(ctx, http.MethodGet, imagePath(image, "releases", ref, "licenses"), nil, &resp)
+	return resp.Data, err
+}
+
+// Diff returns the package changes between two releases of an image, each
+// given by digest, tag or as types.LatestRef.
+func (c *Client) Diff(ctx context.Context, image, oldRef, newRef string) (*Diff, error) {
+	return c.DiffWith(ctx, image, oldRef, newRef, DiffOptions{})
+}
+
+// ExplainDiff is Diff, along with the dependency chains that pulled in the
+// added packages.
+func (c *Client) ExplainDiff(ctx context.Context, image, oldRef, newRef string) (*Diff, error) {
+	return c.DiffWith(ctx, image, oldRef, newRef, DiffOptions{Explain: true})
+}
+
+// DiffWith is Diff, sorted, filtered and explained as selected by options.
+func (c *Client) DiffWith(ctx context.Context, image, oldRef, newRef string, options DiffOptions) (*Diff, error) {
+	query := url.Values{"from": {oldRef}, "to": {newRef}, "sort": {options.Sort}}
+	if options.Explain {
+		query.Set("explain", "true")
+	}
+	hide := make([]string, 0, len(options.Hide))
+	for _, change := range options.Hide {
+		hide = append(hide, string(change))
+	}
+	query.Set("hide", strings.Join(hide, ","))
+
+	var resp envelope[*Diff]
+	err := c.do(ctx, http.MethodGet, withQuery(imagePath(image, "diff"), query), nil, &resp)
+	return resp.Data, err
+}
+
+// ReverseDependencies returns the packages of a release depending on the
+// package with the given name.
+func (c *Client) ReverseDependencies(ctx context.Context, image, ref, pkg string) (*ReverseDependencies, error) {
+	var resp envelope[*ReverseDependencies]
+	err := c.do(ctx, http.MethodGet, imagePath(image, "releases", ref, "packages", pkg, "reverse-dependencies"), nil, &resp)
+	return resp.Data, err
+}
+
+// SetReleaseFiles replaces the file manifests of a release, by package.
+func (c *Client) SetReleaseFiles(ctx context.Context, image, digest string, manifests []types.PackageFiles) (*FilesSummary, error) {
+	var resp envelope[*FilesSummary]
+	err := c.do(ctx, http.MethodPut, imagePath(image, "releases", digest, "files"), api.ReleaseFiles{Manifests: manifests}, &resp)
+	return resp.Data, err
+}
+
+// DiffFiles returns the files added, removed and modified between two
+// releases of an image, keeping those whose path starts with prefix.
+func (c *Client) DiffFiles(ctx context.Context, image, oldRef, newRef, prefix string) (*FileDiff, error) {
+	query := url.Values{"from": {oldRef}, "to": {newRef}, "prefix": {prefix}}
+	var resp envelope[*FileDiff]
+	err := c.do(ctx, http.MethodGet, withQuery(imagePath(image, "diff", "files"), query), nil, &resp)
+	return resp.Data, err
+}
+
+// ListTags returns the tags of an image, sorted by name.
+func (c *Client) ListTags(ctx context.Context, image string) ([]types.Tag, error) {
+	var resp envelope[[]types.Tag]
+	err := c.do(ctx, http.MethodGet, imagePath(image, "tags"), nil, &resp)
+	return resp.Data, err
+}
+
+func (c *Client) GetTag(ctx context.Context, image, tag string) (*types.Tag, error) {
+	var resp envelope[*types.Tag]
+	err := c.do(ctx, http.MethodGet, imagePath(image, "tags", tag), nil, &resp)
+	return resp.Data, err
+}
+
+// SetTag points a tag of an image at the release with the given digest,
+// creating the tag if needed.
+func (c *Client) SetTag(ctx context.Context, image, tag, digest string) (*types.Tag, error) {
+	var resp envelope[*types.Tag]
+	err := c.do(ctx, http.MethodPut, imagePath(image, "tags", tag), api.SetTag{Digest: digest}, &resp)
+	return resp.Data, err
+}
+
+// DeleteTag removes a tag of an image, keeping its history.
+func (c *Client) DeleteTag(ctx context.Context, image, tag string) error {
+	return c.do(ctx, http.MethodDelete, imagePath(image, "tags", tag), nil, nil)
+}
+
+// TagHistory returns the past assignments of a tag, from newest to oldest.
+func (c *Client) TagHistory(ctx context.Context, image, tag string) ([]types.TagAssignment, error) {
+	var resp envelope[[]types.TagAssignment]
+	err := c.do(ctx, http.MethodGet, imagePath(image, "tags", tag, "history"), nil, &resp)
+	return resp.Data, err
+}
+
+// Changelog returns the diff between two releases of an image, given as for
+// Diff, rendered by the server in format. Packages whose epoch or major
+// version changed are highlighted when highlight is set.
+func (c *Client) Changelog(ctx context.Context, image, oldRef, newRef string, format changelog.Format, highlight bool) (string, error) {
+	query := url.Values{"from": {oldRef}, "to": {newRef}, "format": {string(format)}}
+	if highlight {
+		query.Set("highlight", "major")
+	}
+
+	var out []byte
+	err := c.do(ctx, http.MethodGet, imagePath(image, "diff")+"?"+query.Encode(), nil, &out)
+	return string(out), err
+}
+
+// SearchPackages returns the page of packages shipped by releases matching
+// query, and the cursor of the next page, empty on the last one.
+func (c *Client) SearchPackages(ctx context.Context, query types.PackageQuery) ([]types.PackageMatch, string, error) {
+	values := pageValues("", query.Limit, query.Cursor)
+	values.Set("name", query.Name)
+	values.Set("match", query.Match)
+	values.Set("version", query.Version)
+	values.Set("arch", query.Arch)
+	values.Set("image", query.Image)
+
+	var resp page[types.PackageMatch]
+	err := c.do(ctx, http.MethodGet, withQuery("/v1/packages", values), nil, &resp)
+	return resp.Data, resp.NextCursor, err
+}
+
+// PackageHistory returns the versions of a package shipped by the non-yanked
+// releases of an image, from oldest to newest. An empty arch reports every
+// architecture.
+func (c *Client) PackageHistory(ctx context.Context, image, pkg, arch string) ([]types.VersionSpan, error) {
+	var resp envelope[[]types.VersionSpan]
+	path := withQuery(imagePath(image, "packages", pkg, "history"), url.Values{"arch": {arch}})
+	err := c.do(ctx, http.MethodGet, path, nil, &resp)
+	return resp.Data, err
+}
+
+// RunGC enforces the retention policies of every image.
+func (c *Client) RunGC(ctx context.Context) (*GCResult, error) {
+	var resp envelope[*GCResult]
+	err := c.do(ctx, http.MethodPost, "/v1/gc", nil, &resp)
+	return resp.Data, err
+}
+
+// ListWebhooks returns every webhook, oldest first.
+func (c *Client) ListWebhooks(ctx context.Context) ([]types.Webhook, error) {
+	var resp envelope[[]types.Webhook]
+	err := c.do(ctx, http.MethodGet, "/v1/webhooks", nil, &resp)
+	return resp.Data, err
+}
+
+// CreateWebhook registers a webhook, notified of the events of every image
+// unless hook names one.
+func (c *Client) CreateWebhook(ctx context.Context, hook NewWebhookRequest) (*types.Webhook, error) {
+	var resp envelope[*types.Webhook]
+	err := c.do(ctx, http.MethodPost, "/v1/webhooks", hook, &resp)
+	return resp.Data, err
+}
+
+func (c *Client) GetWebhook(ctx context.Context, id uint) (*types.Webhook, error) {
+	var resp envelope[*types.Webhook]
+	err := c.do(ctx, http.MethodGet, webhookPath(id), nil, &resp)
+	return resp.Data, err
+}
+
+// DeleteWebhook removes a webhook and its deliveries.
+func (c *Client) DeleteWebhook(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, webhookPath(id), nil, nil)
+}
+
+// ListWebhookDeliveries returns the page of deliveries to a webhook selected
+// by query, newest first and without their payloads, and the cursor of the
+// next page, empty on the last one.
+func (c *Client) ListWebhookDeliveries(ctx context.Context, id uint, query types.DeliveryQuery) ([]types.WebhookDelivery, string, error) {
+	values := pageValues("", query.Limit, query.Cursor)
+
+	var resp page[types.WebhookDelivery]
+	err := c.do(ctx, http.MethodGet, withQuery(webhookPath(id, "deliveries"), values), nil, &resp)
+	return resp.Data, resp.NextCursor, err
+}
+
+// GetWebhookDelivery returns a delivery to a webhook, with its payload.
+func (c *Client) GetWebhookDelivery(ctx context.Context, id, deliveryID uint) (*types.WebhookDelivery, error) {
+	var resp envelope[*types.WebhookDelivery]
+	err := c.do(ctx, http.MethodGet, webhookPath(id, "deliveries", strconv.FormatUint(uint64(deliveryID), 10)), nil, &resp)
+	return resp.Data, err
+}
+
+// Redeliver sends the payload of a past delivery to its webhook again, and
+// returns the new delivery, still pending.
+func (c *Client) Redeliver(ctx context.Context, id, deliveryID uint) (*types.WebhookDelivery, error) {
+	var resp envelope[*types.WebhookDelivery]
+	path := webhookPath(id, "deliveries", strconv.FormatUint(uint64(deliveryID), 10), "redeliver")
+	err := c.do(ctx, http.MethodPost, path, nil, &resp)
+	return resp.Data, err
+}
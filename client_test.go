@@ -0,0 +1,231 @@
+package main
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/changelog"
+	"github.com/vanilla-os/differ/client"
+	"github.com/vanilla-os/differ/diff"
+	"github.com/vanilla-os/differ/types"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+	_, router := newTestServer(t)
+	httpServer := httptest.NewServer(router)
+	defer httpServer.Close()
+
+	ctx := context.Background()
+	c, err := client.New(httpServer.URL, client.WithBasicAuth("admin", "admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Status(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Webhooks are delivered to a local receiver
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+	hook, err := c.CreateWebhook(ctx, client.NewWebhookRequest{URL: receiver.URL, Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hooks, err := c.ListWebhooks(ctx); err != nil || len(hooks) != 1 {
+		t.Fatalf("unexpected webhooks %v (%v)", hooks, err)
+	}
+	if got, err := c.GetWebhook(ctx, hook.ID); err != nil || got.URL != hook.URL {
+		t.Fatalf("unexpected webhook %v (%v)", got, err)
+	}
+
+	if _, err := c.AddImage(ctx, client.NewImageRequest{Name: "client", URL: "https://example.com/client"}); err != nil {
+		t.Fatal(err)
+	}
+	releases := []client.NewReleaseRequest{
+		{Digest: "sha256:a", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Packages: []types.Package{{Name: "apt", Version: "2.7.3", InstalledSize: 4000}}},
+		{Digest: "sha256:b", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), ReleaseMetadata: types.ReleaseMetadata{Version: "1.1", Notes: "Upgrades apt"}, Packages: []types.Package{
+			{Name: "apt", Version: "2.7.4", InstalledSize: 4100, Depends: []string{"libzstd1 (>= 1.5)"}},
+			{Name: "libzstd1", Version: "1.5.5", InstalledSize: 800},
+		}},
+	}
+	for _, release := range releases {
+		if _, err := c.AddRelease(ctx, "client", release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	images, err := c.ListImages(ctx)
+	if err != nil || len(images) != 1 {
+		t.Fatalf("expected one image, got %v (%v)", images, err)
+	}
+	if _, err := c.GetImage(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+	page, next, err := c.ListReleases(ctx, "client", types.ReleaseQuery{Limit: 1})
+	if err != nil || len(page) != 1 || page[0].Digest != "sha256:b" || next == "" {
+		t.Fatalf("unexpected first page of releases %v (%v)", page, err)
+	}
+	page, next, err = c.ListReleases(ctx, "client", types.ReleaseQuery{Limit: 1, Cursor: next})
+	if err != nil || len(page) != 1 || page[0].Digest != "sha256:a" || next != "" {
+		t.Fatalf("unexpected last page of releases %v (%v)", page, err)
+	}
+
+	latest, err := c.GetLatestRelease(ctx, "client")
+	if err != nil || latest.Digest != "sha256:b" {
+		t.Fatalf("unexpected latest release %v (%v)", latest, err)
+	}
+	if byVersion, err := c.GetReleaseByVersion(ctx, "client", "1.1"); err != nil || byVersion.Digest != "sha256:b" || byVersion.Notes != "Upgrades apt" {
+		t.Fatalf("unexpected release for version 1.1 %v (%v)", byVersion, err)
+	}
+	release, err := c.GetRelease(ctx, "client", "sha256:a")
+	if err != nil || len(release.Packages) != 1 || release.InstalledSize != 4000 {
+		t.Fatalf("unexpected release %v (%v)", release, err)
+	}
+
+	// Diffs are decoded the same way whether they come from the cache or not
+	for range 2 {
+		result, err := c.Diff(ctx, "client", "sha256:a", types.LatestRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Upgraded) != 1 || result.Upgraded[0].NewVersion != "2.7.4" || result.Upgraded[0].Change != diff.ChangePatch || result.NewDigest != "sha256:b" || result.Size.Delta != 900 || len(result.Size.Packages) != 2 {
+			t.Fatalf("unexpected diff %+v", result)
+		}
+	}
+
+	options := client.DiffOptions{Sort: api.SortSize, Hide: []diff.Change{diff.ChangePatch}, Explain: true}
+	if result, err := c.DiffWith(ctx, "client", "sha256:a", "sha256:b", options); err != nil || len(result.Upgraded) != 0 || result.PulledIn == nil {
+		t.Fatalf("unexpected diff with options %+v (%v)", result, err)
+	}
+
+	// Hiding rebuilds keeps those fixing vulnerabilities
+	rebuilds := client.Diff{
+		Upgraded:        []diff.PackageDiff{{Name: "apt", Change: diff.ChangeRebuild}, {Name: "bash", Change: diff.ChangeRebuild}, {Name: "curl", Change: diff.ChangeMinor}},
+		Vulnerabilities: &types.VulnerabilityChanges{Fixed: []types.VulnerabilityMatch{{ID: "CVE-2024-0001", Package: "apt"}}},
+	}
+	rebuilds.MarkSecurityFixes()
+	rebuilds.Hide([]diff.Change{diff.ChangeRebuild})
+	if len(rebuilds.Upgraded) != 2 || !rebuilds.Upgraded[0].Security || rebuilds.Upgraded[1].Name != "curl" {
+		t.Fatalf("unexpected packages left %+v", rebuilds.Upgraded)
+	}
+
+	if _, err := c.SetTag(ctx, "client", "stable", "sha256:a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SetTag(ctx, "client", "stable", "sha256:b"); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := c.ListTags(ctx, "client"); err != nil || len(tags) != 1 || tags[0].Digest != "sha256:b" {
+		t.Fatalf("unexpected tags %v (%v)", tags, err)
+	}
+	if tag, err := c.GetTag(ctx, "client", "stable"); err != nil || tag.Digest != "sha256:b" {
+		t.Fatalf("unexpected tag %v (%v)", tag, err)
+	}
+	if stable, err := c.GetChannelRelease(ctx, "client", "stable"); err != nil || stable.Digest != "sha256:b" {
+		t.Fatalf("unexpected stable release %v (%v)", stable, err)
+	}
+	if result, err := c.Diff(ctx, "client", "sha256:a", "stable"); err != nil || len(result.Upgraded) != 1 {
+		t.Fatalf("unexpected diff against tag %v (%v)", result, err)
+	}
+	if err := c.DeleteTag(ctx, "client", "stable"); err != nil {
+		t.Fatal(err)
+	}
+	if history, err := c.TagHistory(ctx, "client", "stable"); err != nil || len(history) != 3 || history[0].Previous != "sha256:b" {
+		t.Fatalf("unexpected tag history %v (%v)", history, err)
+	}
+
+	matches, _, err := c.SearchPackages(ctx, types.PackageQuery{Name: "ap", Match: types.MatchPrefix})
+	if err != nil || len(matches) != 2 || len(matches[0].Releases) != 1 {
+		t.Fatalf("unexpected search results %v (%v)", matches, err)
+	}
+	history, err := c.PackageHistory(ctx, "client", "apt", "")
+	if err != nil || len(history) != 2 || history[1].Version != "2.7.4" {
+		t.Fatalf("unexpected package history %v (%v)", history, err)
+	}
+
+	if summary, err := c.ReleaseLicenses(ctx, "client", "sha256:a"); err != nil || summary.Packages != 1 || summary.Licenses[0].License != types.NoAssertion {
+		t.Fatalf("unexpected licenses %+v (%v)", summary, err)
+	}
+	if result, err := c.ExplainDiff(ctx, "client", "sha256:a", "sha256:b"); err != nil || len(result.PulledIn) != 1 || strings.Join(result.PulledIn[0].Chain, " ") != "apt libzstd1" {
+		t.Fatalf("unexpected explained diff %+v (%v)", result, err)
+	}
+	if rdepends, err := c.ReverseDependencies(ctx, "client", "sha256:b", "libzstd1"); err != nil || len(rdepends.Dependents) != 1 || rdepends.Dependents[0].Name != "apt" {
+		t.Fatalf("unexpected reverse dependencies %+v (%v)", rdepends, err)
+	}
+	for _, digest := range []string{"sha256:a", "sha256:b"} {
+		manifests := []types.PackageFiles{{Package: "apt", Files: []types.FileEntry{{Path: "/usr/bin/apt", Mode: types.ModeRegular | 0o755, SHA256: strings.Repeat(strings.TrimPrefix(digest, "sha256:"), 64)}}}}
+		if summary, err := c.SetReleaseFiles(ctx, "client", digest, manifests); err != nil || summary.Files != 1 {
+			t.Fatalf("unexpected files summary %+v (%v)", summary, err)
+		}
+	}
+	if result, err := c.DiffFiles(ctx, "client", "sha256:a", "sha256:b", "/usr/"); err != nil || len(result.Modified) != 1 || result.Prefix != "/usr/" {
+		t.Fatalf("unexpected file diff %+v (%v)", result, err)
+	}
+	if summary, err := c.ReleaseVulnerabilities(ctx, "client", "sha256:a"); err != nil || summary.Database != nil || summary.Total != 0 {
+		t.Fatalf("unexpected vulnerabilities %+v (%v)", summary, err)
+	}
+
+	markdown, err := c.Changelog(ctx, "client", "sha256:a", "sha256:b", changelog.Markdown, true)
+	if err != nil || !strings.Contains(markdown, "### Upgraded (1)") {
+		t.Fatalf("unexpected changelog %q (%v)", markdown, err)
+	}
+
+	yanked := true
+	if _, err := c.UpdateRelease(ctx, "client", "sha256:b", types.ReleaseUpdate{Yanked: &yanked}); err != nil {
+		t.Fatal(err)
+	}
+	url := "https://example.com/client-new"
+	if image, err := c.UpdateImage(ctx, "client", types.ImageUpdate{URL: &url}); err != nil || image.URL != url {
+		t.Fatalf("image was not updated: %v (%v)", image, err)
+	}
+	policy := types.PublishPolicy{RequiredPackages: []string{"bash"}}
+	if _, err := c.UpdateImage(ctx, "client", types.ImageUpdate{Policy: &policy}); err != nil {
+		t.Fatal(err)
+	}
+	var policyError *client.APIError
+	if _, err := c.AddRelease(ctx, "client", client.NewReleaseRequest{Digest: "sha256:c", Packages: []types.Package{{Name: "apt", Version: "2.7.5"}}}); !errors.As(err, &policyError) || len(policyError.Violations) != 1 {
+		t.Fatalf("expected policy violation, got %v", err)
+	}
+	if _, err := c.RunGC(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteRelease(ctx, "client", "sha256:b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteImage(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.ListWebhookDeliveries(ctx, hook.ID, types.DeliveryQuery{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteWebhook(ctx, hook.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var apiError *client.APIError
+	if _, err := c.GetImage(ctx, "client"); !errors.As(err, &apiError) || apiError.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected API error for deleted image, got %v", err)
+	}
+
+	unauthenticated, _ := client.New(httpServer.URL)
+	if _, err := unauthenticated.AddImage(ctx, client.NewImageRequest{Name: "x", URL: "x"}); !errors.As(err, &apiError) || apiError.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vanilla-os/differ/core"
+	"github.com/vanilla-os/differ/types"
+	"github.com/vanilla-os/differ/vulndb"
+	"gorm.io/gorm"
+)
+
+var userCommand = &command{
+	name:    "user",
+	summary: "Manage users allowed to modify images and releases",
+	subcommands: []*command{
+		{name: "add", usage: "[flags] <name>", summary: "Add an authorized user", run: runUserAdd},
+	},
+}
+
+var dbCommand = &command{
+	name:    "db",
+	summary: "Manage the storage database",
+	subcommands: []*command{
+		{name: "migrate", usage: "[flags]", summary: "Create or upgrade the database schema", run: runDBMigrate},
+	},
+}
+
+var vulnCommand = &command{
+	name:    "vuln",
+	summary: "Manage the vulnerability database",
+	subcommands: []*command{
+		{name: "import", usage: "[flags] <file>", summary: "Replace the vulnerability database with an OSV or Debian Security Tracker dump", run: runVulnImport},
+	},
+}
+
+// bindStorageFlags registers the configuration flags on fs, returning a
+// function opening the configured database.
+func bindStorageFlags(fs *flag.FlagSet) func() (*gorm.DB, error) {
+	loadConfig := bindConfigFlags(fs)
+
+	return func() (*gorm.DB, error) {
+		config, err := loadConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration:\n%v", err)
+		}
+
+		return core.OpenStorage(config.Database.DSN)
+	}
+}
+
+func runUserAdd(args []string) error {
+	fs := newFlagSet("differ user add", "[flags] <name>")
+	password := fs.String("password", "", "password of the new user, read from stdin if not set")
+	openStorage := bindStorageFlags(fs)
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read password: %v", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if *password == "" {
+		return errors.New("password must not be empty")
+	}
+
+	db, err := openStorage()
+	if err != nil {
+		return err
+	}
+	if err := core.AddAuthorization(db, positional[0], *password); err != nil {
+		return err
+	}
+
+	fmt.Printf("Added user %s. Restart the server for the change to take effect.\n", positional[0])
+	return nil
+}
+
+func runDBMigrate(args []string) error {
+	fs := newFlagSet("differ db migrate", "[flags]")
+	openStorage := bindStorageFlags(fs)
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	// Migrations are run whenever the storage is opened
+	if _, err := openStorage(); err != nil {
+		return err
+	}
+
+	fmt.Println("Database schema is up to date.")
+	return nil
+}
+
+func runVulnImport(args []string) error {
+	fs := newFlagSet("differ vuln import", "[flags] <file>")
+	format := fs.String("format", string(vulndb.OSV), "format of the dump, osv or debian")
+	ecosystem := fs.String("ecosystem", "", "only import the OSV advisories of this ecosystem, such as Debian:12")
+	release := fs.String("release", "", "codename of the Debian release to import the status of, such as bookworm")
+	openStorage := bindStorageFlags(fs)
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	dumpFormat, err := vulndb.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	scope := *ecosystem
+	switch {
+	case dumpFormat == vulndb.Debian && *ecosystem != "":
+		return errors.New("-ecosystem only applies to OSV dumps, use -release")
+	case dumpFormat == vulndb.OSV && *release != "":
+		return errors.New("-release only applies to Debian dumps, use -ecosystem")
+	case dumpFormat == vulndb.Debian:
+		scope = *release
+	}
+
+	vulns, err := vulndb.Load(positional[0], dumpFormat, scope)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", positional[0], err)
+	}
+
+	db, err := openStorage()
+	if err != nil {
+		return err
+	}
+	info := types.VulnerabilityImport{Format: string(dumpFormat), Scope: scope, ImportedAt: time.Now().UTC()}
+	if err := types.ReplaceVulnerabilities(db, &info, vulns); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d vulnerabilities.\n", len(vulns))
+	return nil
+}
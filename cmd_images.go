@@ -0,0 +1,645 @@
+package main
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/diff"
+	"github.com/vanilla-os/differ/packages"
+	"github.com/vanilla-os/differ/types"
+	"gopkg.in/yaml.v3"
+)
+
+var imageCommand = &command{
+	name:    "image",
+	summary: "Manage images",
+	subcommands: []*command{
+		{name: "add", usage: "[flags] <name> <url>", summary: "Create an image", run: runImageAdd},
+		{name: "list", usage: "[flags]", summary: "List all images", run: runImageList},
+		{name: "show", usage: "[flags] <name>", summary: "Show an image and its releases", run: runImageShow},
+		{name: "policy", usage: "[flags] <name> <file>", summary: "Set the publish policy of an image from a YAML file", run: runImagePolicy},
+	},
+}
+
+var releaseCommand = &command{
+	name:    "release",
+	summary: "Manage releases",
+	subcommands: []*command{
+		{name: "add", usage: "[flags] <image> <digest>", summary: "Create a release from a package list", run: runReleaseAdd},
+		{name: "show", usage: "[flags] <image> <digest>", summary: "Show a release and its packages", run: runReleaseShow},
+		{name: "latest", usage: "[flags] <image>", summary: "Show the latest release of an image", run: runReleaseLatest},
+		{name: "rdepends", usage: "[flags] <image> <digest> <package>", summary: "List the packages of a release depending on a package", run: runReleaseRdepends},
+		{name: "files", usage: "[flags] <image> <digest>", summary: "Replace the file manifests of a release", run: runReleaseFiles},
+	},
+}
+
+var diffCommand = &command{
+	name:    "diff",
+	usage:   "[flags] <image> <from> <to>",
+	summary: "Show package changes between two releases, given by digest or \"latest\"",
+	run:     runDiff,
+}
+
+func runImageAdd(args []string) error {
+	fs := newFlagSet("differ image add", "[flags] <name> <url>")
+	comparator := fs.String("comparator", "", "version comparator used when diffing releases")
+	keepLast := fs.Int("keep-last", 0, "retention policy: keep the N most recent releases")
+	maxAgeDays := fs.Int("max-age-days", 0, "retention policy: keep releases newer than N days")
+	policyPath := fs.String("policy", "", "YAML `file` with the publish policy of the image, - for stdin")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	openBackend := bindBackendFlags(fs)
+	positional, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	var policy types.PublishPolicy
+	if *policyPath != "" {
+		if policy, err = readPolicy(*policyPath); err != nil {
+			return err
+		}
+	}
+
+	b, err := openBackend()
+	if err != nil {
+		return err
+	}
+	image, err := b.AddImage(&types.Image{
+		Name:       positional[0],
+		URL:        positional[1],
+		Comparator: *comparator,
+		Retention:  types.RetentionPolicy{KeepLast: *keepLast, MaxAgeDays: *maxAgeDays},
+		Policy:     policy,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, image)
+	}
+	fmt.Printf("Created image %s\n", image.Name)
+	return nil
+}
+
+func runImageList(args []string) error {
+	fs := newFlagSet("differ image list", "[flags]")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	openBackend := bindBackendFlags(fs)
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	b, err := openBackend()
+	if err != nil {
+		return err
+	}
+	images, err := b.ListImages()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, images)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tURL")
+	for _, image := range images {
+		fmt.Fprintf(w, "%s\t%s\n", image.Name, image.URL)
+	}
+	return w.Flush()
+}
+
+func runImageShow(args []string) error {
+	fs := newFlagSet("differ image show", "[flags] <name>")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	openBackend := bindBackendFlags(fs)
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	b, err := openBackend()
+	if err != nil {
+		return err
+	}
+	image, err := b.GetImage(positional[0])
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, image)
+	}
+	fmt.Printf("Name:\t%s\nURL:\t%s\n", image.Name, image.URL)
+	if image.Comparator != "" {
+		fmt.Printf("Comparator:\t%s\n", image.Comparator)
+	}
+	if image.Retention.Enabled() {
+		fmt.Printf("Retention:\tkeep last %d, max age %d days\n", image.Retention.KeepLast, image.Retention.MaxAgeDays)
+	}
+	if image.Policy.Enabled() {
+		policy, err := yaml.Marshal(image.Policy)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Publish policy:")
+		for _, line := range strings.Split(strings.TrimSuffix(string(policy), "\n"), "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DIGEST\tDATE\tYANKED")
+	for _, release := range image.Releases {
+		fmt.Fprintf(w, "%s\t%s\t%t\n", release.Digest, release.Date.Format(time.RFC3339), release.Yanked)
+	}
+	return w.Flush()
+}
+
+func runImagePolicy(args []string) error {
+	fs := newFlagSet("differ image policy", "[flags] <name> <file>")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	openBackend := bindBackendFlags(fs)
+	positional, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	policy, err := readPolicy(positional[1])
+	if err != nil {
+		return err
+	}
+
+	b, err := openBackend()
+	if err != nil {
+		return err
+	}
+	image, err := b.UpdateImage(positional[0], types.ImageUpdate{Policy: &policy})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, image)
+	}
+	if policy.Enabled() {
+		fmt.Printf("Set the publish policy of %s\n", image.Name)
+	} else {
+		fmt.Printf("Removed the publish policy of %s\n", image.Name)
+	}
+	return nil
+}
+
+// readPolicy reads a publish policy in YAML from the file at path, or from
+// stdin if path is "-". An empty file holds an empty policy.
+func readPolicy(path string) (types.PublishPolicy, error) {
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return types.PublishPolicy{}, err
+	}
+
+	return types.ParsePublishPolicy(content)
+}
+
+// readPackages reads a package list from the file at path, or from stdin if
+// path is "-". A plain JSON array, an object with a "packages" key, and SPDX
+// or CycloneDX SBOMs in JSON format are accepted.
+func readPackages(path string) ([]types.Package, error) {
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []types.Package
+	if err := json.Unmarshal(content, &pkgs); err == nil {
+		return pkgs, nil
+	}
+	if pkgs, err := packages.ReadSBOM(content); !errors.Is(err, packages.ErrNotSBOM) {
+		return pkgs, err
+	}
+
+	var release struct {
+		Packages []types.Package `json:"packages"`
+	}
+	if err := json.Unmarshal(content, &release); err != nil {
+		return nil, fmt.Errorf("failed to parse package list: %v", err)
+	}
+
+	return release.Packages, nil
+}
+
+// readManifests reads file manifests from a JSON file, or stdin for "-",
+// holding either a list of manifests or an object with a manifests field.
+func readManifests(path string) ([]types.PackageFiles, error) {
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []types.PackageFiles
+	if err := json.Unmarshal(content, &manifests); err == nil {
+		return manifests, nil
+	}
+	var files api.ReleaseFiles
+	if err := json.Unmarshal(content, &files); err != nil {
+		return nil, fmt.Errorf("failed to parse file manifests: %v", err)
+	}
+
+	return files.Manifests, nil
+}
+
+func runReleaseFiles(args []string) error {
+	fs := newFlagSet("differ release files", "[flags] <image> <digest>")
+	manifestsPath := fs.String("manifests", "", "JSON `file` with the file manifests, - for stdin")
+	root := fs.String("root", "", "root `directory` of a dpkg system to collect the file manifests from")
+	layout := fs.String("oci", "", "OCI image layout `directory` to collect the file manifests from")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	openBackend := bindBackendFlags(fs)
+	positional, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	var manifests []types.PackageFiles
+	switch {
+	case *manifestsPath != "" && *root == "" && *layout == "":
+		manifests, err = readManifests(*manifestsPath)
+	case *manifestsPath == "" && *root != "" && *layout == "":
+		manifests, err = packages.CollectFiles(*root)
+	case *manifestsPath == "" && *root == "" && *layout != "":
+		manifests, err = packages.ReadOCILayout(*layout)
+	default:
+		fs.Usage()
+		return errors.New("exactly one of -manifests, -root and -oci is required")
+	}
+	if err != nil {
+		return err
+	}
+
+	b, err := openBackend()
+	if err != nil {
+		return err
+	}
+	summary, err := b.SetReleaseFiles(positional[0], positional[1], manifests)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, summary)
+	}
+	fmt.Printf("Stored %d files of %d packages for %s\n", summary.Files, summary.Packages, summary.Digest)
+	return nil
+}
+
+func runReleaseAdd(args []string) error {
+	fs := newFlagSet("differ release add", "[flags] <image> <digest>")
+	packagesPath := fs.String("packages", "", "JSON `file` with the release packages, - for stdin (required)")
+	date := fs.String("date", "", "release date in RFC 3339 format, defaults to now")
+	override := fs.Bool("override", false, "publish the release even if it violates the publish policy of the image (admins only)")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	loadMetadata := bindMetadataFlags(fs)
+	openBackend := bindBackendFlags(fs)
+	positional, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	if *packagesPath == "" {
+		fs.Usage()
+		return errors.New("-packages is required")
+	}
+
+	release := types.Release{Digest: positional[1], Date: time.Now()}
+	if *date != "" {
+		if release.Date, err = time.Parse(time.RFC3339, *date); err != nil {
+			return fmt.Errorf("invalid date: %v", err)
+		}
+	}
+	if release.Packages, err = readPackages(*packagesPath); err != nil {
+		return err
+	}
+	if release.ReleaseMetadata, err = loadMetadata(); err != nil {
+		return err
+	}
+
+	b, err := openBackend()
+	if err != nil {
+		return err
+	}
+	newRelease, err := b.AddRelease(positional[0], &release, *override)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, newRelease)
+	}
+	fmt.Printf("Created release %s with %d packages\n", newRelease.Digest, len(newRelease.Packages))
+	return nil
+}
+
+func printRelease(release *types.Release) error {
+	fmt.Printf("Digest:\t%s\nDate:\t%s\n", release.Digest, release.Date.Format(time.RFC3339))
+	if release.Yanked {
+		fmt.Println("Yanked:\ttrue")
+	}
+	for _, field := range []struct{ name, value string }{
+		{"Version", release.Version},
+		{"Build ID", release.BuildID},
+		{"Git commit", release.GitCommit},
+		{"Kernel", release.Kernel},
+		{"Base snapshot", release.BaseSnapshot},
+	} {
+		if field.value != "" {
+			fmt.Printf("%s:\t%s\n", field.name, field.value)
+		}
+	}
+	if release.InstalledSize != 0 {
+		fmt.Printf("Installed size:\t%s\n", formatSize(release.InstalledSize))
+	}
+	if release.Notes != "" {
+		fmt.Printf("\n%s\n", strings.TrimSpace(release.Notes))
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tVERSION\tARCH\tSIZE")
+	for _, pkg := range release.Packages {
+		size := "-"
+		if pkg.InstalledSize != 0 {
+			size = formatSize(pkg.InstalledSize)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", pkg.Name, pkg.Version, pkg.Arch, size)
+	}
+	return w.Flush()
+}
+
+// showRelease prints the release of image referenced by ref.
+func showRelease(name string, args []string, positionalCount int, ref func(positional []string) string) error {
+	fs := newFlagSet(name, "[flags] <image>"+strings.Repeat(" <digest>", positionalCount-1))
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	openBackend := bindBackendFlags(fs)
+	positional, err := parseArgs(fs, args, positionalCount)
+	if err != nil {
+		return err
+	}
+
+	b, err := openBackend()
+	if err != nil {
+		return err
+	}
+	release, err := b.GetRelease(positional[0], ref(positional))
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, release)
+	}
+	return printRelease(release)
+}
+
+func runReleaseShow(args []string) error {
+	return showRelease("differ release show", args, 2, func(positional []string) string { return positional[1] })
+}
+
+func runReleaseLatest(args []string) error {
+	return showRelease("differ release latest", args, 1, func([]string) string { return types.LatestRef })
+}
+
+func runReleaseRdepends(args []string) error {
+	fs := newFlagSet("differ release rdepends", "[flags] <image> <digest> <package>")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	openBackend := bindBackendFlags(fs)
+	positional, err := parseArgs(fs, args, 3)
+	if err != nil {
+		return err
+	}
+
+	b, err := openBackend()
+	if err != nil {
+		return err
+	}
+	result, err := b.ReverseDependencies(positional[0], positional[1], positional[2])
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, result)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tRELATIONSHIP\tCLAUSE")
+	for _, dependent := range result.Dependents {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", dependent.Name, dependent.Version, dependent.Relationship, dependent.Clause)
+	}
+	return w.Flush()
+}
+
+// formatSize formats a number of bytes with binary units, such as 1.5 MiB.
+func formatSize(size int64) string {
+	value, unit := float64(size), 0
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	for max(value, -value) >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// formatSizeDelta formats a size change, always signed.
+func formatSizeDelta(delta int64) string {
+	if delta < 0 {
+		return formatSize(delta)
+	}
+
+	return "+" + formatSize(delta)
+}
+
+func printPackageDiffs[T any](title string, pkgs []T, format func(T) string) {
+	if len(pkgs) == 0 {
+		return
+	}
+
+	fmt.Printf("%s (%d):\n", title, len(pkgs))
+	for _, pkg := range pkgs {
+		fmt.Printf("  %s\n", format(pkg))
+	}
+}
+
+func runDiff(args []string) error {
+	fs := newFlagSet("differ diff", "[flags] <image> <from> <to>")
+	explain := fs.Bool("explain", false, "show the dependency chains that pulled in the added packages")
+	order := fs.String("sort", api.SortName, "sort the packages by name, or by size for the biggest size change first")
+	hide := fs.String("hide", "", "comma-separated version `changes` whose upgrades and downgrades are left out, unless they fix vulnerabilities, such as revision,rebuild")
+	files := fs.Bool("files", false, "show the files added, removed and modified instead of the packages, both releases having file manifests")
+	prefix := fs.String("prefix", "", "with -files, only show the files whose path starts with `prefix`, such as /etc/")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	openBackend := bindBackendFlags(fs)
+	positional, err := parseArgs(fs, args, 3)
+	if err != nil {
+		return err
+	}
+	if *order != api.SortName && *order != api.SortSize {
+		fs.Usage()
+		return fmt.Errorf("unsupported sort order %s", *order)
+	}
+	hidden, err := api.ParseChanges(*hide)
+	if err != nil {
+		fs.Usage()
+		return err
+	}
+
+	b, err := openBackend()
+	if err != nil {
+		return err
+	}
+	if *files {
+		return printFileDiff(b, positional, *prefix, *asJSON)
+	}
+	result, err := b.Diff(positional[0], positional[1], positional[2], api.DiffOptions{Sort: *order, Hide: hidden, Explain: *explain})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, result)
+	}
+	chains := map[string][]string{}
+	for _, pulledIn := range result.PulledIn {
+		chains[pulledIn.Name] = pulledIn.Chain
+	}
+	fmt.Printf("%s -> %s\n\n", result.OldDigest, result.NewDigest)
+	printPackageDiffs("Added", result.Added, func(pkg diff.PackageDiff) string {
+		if chain := chains[pkg.Name]; len(chain) > 1 {
+			return pkg.Name + " " + pkg.NewVersion + " (pulled in by " + strings.Join(chain, " -> ") + ")"
+		}
+		return pkg.Name + " " + pkg.NewVersion
+	})
+	printPackageDiffs("Upgraded", result.Upgraded, formatVersionChange)
+	printPackageDiffs("Downgraded", result.Downgraded, formatVersionChange)
+	printPackageDiffs("Removed", result.Removed, func(pkg diff.PackageDiff) string {
+		return pkg.Name + " " + pkg.PreviousVersion
+	})
+	printPackageDiffs("Relicensed", result.Relicensed, func(pkg types.LicenseChange) string {
+		return pkg.Name + " " + pkg.NewVersion + ": " + pkg.PreviousLicense + " -> " + pkg.NewLicense
+	})
+	printPackageDiffs("Size changes", result.Size.Packages, func(pkg types.SizeChange) string {
+		return pkg.Name + " " + formatSizeDelta(pkg.Delta)
+	})
+	if result.Vulnerabilities != nil {
+		printVulnerabilities("Fixed vulnerabilities", result.Vulnerabilities.Fixed)
+		printVulnerabilities("Introduced vulnerabilities", result.Vulnerabilities.Introduced)
+	}
+	if result.Size.Delta != 0 {
+		fmt.Printf("\nInstalled size: %s -> %s (%s)\n", formatSize(result.Size.PreviousTotal), formatSize(result.Size.NewTotal), formatSizeDelta(result.Size.Delta))
+	}
+	return nil
+}
+
+// formatVersionChange formats an upgraded or downgraded package along with
+// its kind of version change, and whether it fixes vulnerabilities.
+func formatVersionChange(pkg diff.PackageDiff) string {
+	kinds := []string{}
+	if pkg.Change != "" {
+		kinds = append(kinds, string(pkg.Change))
+	}
+	if pkg.Security {
+		kinds = append(kinds, "security")
+	}
+	line := pkg.Name + " " + pkg.PreviousVersion + " -> " + pkg.NewVersion
+	if len(kinds) == 0 {
+		return line
+	}
+	return line + " (" + strings.Join(kinds, ", ") + ")"
+}
+
+// printFileDiff prints the file diff between the releases of the image given
+// as positional arguments.
+func printFileDiff(b backend, positional []string, prefix string, asJSON bool) error {
+	result, err := b.DiffFiles(positional[0], positional[1], positional[2], prefix)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return printJSON(os.Stdout, result)
+	}
+	fmt.Printf("%s -> %s\n\n", result.OldDigest, result.NewDigest)
+	owner := func(file types.FileChange) string {
+		if file.Package == "" {
+			return ""
+		}
+		return " (" + file.Package + ")"
+	}
+	printPackageDiffs("Added", result.Added, func(file types.FileChange) string {
+		return file.Path + owner(file)
+	})
+	printPackageDiffs("Removed", result.Removed, func(file types.FileChange) string {
+		return file.Path + owner(file)
+	})
+	printPackageDiffs("Modified", result.Modified, func(file types.FileChange) string {
+		changes := []string{}
+		if file.PreviousSHA256 != file.NewSHA256 {
+			changes = append(changes, "content")
+		}
+		if file.PreviousMode != file.NewMode {
+			changes = append(changes, fmt.Sprintf("mode %o -> %o", file.PreviousMode, file.NewMode))
+		}
+		return file.Path + owner(file) + ": " + strings.Join(changes, ", ")
+	})
+	return nil
+}
+
+func printVulnerabilities(title string, matches []types.VulnerabilityMatch) {
+	if len(matches) == 0 {
+		return
+	}
+
+	fmt.Printf("%s (%d):\n", title, len(matches))
+	for _, match := range matches {
+		severity := ""
+		if match.Severity != "" {
+			severity = " (" + match.Severity + ")"
+		}
+		fmt.Printf("  %s%s in %s %s\n", match.ID, severity, match.Package, match.Version)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/client"
+	"github.com/vanilla-os/differ/packages"
+	"github.com/vanilla-os/differ/types"
+)
+
+var publishCommand = &command{
+	name:    "publish",
+	usage:   "[flags] <image>",
+	summary: "Publish the packages installed on this system as a new release",
+	run:     runPublish,
+}
+
+func runPublish(args []string) error {
+	fs := newFlagSet("differ publish", "[flags] <image>")
+	root := fs.String("root", "/", "root `directory` of the system to collect packages from")
+	manager := fs.String("manager", "", "package manager to read (dpkg, apk, pacman or rpm), detected when empty")
+	sbom := fs.String("sbom", "", "SPDX or CycloneDX JSON `file` to read the packages from, instead of the package manager")
+	digest := fs.String("digest", "", "image digest of the release, computed from the package list when empty")
+	date := fs.String("date", "", "release date in RFC 3339 format, defaults to now")
+	channel := fs.String("channel", "", "tag to point at the published release, such as stable")
+	override := fs.Bool("override", false, "publish the release even if it violates the publish policy of the image (admins only)")
+	files := fs.Bool("files", false, "also publish the file manifests of the dpkg packages under the root")
+	layout := fs.String("oci", "", "also publish the file manifests of the image stored in this OCI layout `directory`")
+	dryRun := fs.Bool("dry-run", false, "print the release payload instead of publishing it")
+	loadMetadata := bindMetadataFlags(fs)
+	remoteURL := fs.String("url", os.Getenv("DIFFER_URL"), "`URL` of the Differ server")
+	user := fs.String("user", os.Getenv("DIFFER_USER"), "user for authenticating against the server")
+	password := fs.String("password", os.Getenv("DIFFER_PASSWORD"), "password for authenticating against the server")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *remoteURL == "" && !*dryRun {
+		return errors.New("no server to publish to, set -url or DIFFER_URL")
+	}
+
+	if *channel != "" {
+		if err := types.ValidateTagName(*channel); err != nil {
+			return err
+		}
+	}
+
+	release := client.NewReleaseRequest{Digest: *digest, Date: time.Now().UTC(), Override: *override}
+	if *date != "" {
+		release.Date, err = time.Parse(time.RFC3339, *date)
+		if err != nil {
+			return fmt.Errorf("invalid date: %v", err)
+		}
+	}
+
+	if release.ReleaseMetadata, err = loadMetadata(); err != nil {
+		return err
+	}
+	if release.Kernel == "" {
+		release.Kernel = detectKernel(*root)
+	}
+
+	if *sbom != "" {
+		release.Packages, err = readSBOM(*sbom)
+	} else {
+		release.Packages, err = collectPackages(*root, *manager)
+	}
+	if err != nil {
+		return err
+	}
+	if len(release.Packages) == 0 {
+		return fmt.Errorf("no installed packages found in %s", *root)
+	}
+	if release.Digest == "" {
+		release.Digest = packages.Digest(release.Packages)
+	}
+
+	var manifests []types.PackageFiles
+	switch {
+	case *files && *layout != "":
+		return errors.New("-files and -oci are mutually exclusive")
+	case *files:
+		manifests, err = packages.CollectFiles(*root)
+	case *layout != "":
+		manifests, err = packages.ReadOCILayout(*layout)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		if err := printJSON(os.Stdout, release); err != nil {
+			return err
+		}
+		if manifests != nil {
+			return printJSON(os.Stdout, api.ReleaseFiles{Manifests: manifests})
+		}
+		return nil
+	}
+
+	c, err := client.New(*remoteURL, client.WithBasicAuth(*user, *password))
+	if err != nil {
+		return err
+	}
+	created, err := c.AddRelease(context.Background(), positional[0], release)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Published release %s of %s with %d packages\n", created.Digest, positional[0], len(release.Packages))
+
+	if manifests != nil {
+		summary, err := c.SetReleaseFiles(context.Background(), positional[0], created.Digest, manifests)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Published %d files of %d packages\n", summary.Files, summary.Packages)
+	}
+
+	if *channel != "" {
+		if _, err := c.SetTag(context.Background(), positional[0], *channel, created.Digest); err != nil {
+			return err
+		}
+		fmt.Printf("Moved %s to %s\n", *channel, created.Digest)
+	}
+	return nil
+}
+
+// detectKernel returns the version of the kernel installed under root, found
+// from its modules directory, or an empty string if there isn't exactly one.
+func detectKernel(root string) string {
+	for _, dir := range []string{"usr/lib/modules", "lib/modules"} {
+		entries, err := os.ReadDir(filepath.Join(root, dir))
+		if err != nil {
+			continue
+		}
+
+		versions := []string{}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				versions = append(versions, entry.Name())
+			}
+		}
+		if len(versions) == 1 {
+			return versions[0]
+		}
+		return ""
+	}
+
+	return ""
+}
+
+// collectPackages reads the installed packages under root, using the named
+// package manager or detecting it when name is empty.
+func collectPackages(root, name string) ([]types.Package, error) {
+	if name == "" {
+		_, pkgs, err := packages.Collect(root)
+		return pkgs, err
+	}
+
+	manager, err := packages.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := manager.Collect(root)
+	if err != nil {
+		return nil, err
+	}
+	packages.Sort(pkgs)
+
+	return pkgs, nil
+}
+
+// readSBOM returns the packages listed by the SBOM at path, sorted like
+// collected ones.
+func readSBOM(path string) ([]types.Package, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := packages.ReadSBOM(content)
+	if err != nil {
+		return nil, err
+	}
+	packages.Sort(pkgs)
+
+	return pkgs, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/core"
+	"github.com/vanilla-os/differ/core/handlers"
+	"gopkg.in/yaml.v3"
+)
+
+var serveCommand = &command{
+	name:    "serve",
+	usage:   "[flags] [database]",
+	summary: "Start the API server",
+	run:     runServe,
+}
+
+var configCommand = &command{
+	name:    "config",
+	summary: "Inspect the server configuration",
+	subcommands: []*command{
+		{
+			name:    "check",
+			usage:   "[flags]",
+			summary: "Validate the configuration and print the effective settings",
+			run:     runConfigCheck,
+		},
+	},
+}
+
+// setupLogging configures the default logger and Gin for the configured
+// log level.
+func setupLogging(config core.Config) {
+	level := config.SlogLevel()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	// GIN_MODE still takes precedence, as it always did
+	if _, ok := os.LookupEnv("GIN_MODE"); !ok && level > slog.LevelDebug {
+		gin.SetMode(gin.ReleaseMode)
+	}
+}
+
+func runServe(args []string) error {
+	fs := newFlagSet("differ serve", "[flags] [database]")
+	loadConfig := bindConfigFlags(fs)
+	positional, err := parseArgs(fs, args, -1)
+	if err != nil {
+		return err
+	}
+
+	// The database used to be passed as the only positional argument
+	switch len(positional) {
+	case 0:
+	case 1:
+		fs.Set("dsn", positional[0])
+	default:
+		fs.Usage()
+		return errUsage
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%v", err)
+	}
+	setupLogging(config)
+
+	server, err := handlers.NewServer(config)
+	if err != nil {
+		return err
+	}
+
+	router, err := setupRouter(server)
+	if err != nil {
+		return err
+	}
+
+	if config.GCInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		core.StartGC(ctx, server.DB, server.Cache, time.Duration(config.GCInterval))
+	}
+
+	return router.Run(config.Listen)
+}
+
+// runConfigCheck validates the configuration and prints the effective
+// settings, with secrets redacted.
+func runConfigCheck(args []string) error {
+	fs := newFlagSet("differ config check", "[flags]")
+	loadConfig := bindConfigFlags(fs)
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%v", err)
+	}
+
+	out, err := yaml.Marshal(config.Redacted())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Configuration is valid.\n\n%s", out)
+
+	return nil
+}
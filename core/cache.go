@@ -8,25 +8,61 @@ package core
  */
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/dgraph-io/ristretto"
 	"github.com/eko/gocache/lib/v4/cache"
+	"github.com/eko/gocache/lib/v4/store"
 	ristretto_store "github.com/eko/gocache/store/ristretto/v4"
 )
 
-var CacheManager *cache.Cache[[]byte]
+// Cache stores generated diffs, tagged with the digests of the releases
+// involved.
+type Cache struct {
+	*cache.Cache[[]byte]
+}
 
-func InitCache() error {
+func NewCache(config CacheConfig) (*Cache, error) {
 	ristrettoCache, err := ristretto.NewCache(&ristretto.Config{
-		NumCounters: 1000,
-		MaxCost:     100,
-		BufferItems: 64,
+		NumCounters: config.NumCounters,
+		MaxCost:     config.MaxCost,
+		BufferItems: config.BufferItems,
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Sets must be synchronous so that tags are visible to Invalidate right
+	// after the entry is stored.
+	options := []store.Option{store.WithSynchronousSet()}
+	if config.TTL > 0 {
+		options = append(options, store.WithExpiration(time.Duration(config.TTL)))
 	}
+	ristrettoStore := ristretto_store.NewRistretto(ristrettoCache, options...)
+
+	return &Cache{cache.New[[]byte](ristrettoStore)}, nil
+}
 
-	ristrettoStore := ristretto_store.NewRistretto(ristrettoCache)
-	CacheManager = cache.New[[]byte](ristrettoStore)
+// InvalidateReleases removes every cached entry that was tagged with any of
+// the given release digests.
+func (c *Cache) InvalidateReleases(digests ...string) error {
+	if len(digests) == 0 {
+		return nil
+	}
+
+	return c.Invalidate(context.Background(), store.WithInvalidateTags(digests))
+}
+
+// ImageTag returns the tag of cached entries depending on an image as a
+// whole rather than on specific releases, such as its feeds.
+func ImageTag(imageID uint) string {
+	return fmt.Sprintf("image:%d", imageID)
+}
 
-	return nil
+// InvalidateImage removes every cached entry that was tagged with the
+// ImageTag of the image.
+func (c *Cache) InvalidateImage(imageID uint) error {
+	return c.Invalidate(context.Background(), store.WithInvalidateTags([]string{ImageTag(imageID)}))
 }
@@ -0,0 +1,390 @@
+package core
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported authentication modes.
+const (
+	// AuthBasic protects write endpoints with HTTP basic auth, using the
+	// users in the `auth` table.
+	AuthBasic = "basic"
+	// AuthNone leaves write endpoints unprotected, for deployments where a
+	// trusted proxy takes care of authentication.
+	AuthNone = "none"
+)
+
+// Duration is a time.Duration written as a Go duration string (e.g. "24h")
+// in configuration files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config holds the settings of a Differ server. Settings are read, in order
+// of increasing precedence, from the defaults, a YAML or TOML configuration
+// file, environment variables and command line flags.
+type Config struct {
+	// Listen is the address the HTTP server binds to.
+	Listen string `yaml:"listen" toml:"listen"`
+	// LogLevel is one of debug, info, warn or error.
+	LogLevel string `yaml:"log_level" toml:"log_level"`
+	// ReadOnly disables every endpoint that modifies images or releases.
+	ReadOnly bool `yaml:"read_only" toml:"read_only"`
+	// TrustedProxies lists the IPs or CIDRs allowed to set forwarding headers.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// PublicURL is the URL clients reach the server at, used to build the
+	// absolute links of feeds. Links are relative when it is empty.
+	PublicURL string `yaml:"public_url" toml:"public_url"`
+	// GCInterval is how often the garbage collector runs. Zero disables it.
+	GCInterval Duration `yaml:"gc_interval" toml:"gc_interval"`
+
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Cache     CacheConfig     `yaml:"cache" toml:"cache"`
+	Auth      AuthConfig      `yaml:"auth" toml:"auth"`
+	Changelog ChangelogConfig `yaml:"changelog" toml:"changelog"`
+	Webhooks  WebhooksConfig  `yaml:"webhooks" toml:"webhooks"`
+	Events    EventsConfig    `yaml:"events" toml:"events"`
+}
+
+type DatabaseConfig struct {
+	// DSN selects the storage database, see OpenDialector.
+	DSN string `yaml:"dsn" toml:"dsn"`
+}
+
+type CacheConfig struct {
+	NumCounters int64 `yaml:"num_counters" toml:"num_counters"`
+	MaxCost     int64 `yaml:"max_cost" toml:"max_cost"`
+	BufferItems int64 `yaml:"buffer_items" toml:"buffer_items"`
+	// TTL is how long generated diffs are kept. Zero keeps them until evicted.
+	TTL Duration `yaml:"ttl" toml:"ttl"`
+}
+
+type AuthConfig struct {
+	// Mode is either AuthBasic or AuthNone.
+	Mode string `yaml:"mode" toml:"mode"`
+	// AdminUser and AdminPassword, if set, create the first authorized user
+	// of an empty database.
+	AdminUser     string `yaml:"admin_user" toml:"admin_user"`
+	AdminPassword string `yaml:"admin_password" toml:"admin_password"`
+	// Admins lists the users allowed to override publish policies, along
+	// with AdminUser. Anyone is an admin when Mode is AuthNone.
+	Admins []string `yaml:"admins" toml:"admins"`
+}
+
+// IsAdmin reports whether user is allowed to override publish policies.
+func (a AuthConfig) IsAdmin(user string) bool {
+	if a.Mode == AuthNone {
+		return true
+	}
+
+	return user != "" && (user == a.AdminUser || slices.Contains(a.Admins, user))
+}
+
+type ChangelogConfig struct {
+	// Templates is a directory of templates replacing the default changelog
+	// templates, see changelog.NewRenderer.
+	Templates string `yaml:"templates" toml:"templates"`
+}
+
+type WebhooksConfig struct {
+	// MaxAttempts is how many times a delivery is attempted before giving up.
+	MaxAttempts int64 `yaml:"max_attempts" toml:"max_attempts"`
+	// Backoff is the delay before the first retry, doubled after each one.
+	Backoff Duration `yaml:"backoff" toml:"backoff"`
+	// Timeout bounds each attempt.
+	Timeout Duration `yaml:"timeout" toml:"timeout"`
+}
+
+type EventsConfig struct {
+	// LogSize is how many of the latest events are kept for clients resuming
+	// their stream.
+	LogSize int64 `yaml:"log_size" toml:"log_size"`
+}
+
+// DefaultConfig returns the configuration used when nothing is overridden.
+func DefaultConfig() Config {
+	return Config{
+		Listen:   ":8080",
+		LogLevel: "info",
+		Cache: CacheConfig{
+			NumCounters: 1000,
+			MaxCost:     100,
+			BufferItems: 64,
+		},
+		Auth: AuthConfig{Mode: AuthBasic},
+		Webhooks: WebhooksConfig{
+			MaxAttempts: 5,
+			Backoff:     Duration(10 * time.Second),
+			Timeout:     Duration(10 * time.Second),
+		},
+		Events: EventsConfig{LogSize: 1000},
+	}
+}
+
+// LoadConfig returns the default configuration overridden by the file at
+// path, if not empty, and then by environment variables. The file format is
+// chosen by its extension.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("failed to read configuration file: %v", err)
+		}
+
+		switch ext := filepath.Ext(path); ext {
+		case ".yaml", ".yml":
+			decoder := yaml.NewDecoder(strings.NewReader(string(content)))
+			decoder.KnownFields(true)
+			err = decoder.Decode(&config)
+		case ".toml":
+			decoder := toml.NewDecoder(strings.NewReader(string(content)))
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(&config)
+		default:
+			return config, fmt.Errorf("unsupported configuration file extension %q, expected .yaml, .yml or .toml", ext)
+		}
+		if err != nil {
+			return config, fmt.Errorf("failed to parse configuration file %s: %v", path, err)
+		}
+	}
+
+	if err := config.applyEnv(); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// envBinding maps an environment variable to the setting it overrides.
+type envBinding struct {
+	name  string
+	apply func(value string) error
+}
+
+func setString(target *string) func(string) error {
+	return func(value string) error {
+		*target = value
+		return nil
+	}
+}
+
+func setBool(target *bool) func(string) error {
+	return func(value string) (err error) {
+		*target, err = strconv.ParseBool(value)
+		return err
+	}
+}
+
+func setInt(target *int64) func(string) error {
+	return func(value string) (err error) {
+		*target, err = strconv.ParseInt(value, 10, 64)
+		return err
+	}
+}
+
+func setDuration(target *Duration) func(string) error {
+	return func(value string) error {
+		return target.UnmarshalText([]byte(value))
+	}
+}
+
+func setList(target *[]string) func(string) error {
+	return func(value string) error {
+		*target = SplitList(value)
+		return nil
+	}
+}
+
+// applyEnv overrides settings with the environment variables that are set.
+// The unprefixed variables are kept for compatibility with older setups and
+// are superseded by their DIFFER_ counterparts.
+func (c *Config) applyEnv() error {
+	bindings := []envBinding{
+		{"PORT", func(value string) error { c.Listen = ":" + value; return nil }},
+		{"db_path", setString(&c.Database.DSN)},
+		{"admin_user", setString(&c.Auth.AdminUser)},
+		{"admin_password", setString(&c.Auth.AdminPassword)},
+		{"gc_interval", setDuration(&c.GCInterval)},
+
+		{"DIFFER_LISTEN", setString(&c.Listen)},
+		{"DIFFER_LOG_LEVEL", setString(&c.LogLevel)},
+		{"DIFFER_READ_ONLY", setBool(&c.ReadOnly)},
+		{"DIFFER_TRUSTED_PROXIES", setList(&c.TrustedProxies)},
+		{"DIFFER_PUBLIC_URL", setString(&c.PublicURL)},
+		{"DIFFER_GC_INTERVAL", setDuration(&c.GCInterval)},
+		{"DIFFER_DSN", setString(&c.Database.DSN)},
+		{"DIFFER_CACHE_NUM_COUNTERS", setInt(&c.Cache.NumCounters)},
+		{"DIFFER_CACHE_MAX_COST", setInt(&c.Cache.MaxCost)},
+		{"DIFFER_CACHE_BUFFER_ITEMS", setInt(&c.Cache.BufferItems)},
+		{"DIFFER_CACHE_TTL", setDuration(&c.Cache.TTL)},
+		{"DIFFER_AUTH_MODE", setString(&c.Auth.Mode)},
+		{"DIFFER_ADMIN_USER", setString(&c.Auth.AdminUser)},
+		{"DIFFER_ADMIN_PASSWORD", setString(&c.Auth.AdminPassword)},
+		{"DIFFER_AUTH_ADMINS", setList(&c.Auth.Admins)},
+		{"DIFFER_CHANGELOG_TEMPLATES", setString(&c.Changelog.Templates)},
+		{"DIFFER_WEBHOOKS_MAX_ATTEMPTS", setInt(&c.Webhooks.MaxAttempts)},
+		{"DIFFER_WEBHOOKS_BACKOFF", setDuration(&c.Webhooks.Backoff)},
+		{"DIFFER_WEBHOOKS_TIMEOUT", setDuration(&c.Webhooks.Timeout)},
+		{"DIFFER_EVENTS_LOG_SIZE", setInt(&c.Events.LogSize)},
+	}
+
+	for _, binding := range bindings {
+		value, ok := os.LookupEnv(binding.name)
+		if !ok {
+			continue
+		}
+		if err := binding.apply(value); err != nil {
+			return fmt.Errorf("invalid value %q for environment variable %s: %v", value, binding.name, err)
+		}
+	}
+
+	return nil
+}
+
+// SplitList splits a comma-separated list, dropping empty items.
+func SplitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// SlogLevel returns the slog level matching LogLevel.
+func (c *Config) SlogLevel() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.LogLevel))
+	return level
+}
+
+// Validate checks every setting, reporting all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen: invalid address %q: %v", c.Listen, err))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: unknown level %q, expected debug, info, warn or error", c.LogLevel))
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				errs = append(errs, fmt.Errorf("trusted_proxies: %q is neither an IP nor a CIDR", proxy))
+			}
+		}
+	}
+
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("public_url: %q is not an absolute HTTP URL", c.PublicURL))
+		}
+	}
+
+	if c.GCInterval < 0 {
+		errs = append(errs, errors.New("gc_interval: must not be negative"))
+	}
+
+	if c.Database.DSN == "" {
+		errs = append(errs, errors.New("database.dsn: no database was provided"))
+	} else if _, err := OpenDialector(c.Database.DSN); err != nil {
+		errs = append(errs, fmt.Errorf("database.dsn: %v", err))
+	}
+
+	if c.Cache.NumCounters <= 0 {
+		errs = append(errs, errors.New("cache.num_counters: must be positive"))
+	}
+	if c.Cache.MaxCost <= 0 {
+		errs = append(errs, errors.New("cache.max_cost: must be positive"))
+	}
+	if c.Cache.BufferItems <= 0 {
+		errs = append(errs, errors.New("cache.buffer_items: must be positive"))
+	}
+	if c.Cache.TTL < 0 {
+		errs = append(errs, errors.New("cache.ttl: must not be negative"))
+	}
+
+	if c.Auth.Mode != AuthBasic && c.Auth.Mode != AuthNone {
+		errs = append(errs, fmt.Errorf("auth.mode: unknown mode %q, expected %s or %s", c.Auth.Mode, AuthBasic, AuthNone))
+	}
+	if c.Auth.AdminUser != "" && c.Auth.AdminPassword == "" {
+		errs = append(errs, errors.New("auth.admin_password: required when auth.admin_user is set"))
+	}
+
+	if c.Changelog.Templates != "" {
+		if info, err := os.Stat(c.Changelog.Templates); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("changelog.templates: %q is not a directory", c.Changelog.Templates))
+		}
+	}
+
+	if c.Webhooks.MaxAttempts <= 0 {
+		errs = append(errs, errors.New("webhooks.max_attempts: must be positive"))
+	}
+	if c.Webhooks.Backoff < 0 {
+		errs = append(errs, errors.New("webhooks.backoff: must not be negative"))
+	}
+	if c.Webhooks.Timeout <= 0 {
+		errs = append(errs, errors.New("webhooks.timeout: must be positive"))
+	}
+
+	if c.Events.LogSize <= 0 {
+		errs = append(errs, errors.New("events.log_size: must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of the configuration safe for printing.
+func (c Config) Redacted() Config {
+	if c.Auth.AdminPassword != "" {
+		c.Auth.AdminPassword = "<redacted>"
+	}
+
+	if dsn, err := url.Parse(c.Database.DSN); err == nil && dsn.User != nil {
+		if _, ok := dsn.User.Password(); ok {
+			dsn.User = url.UserPassword(dsn.User.Username(), "redacted")
+			c.Database.DSN = dsn.String()
+		}
+	}
+
+	return c
+}
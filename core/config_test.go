@@ -0,0 +1,95 @@
+package core
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	files := map[string]string{
+		"differ.yaml": "listen: \"127.0.0.1:9000\"\ncache:\n  ttl: 1h\ndatabase:\n  dsn: differ.db\n",
+		"differ.toml": "listen = \"127.0.0.1:9000\"\n[cache]\nttl = \"1h\"\n[database]\ndsn = \"differ.db\"\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Setenv("DIFFER_DSN", "postgres://differ@localhost/differ")
+		config, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := config.Validate(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if config.Listen != "127.0.0.1:9000" || time.Duration(config.Cache.TTL) != time.Hour {
+			t.Fatalf("%s: settings not loaded from file: %+v", name, config)
+		}
+		if config.Database.DSN != "postgres://differ@localhost/differ" {
+			t.Fatalf("%s: environment did not override file, got DSN %s", name, config.Database.DSN)
+		}
+		if config.Cache.MaxCost != DefaultConfig().Cache.MaxCost {
+			t.Fatalf("%s: unset settings lost their default", name)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.LogLevel = "loud"
+	config.Auth.Mode = "oauth"
+	config.Changelog.Templates = filepath.Join(t.TempDir(), "missing")
+	config.Webhooks.MaxAttempts = 0
+	config.Events.LogSize = -1
+	config.PublicURL = "differ.example.com"
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("invalid configuration was accepted")
+	}
+	for _, setting := range []string{"log_level", "auth.mode", "database.dsn", "changelog.templates", "webhooks.max_attempts", "events.log_size", "public_url"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("error does not mention %s: %v", setting, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "differ.yaml")
+	os.WriteFile(path, []byte("lisen: \":80\"\n"), 0o644)
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatal("unknown setting in configuration file was accepted")
+	}
+}
+
+func TestAdmins(t *testing.T) {
+	t.Setenv("DIFFER_AUTH_ADMINS", "alice, bob")
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Auth.AdminUser = "root"
+
+	for user, admin := range map[string]bool{"root": true, "alice": true, "bob": true, "carol": false, "": false} {
+		if config.Auth.IsAdmin(user) != admin {
+			t.Errorf("IsAdmin(%q) should be %v", user, admin)
+		}
+	}
+
+	config.Auth.Mode = AuthNone
+	if !config.Auth.IsAdmin("") {
+		t.Error("anyone should be an admin without authentication")
+	}
+}
@@ -0,0 +1,86 @@
+package core
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bytedance/sonic"
+	"github.com/eko/gocache/lib/v4/store"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/diff"
+	"github.com/vanilla-os/differ/types"
+	"gorm.io/gorm"
+)
+
+// DiffReleases diffs oldRelease against newRelease, comparing versions with
+// compare, and sets the vulnerabilities fixed and introduced. Package changes
+// are cached until either release changes unless cache is nil, while
+// vulnerabilities are looked up on each call, so that newly imported
+// advisories apply at once.
+func DiffReleases(db *gorm.DB, cache *Cache, oldRelease, newRelease *types.Release, compare diff.Comparator) (*api.Diff, error) {
+	result, err := cachedDiff(cache, oldRelease, newRelease, compare)
+	if err != nil {
+		return nil, err
+	}
+	if err := annotateVulnerabilities(db, result, compare); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// cachedDiff returns the diff of oldRelease to newRelease from cache,
+// generating and storing it on a miss.
+func cachedDiff(cache *Cache, oldRelease, newRelease *types.Release, compare diff.Comparator) (*api.Diff, error) {
+	if cache == nil {
+		return api.NewDiff(oldRelease, newRelease, compare), nil
+	}
+
+	cacheKey := fmt.Sprintf("%s-%s", oldRelease.Digest, newRelease.Digest)
+	if cached, _ := cache.Get(context.Background(), cacheKey); cached != nil {
+		var result api.Diff
+		if err := sonic.Unmarshal(cached, &result); err != nil {
+			return nil, err
+		}
+		return &result, nil
+	}
+
+	// Diff not in cache. Generate diff and store for future queries.
+	result := api.NewDiff(oldRelease, newRelease, compare)
+	cacheBytes, err := sonic.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	err = cache.Set(context.Background(), cacheKey, cacheBytes, store.WithTags([]string{oldRelease.Digest, newRelease.Digest}))
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// annotateVulnerabilities sets the vulnerabilities fixed and introduced by
+// result and flags the packages fixing them, unless no vulnerability
+// database was imported.
+func annotateVulnerabilities(db *gorm.DB, result *api.Diff, compare diff.Comparator) error {
+	info, err := types.GetVulnerabilityImport(db)
+	if err != nil || info == nil {
+		return err
+	}
+
+	// Only changed packages can fix or introduce vulnerabilities
+	oldPackages, newPackages := result.ChangedPackages()
+	if result.Vulnerabilities, err = types.DiffVulnerabilities(db, compare, oldPackages, newPackages); err != nil {
+		return err
+	}
+	result.MarkSecurityFixes()
+
+	return nil
+}
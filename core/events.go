@@ -0,0 +1,139 @@
+package core
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// subscriptionBuffer is how many events a subscriber can lag behind before
+// being dropped.
+const subscriptionBuffer = 64
+
+// Event is a change to an image or its releases, published on an EventBus.
+type Event struct {
+	// ID orders the events published on a bus, starting at 1. IDs start over
+	// when the process restarts.
+	ID      uint64
+	Name    string
+	ImageID uint
+	// Data is the JSON encoding of the payload of the event.
+	Data []byte
+}
+
+// EventBus broadcasts events to the subscribers of the process, keeping the
+// latest ones in a bounded log so that subscribers can resume after
+// disconnecting.
+type EventBus struct {
+	mu          sync.Mutex
+	log         []Event
+	size        int
+	lastID      uint64
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events published on an EventBus after it was
+// created.
+type Subscription struct {
+	// Events receives the events as they are published. It is closed when
+	// the subscriber falls too far behind, in which case it should subscribe
+	// again from the last event it got.
+	Events <-chan Event
+
+	events  chan Event
+	imageID uint
+}
+
+// NewEventBus returns an EventBus keeping the latest logSize events.
+func NewEventBus(logSize int) *EventBus {
+	return &EventBus{
+		log:         make([]Event, 0, logSize),
+		size:        logSize,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish broadcasts the event name about the image with the given ID, with
+// data encoded as JSON.
+func (b *EventBus) Publish(imageID uint, name string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{ID: b.lastID, Name: name, ImageID: imageID, Data: encoded}
+	if len(b.log) == b.size {
+		b.log = append(b.log[:0], b.log[1:]...)
+	}
+	b.log = append(b.log, event)
+
+	for sub := range b.subscribers {
+		if sub.imageID != 0 && sub.imageID != imageID {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			b.drop(sub)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe returns a subscription to the events about the image with the
+// given ID, or every image when zero. When lastID is not zero, the logged
+// events following the one with that ID are returned as well, and complete
+// reports whether the log still held all of them.
+func (b *EventBus) Subscribe(imageID uint, lastID uint64) (sub *Subscription, missed []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make(chan Event, subscriptionBuffer)
+	sub = &Subscription{Events: events, events: events, imageID: imageID}
+	b.subscribers[sub] = struct{}{}
+
+	if lastID == 0 {
+		return sub, nil, true
+	}
+
+	if lastID > b.lastID {
+		// The subscriber got events from before a restart, all the events
+		// published since are new to it but some may have been lost
+		lastID, complete = 0, false
+	} else {
+		complete = lastID == b.lastID || b.log[0].ID <= lastID+1
+	}
+	for _, event := range b.log {
+		if event.ID > lastID && (imageID == 0 || event.ImageID == imageID) {
+			missed = append(missed, event)
+		}
+	}
+
+	return sub, missed, complete
+}
+
+// Unsubscribe stops sending events to sub.
+func (b *EventBus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		b.drop(sub)
+	}
+}
+
+func (b *EventBus) drop(sub *Subscription) {
+	delete(b.subscribers, sub)
+	close(sub.events)
+}
@@ -0,0 +1,84 @@
+package core
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"testing"
+)
+
+// eventIDs returns the IDs of events.
+func eventIDs(events []Event) []uint64 {
+	ids := []uint64{}
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus(3)
+
+	all, _, _ := bus.Subscribe(0, 0)
+	one, _, _ := bus.Subscribe(1, 0)
+	for _, imageID := range []uint{1, 2, 1, 2, 1} {
+		if err := bus.Publish(imageID, "release.created", map[string]uint{"image": imageID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(all.Events) != 5 || len(one.Events) != 3 {
+		t.Fatalf("expected 5 and 3 events, got %d and %d", len(all.Events), len(one.Events))
+	}
+	if event := <-one.Events; event.ID != 1 || event.Name != "release.created" || string(event.Data) != `{"image":1}` {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	tests := []struct {
+		name     string
+		imageID  uint
+		lastID   uint64
+		missed   []uint64
+		complete bool
+	}{
+		{"live", 0, 0, nil, true},
+		{"up to date", 0, 5, nil, true},
+		{"logged", 0, 2, []uint64{3, 4, 5}, true},
+		{"filtered", 1, 2, []uint64{3, 5}, true},
+		{"truncated", 0, 1, []uint64{3, 4, 5}, false},
+		{"restarted", 0, 42, []uint64{3, 4, 5}, false},
+	}
+	for _, test := range tests {
+		sub, missed, complete := bus.Subscribe(test.imageID, test.lastID)
+		bus.Unsubscribe(sub)
+		if len(missed) != len(test.missed) || complete != test.complete {
+			t.Errorf("%s: expected %v (complete %t), got %v (complete %t)", test.name, test.missed, test.complete, eventIDs(missed), complete)
+			continue
+		}
+		for i, event := range missed {
+			if event.ID != test.missed[i] {
+				t.Errorf("%s: expected %v, got %v", test.name, test.missed, eventIDs(missed))
+				break
+			}
+		}
+	}
+
+	// Lagging subscribers are dropped instead of blocking publishers
+	for range subscriptionBuffer {
+		bus.Publish(2, "release.created", nil)
+	}
+	for range all.Events {
+	}
+	if len(one.Events) != 2 {
+		t.Fatalf("expected the filtered subscription to be kept, got %d events", len(one.Events))
+	}
+	bus.Unsubscribe(all)
+	bus.Unsubscribe(one)
+	if _, ok := <-one.Events; !ok {
+		t.Fatal("expected the events received before unsubscribing to be kept")
+	}
+}
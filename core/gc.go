@@ -0,0 +1,90 @@
+package core
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/types"
+	"gorm.io/gorm"
+)
+
+// RunGC enforces the retention policy of every image and then removes
+// packages and file manifests no longer referenced by any release.
+func RunGC(db *gorm.DB, cache *Cache) (api.GCResult, error) {
+	result := api.GCResult{DeletedReleases: []string{}}
+
+	images, err := types.GetImages(db)
+	if err != nil {
+		return result, err
+	}
+
+	now := time.Now()
+	for _, image := range images {
+		if !image.Retention.Enabled() {
+			continue
+		}
+
+		image, err = types.GetImageByName(db, image.Name)
+		if err != nil {
+			return result, err
+		}
+
+		deleted, err := image.ApplyRetention(db, now)
+		if err != nil {
+			return result, fmt.Errorf("failed to apply retention policy for %s: %v", image.Name, err)
+		}
+		result.DeletedReleases = append(result.DeletedReleases, deleted...)
+	}
+
+	if err := cache.InvalidateReleases(result.DeletedReleases...); err != nil {
+		return result, err
+	}
+
+	result.DeletedPackages, err = types.DeleteOrphanPackages(db)
+	if err != nil {
+		return result, fmt.Errorf("failed to delete orphaned packages: %v", err)
+	}
+	result.DeletedManifests, err = types.DeleteOrphanManifests(db)
+	if err != nil {
+		return result, fmt.Errorf("failed to delete orphaned file manifests: %v", err)
+	}
+
+	return result, nil
+}
+
+// StartGC runs the garbage collector every interval in the background, until
+// ctx is done.
+func StartGC(ctx context.Context, db *gorm.DB, cache *Cache, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			result, err := RunGC(db, cache)
+			if err != nil {
+				slog.Error("Garbage collection failed", "error", err)
+				continue
+			}
+			level := slog.LevelDebug
+			if len(result.DeletedReleases) > 0 || result.DeletedPackages > 0 || result.DeletedManifests > 0 {
+				level = slog.LevelInfo
+			}
+			slog.Log(ctx, level, "Garbage collection finished", "releases", len(result.DeletedReleases), "packages", result.DeletedPackages, "manifests", result.DeletedManifests)
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/changelog"
+	"github.com/vanilla-os/differ/types"
+)
+
+// mimeMarkdown is the media type of Markdown changelogs, missing from gin.
+const mimeMarkdown = "text/markdown"
+
+// changelogFormat returns the changelog format requested by the `format`
+// query parameter or, failing that, by the Accept header, and whether major
+// version bumps are highlighted, as requested by `highlight=major`. An empty
+// format means JSON.
+func changelogFormat(c *gin.Context) (changelog.Format, bool, error) {
+	highlight := c.Query("highlight")
+	if highlight != "" && highlight != "major" {
+		return "", false, fmt.Errorf("unknown highlight %q, expected major", highlight)
+	}
+
+	major := highlight == "major"
+
+	switch name := c.Query("format"); name {
+	case "json":
+		return "", false, nil
+	case "":
+	default:
+		format, err := changelog.ParseFormat(name)
+		return format, major, err
+	}
+
+	switch c.NegotiateFormat(gin.MIMEJSON, mimeMarkdown, gin.MIMEHTML, gin.MIMEPlain) {
+	case mimeMarkdown:
+		return changelog.Markdown, major, nil
+	case gin.MIMEHTML:
+		return changelog.HTML, major, nil
+	case gin.MIMEPlain:
+		return changelog.Text, major, nil
+	}
+
+	return "", false, nil
+}
+
+// writeChangelog answers with result, the diff of image from oldRelease to
+// newRelease, rendered in format.
+func (s *Server) writeChangelog(c *gin.Context, image *types.Image, oldRelease, newRelease *types.Release, result *api.Diff, format changelog.Format, highlight bool) error {
+	var out bytes.Buffer
+	err := s.Changelogs.Render(&out, format, changelog.New(image.Name, oldRelease, newRelease, result, highlight))
+	if err != nil {
+		return err
+	}
+
+	c.Data(http.StatusOK, format.ContentType(), out.Bytes())
+	return nil
+}
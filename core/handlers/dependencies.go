@@ -0,0 +1,40 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/types"
+)
+
+func (s *Server) v1ReverseDependencies(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	release, err := image.ResolveRelease(s.DB, c.Param("digest"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	dependents, err := release.ReverseDependencies(c.Param("package"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, api.ReverseDependencies{
+		Digest:     release.Digest,
+		Package:    c.Param("package"),
+		Dependents: dependents,
+	})
+}
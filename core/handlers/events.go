@@ -0,0 +1,113 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/core"
+	"github.com/vanilla-os/differ/types"
+)
+
+// keepaliveInterval is how often idle event streams get a comment, so that
+// proxies don't close them.
+const keepaliveInterval = 30 * time.Second
+
+// resetEvent tells clients resuming a stream that some of the events they
+// missed are no longer logged, and that they should reload their state.
+const resetEvent = "reset"
+
+// HandleGetEvents streams the activity of every image, or of the one given
+// by the "image" parameter, as Server-Sent Events. Clients reconnecting with
+// the Last-Event-ID header first get the logged events they missed.
+func (s *Server) HandleGetEvents(c *gin.Context) {
+	var imageID uint
+	if name := c.Query("image"); name != "" {
+		image, err := types.FindImage(s.DB, name)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		imageID = image.ID
+	}
+
+	var lastID uint64
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID: " + err.Error()})
+			return
+		}
+		lastID = id
+	}
+
+	sub, missed, complete := s.Events.Subscribe(imageID, lastID)
+	defer s.Events.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// Keep nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !complete {
+		fmt.Fprintf(c.Writer, "event: %s\ndata: {}\n\n", resetEvent)
+	}
+	for _, event := range missed {
+		writeEvent(c, event)
+	}
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			// Dropped for lagging behind, the client resumes when reconnecting
+			if !ok {
+				return
+			}
+			writeEvent(c, event)
+		case <-keepalive.C:
+			fmt.Fprint(c.Writer, ": keepalive\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeEvent(c *gin.Context, event core.Event) {
+	fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Name, event.Data)
+}
+
+// publish streams event, about image, to the clients of /events.
+func (s *Server) publish(image *types.Image, event api.ActivityEvent) {
+	event.Date = time.Now().UTC()
+	event.Image = image.Name
+	if err := s.Events.Publish(image.ID, event.Event, event); err != nil {
+		slog.Error("Failed to publish event", "event", event.Event, "image", image.Name, "error", err)
+	}
+}
+
+// notifyYank publishes the yanking of release.
+func (s *Server) notifyYank(image *types.Image, release *types.Release) {
+	s.publish(image, api.ActivityEvent{Event: types.EventReleaseYanked, Release: withoutPackages(release)})
+}
+
+// notifyTag publishes the move of tag, from the release with the digest
+// previous, if any.
+func (s *Server) notifyTag(image *types.Image, tag *types.Tag, previous string) {
+	s.publish(image, api.ActivityEvent{Event: types.EventTagMoved, Tag: tag, Previous: previous})
+}
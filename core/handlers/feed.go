@@ -0,0 +1,146 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/eko/gocache/lib/v4/store"
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/changelog"
+	"github.com/vanilla-os/differ/core"
+	"github.com/vanilla-os/differ/feed"
+	"github.com/vanilla-os/differ/types"
+)
+
+// feedSize is the number of releases listed by feeds.
+const feedSize = 20
+
+// HandleGetAtomFeed answers with the Atom feed of an image, see getFeed.
+func (s *Server) HandleGetAtomFeed(c *gin.Context) {
+	s.getFeed(c, feed.Atom)
+}
+
+// HandleGetRSSFeed answers with the RSS feed of an image, see getFeed.
+func (s *Server) HandleGetRSSFeed(c *gin.Context) {
+	s.getFeed(c, feed.RSS)
+}
+
+// getFeed answers with a feed of the most recent releases of an image, each
+// entry holding the changelog of the release since the one preceding it.
+// Feeds are cached until a release of the image changes, or vulnerabilities
+// are imported.
+func (s *Server) getFeed(c *gin.Context, format feed.Format) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Changelogs list known vulnerabilities, which change on each import
+	base := strings.TrimSuffix(s.Config.PublicURL, "/")
+	var vulnerabilities uint
+	if info, err := types.GetVulnerabilityImport(s.DB); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if info != nil {
+		vulnerabilities = info.ID
+	}
+	cacheKey := fmt.Sprintf("feed-%s-%d-%d", format, image.ID, vulnerabilities)
+	if cached, _ := s.Cache.Get(context.Background(), cacheKey); cached != nil {
+		c.Data(http.StatusOK, format.ContentType(), cached)
+		return
+	}
+
+	content, digests, err := s.renderFeed(&image, format, base, base+c.Request.URL.Path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	err = s.Cache.Set(context.Background(), cacheKey, content, store.WithTags(append(digests, core.ImageTag(image.ID))))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, format.ContentType(), content)
+}
+
+// renderFeed writes the feed of image in format, with links prefixed by
+// base, and returns it alongside the digests of the releases it depends on.
+func (s *Server) renderFeed(image *types.Image, format feed.Format, base, self string) ([]byte, []string, error) {
+	// The oldest release is only fetched to diff the last entry against it
+	releases, err := image.GetRecentReleases(s.DB, feedSize+1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	imagePath := base + "/v1/images/" + url.PathEscape(image.Name)
+	result := &feed.Feed{
+		ID:      "urn:differ:image:" + url.PathEscape(image.Name),
+		Title:   image.Name + " releases",
+		Link:    image.URL,
+		Self:    self,
+		Updated: image.UpdatedAt,
+		Entries: []feed.Entry{},
+	}
+	if len(releases) > 0 {
+		result.Updated = releases[0].Date
+	}
+
+	digests := make([]string, 0, len(releases))
+	for i := range releases {
+		release := &releases[i]
+		digests = append(digests, release.Digest)
+		if i == feedSize {
+			break
+		}
+
+		// The first release of the image is diffed against an empty one
+		var previous *types.Release
+		link := imagePath + "/releases/" + url.PathEscape(release.Digest)
+		if i+1 < len(releases) {
+			previous = &releases[i+1]
+			link = imagePath + "/diff?" + url.Values{"from": {previous.Digest}, "to": {release.Digest}, "format": {string(changelog.HTML)}}.Encode()
+		}
+		diffed := previous
+		if diffed == nil {
+			diffed = &types.Release{}
+		}
+		changes, err := s.diffReleases(image, diffed, release)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var content bytes.Buffer
+		err = s.Changelogs.Render(&content, changelog.HTML, changelog.New(image.Name, previous, release, changes, true))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		result.Entries = append(result.Entries, feed.Entry{
+			ID:      fmt.Sprintf("urn:differ:image:%s:release:%s", url.PathEscape(image.Name), release.Digest),
+			Title:   image.Name + " " + changelog.Label(release),
+			Link:    link,
+			Updated: release.Date,
+			Content: content.String(),
+		})
+	}
+
+	var out bytes.Buffer
+	if err := result.Write(&out, format); err != nil {
+		return nil, nil, err
+	}
+
+	return out.Bytes(), digests, nil
+}
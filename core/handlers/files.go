@@ -0,0 +1,86 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/types"
+)
+
+func (s *Server) v1SetReleaseFiles(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	release, err := image.GetReleaseByDigest(s.DB, c.Param("digest"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	var input api.ReleaseFiles
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := release.SetFiles(s.DB, input.Manifests); err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	summary := api.FilesSummary{Digest: release.Digest, Packages: len(input.Manifests)}
+	for _, manifest := range input.Manifests {
+		summary.Files += len(manifest.Files)
+	}
+	respond(c, http.StatusOK, summary)
+}
+
+func (s *Server) v1DiffFiles(c *gin.Context) {
+	var query struct {
+		From   string `form:"from" binding:"required"`
+		To     string `form:"to" binding:"required"`
+		Prefix string `form:"prefix"`
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	oldRelease, err := image.ResolveRelease(s.DB, query.From)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	newRelease, err := image.ResolveRelease(s.DB, query.To)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	result, err := oldRelease.DiffFiles(s.DB, newRelease, query.Prefix)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, api.FileDiff{
+		OldDigest: oldRelease.Digest,
+		NewDigest: newRelease.Digest,
+		Prefix:    query.Prefix,
+		FileDiff:  *result,
+	})
+}
@@ -0,0 +1,25 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/core"
+)
+
+func (s *Server) HandleRunGC(c *gin.Context) {
+	result, err := core.RunGC(s.DB, s.Cache)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"gc": result})
+}
@@ -12,13 +12,12 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/vanilla-os/differ/core"
 	"github.com/vanilla-os/differ/types"
 	"gorm.io/gorm"
 )
 
-func HandleGetImages(c *gin.Context) {
-	images, err := types.GetImages(core.DB)
+func (s *Server) HandleGetImages(c *gin.Context) {
+	images, err := types.GetImages(s.DB)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -27,8 +26,8 @@ func HandleGetImages(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"images": images})
 }
 
-func HandleFindImage(c *gin.Context) {
-	image, err := types.GetImageByName(core.DB, c.Param("name"))
+func (s *Server) HandleFindImage(c *gin.Context) {
+	image, err := types.GetImageByName(s.DB, c.Param("name"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -37,34 +36,127 @@ func HandleFindImage(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"image": image})
 }
 
-func HandleAddImage(c *gin.Context) {
+func (s *Server) HandleAddImage(c *gin.Context) {
 	var imageInput struct {
-		Name     string `json:"name" binding:"required"`
-		URL      string `json:"url" binding:"required"`
-		Releases []types.Release
+		Name       string                `json:"name" binding:"required"`
+		URL        string                `json:"url" binding:"required"`
+		Comparator string                `json:"comparator"`
+		Retention  types.RetentionPolicy `json:"retention"`
+		Policy     types.PublishPolicy   `json:"policy"`
+		Releases   []types.Release
 	}
 	if err := c.ShouldBindJSON(&imageInput); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if _, err := s.Comparators.Get(imageInput.Comparator); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := imageInput.Policy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	if len(imageInput.Releases) == 0 {
 		imageInput.Releases = []types.Release{}
 	}
 
 	newImage := types.Image{
-		Name:     imageInput.Name,
-		URL:      imageInput.URL,
-		Releases: imageInput.Releases,
+		Name:       imageInput.Name,
+		URL:        imageInput.URL,
+		Comparator: imageInput.Comparator,
+		Retention:  imageInput.Retention,
+		Policy:     imageInput.Policy,
+		Releases:   imageInput.Releases,
 	}
-	if status := core.DB.Create(&newImage); status.Error != nil {
+	if err := types.NewImage(s.DB, &newImage); err != nil {
 		errorCode := http.StatusInternalServerError
-		if errors.Is(status.Error, gorm.ErrDuplicatedKey) {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			errorCode = http.StatusBadRequest
 		}
-		c.JSON(errorCode, gin.H{"error": status.Error.Error()})
+		c.JSON(errorCode, gin.H{"error": err.Error()})
 		return
 	}
 
+	s.notifyImage(&newImage)
+
 	c.JSON(http.StatusOK, gin.H{"image": newImage})
 }
+
+func (s *Server) HandleUpdateImage(c *gin.Context) {
+	image, err := types.GetImageByName(s.DB, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var imageInput types.ImageUpdate
+	if err := c.ShouldBindJSON(&imageInput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if imageInput.Comparator != nil {
+		if _, err := s.Comparators.Get(*imageInput.Comparator); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if imageInput.Policy != nil {
+		if err := imageInput.Policy.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := image.Update(s.DB, imageInput); err != nil {
+		errorCode := http.StatusInternalServerError
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			errorCode = http.StatusBadRequest
+		}
+		c.JSON(errorCode, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Every diff between releases of the image depends on its comparator
+	if imageInput.Comparator != nil {
+		if err := s.Cache.InvalidateReleases(image.Digests()...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	// Feeds show the name and URL of the image
+	if err := s.Cache.InvalidateImage(image.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"image": image})
+}
+
+func (s *Server) HandleDeleteImage(c *gin.Context) {
+	image, err := types.GetImageByName(s.DB, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	digests := image.Digests()
+	if err := image.Delete(s.DB); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.Cache.InvalidateReleases(digests...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.Cache.InvalidateImage(image.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
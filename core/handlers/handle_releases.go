@@ -8,40 +8,55 @@ package handlers
  */
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
-	"github.com/bytedance/sonic"
 	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
 	"github.com/vanilla-os/differ/core"
-	"github.com/vanilla-os/differ/diff"
 	"github.com/vanilla-os/differ/types"
 	"gorm.io/gorm"
 )
 
-func HandleGetLatestRelease(c *gin.Context) {
+func (s *Server) HandleGetLatestRelease(c *gin.Context) {
 	imageName := c.Param("name")
-	image, err := types.GetImageByName(core.DB, imageName)
+	image, err := types.FindImage(s.DB, imageName)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// A channel selects the release it tags rather than the most recent one
+	if channel := c.Query("channel"); channel != "" {
+		release, err := image.GetTaggedRelease(s.DB, channel)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"release": release})
+		return
+	}
+
+	latest, err := image.GetLatestRelease(s.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"release": image.GetLatestRelease()})
+	c.JSON(http.StatusOK, gin.H{"release": latest})
 }
 
-func HandleFindRelease(c *gin.Context) {
+func (s *Server) HandleFindRelease(c *gin.Context) {
 	imageName := c.Param("name")
-	image, err := types.GetImageByName(core.DB, imageName)
+	image, err := types.FindImage(s.DB, imageName)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	releaseDigest := c.Param("digest")
-	release, err := image.GetReleaseByDigest(core.DB, releaseDigest)
+	release, err := image.GetReleaseByDigest(s.DB, releaseDigest)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -50,18 +65,35 @@ func HandleFindRelease(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"release": release})
 }
 
-func HandleAddRelease(c *gin.Context) {
+func (s *Server) HandleFindReleaseByVersion(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	release, err := image.GetReleaseByVersion(s.DB, c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"release": release})
+}
+
+func (s *Server) HandleAddRelease(c *gin.Context) {
 	imageName := c.Param("name")
-	image, err := types.GetImageByName(core.DB, imageName)
+	image, err := types.FindImage(s.DB, imageName)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	var releaseInput struct {
-		Digest   string          `json:"digest" binding:"required"`
-		Date     time.Time       `json:"date"`
+		Digest string    `json:"digest" binding:"required"`
+		Date   time.Time `json:"date"`
+		types.ReleaseMetadata
 		Packages []types.Package `json:"packages" binding:"required"`
+		Override bool            `json:"override"`
 	}
 	if err := c.ShouldBindJSON(&releaseInput); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -72,101 +104,201 @@ func HandleAddRelease(c *gin.Context) {
 		releaseInput.Date = time.Now()
 	}
 
-	newRelease, err := image.NewRelease(core.DB, &types.Release{
-		Digest:   releaseInput.Digest,
-		ImageID:  image.ID,
-		Date:     releaseInput.Date,
-		Packages: releaseInput.Packages,
-	})
+	release := &types.Release{
+		Digest:          releaseInput.Digest,
+		ImageID:         image.ID,
+		Date:            releaseInput.Date,
+		ReleaseMetadata: releaseInput.ReleaseMetadata,
+		Packages:        releaseInput.Packages,
+	}
+	if status, err := s.checkPolicy(c, &image, release, releaseInput.Override); err != nil {
+		response := gin.H{"error": err.Error()}
+		var policyErr *types.PolicyError
+		if errors.As(err, &policyErr) {
+			response["violations"] = policyErr.Violations
+		}
+		c.JSON(status, response)
+		return
+	}
+
+	newRelease, err := image.NewRelease(s.DB, release)
 	if err != nil {
 		errorCode := http.StatusInternalServerError
-		if errors.Is(err, gorm.ErrDuplicatedKey) {
+		if errors.Is(err, gorm.ErrDuplicatedKey) || errors.Is(err, types.ErrConflict) {
 			errorCode = http.StatusBadRequest
 		}
 		c.JSON(errorCode, gin.H{"error": err.Error()})
 		return
 	}
+	if err := s.Cache.InvalidateImage(image.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.notifyRelease(&image, newRelease)
 
 	c.JSON(http.StatusOK, gin.H{"release": newRelease})
 }
 
-func HandleGetReleaseDiff(c *gin.Context) {
-	var diffInput struct {
-		OldDigest string `json:"old_digest" binding:"required"`
-		NewDigest string `json:"new_digest" binding:"required"`
+func (s *Server) HandleUpdateRelease(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
 	}
-	if err := c.ShouldBindJSON(&diffInput); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	release, err := image.GetReleaseByDigest(s.DB, c.Param("digest"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
-	cacheKey := fmt.Sprintf("%s-%s", diffInput.OldDigest, diffInput.NewDigest)
-	cacheDiff, _ := core.CacheManager.Get(context.Background(), cacheKey)
+	var releaseInput types.ReleaseUpdate
+	if err := c.ShouldBindJSON(&releaseInput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// Cache hit
-	if cacheDiff != nil {
-		var diff struct {
-			OldDigest                            string `json:"_old_digest"`
-			NewDigest                            string `json:"_new_digest"`
-			Added, Upgraded, Downgraded, Removed []diff.PackageDiff
+	wasYanked := release.Yanked
+	if err := release.Update(s.DB, releaseInput); err != nil {
+		errorCode := http.StatusInternalServerError
+		if errors.Is(err, types.ErrConflict) {
+			errorCode = http.StatusBadRequest
 		}
-		err := sonic.Unmarshal(cacheDiff, &diff)
-		if err != nil {
+		c.JSON(errorCode, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Yanking doesn't change the contents of a release, but editing its
+	// packages does, so every diff involving it must be regenerated. Feeds
+	// depend on any change.
+	if releaseInput.Packages != nil {
+		if err := s.Cache.InvalidateReleases(release.Digest); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+	}
+	if err := s.Cache.InvalidateImage(image.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if release.Yanked && !wasYanked {
+		s.notifyYank(&image, release)
+	}
 
-		diff.OldDigest = diffInput.OldDigest
-		diff.NewDigest = diffInput.NewDigest
+	c.JSON(http.StatusOK, gin.H{"release": release})
+}
 
-		c.JSON(http.StatusOK, diff)
+func (s *Server) HandleDeleteRelease(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Diff not in cache. Generate diff and store for future queries.
-	imageName := c.Param("name")
-	image, err := types.GetImageByName(core.DB, imageName)
-	if err != nil {
+	releaseDigest := c.Param("digest")
+	if err := image.DeleteRelease(s.DB, releaseDigest); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.Cache.InvalidateReleases(releaseDigest); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.Cache.InvalidateImage(image.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// HandleGetReleaseDiff diffs two releases of an image. Both `old_digest` and
+// `new_digest` accept either a digest, a tag or the symbolic reference
+// "latest". Packages are sorted by name, or by size change with `sort=size`,
+// and `hide` lists the version changes to leave out, such as rebuild.
+// The diff is rendered as a changelog when requested, see changelogFormat.
+func (s *Server) HandleGetReleaseDiff(c *gin.Context) {
+	var diffInput struct {
+		OldDigest string `json:"old_digest" binding:"required"`
+		NewDigest string `json:"new_digest" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&diffInput); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	oldRelease, err := image.GetReleaseByDigest(core.DB, diffInput.OldDigest)
+	format, highlight, err := changelogFormat(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	newRelease, err := image.GetReleaseByDigest(core.DB, diffInput.NewDigest)
+	order := c.DefaultQuery("sort", api.SortName)
+	if order != api.SortName && order != api.SortSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported sort order %s", order)})
+		return
+	}
+	hide, err := api.ParseChanges(c.Query("hide"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	added, upgraded, downgraded, removed := oldRelease.DiffPackages(newRelease)
 
-	cacheDiffEntry := struct {
-		Added, Upgraded, Downgraded, Removed []diff.PackageDiff
-	}{
-		Added:      added,
-		Upgraded:   upgraded,
-		Downgraded: downgraded,
-		Removed:    removed,
+	imageName := c.Param("name")
+	image, err := types.FindImage(s.DB, imageName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	cacheBytes, err := sonic.Marshal(cacheDiffEntry)
+	oldRelease, err := image.ResolveRelease(s.DB, diffInput.OldDigest)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	newRelease, err := image.ResolveRelease(s.DB, diffInput.NewDigest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	err = core.CacheManager.Set(context.Background(), cacheKey, cacheBytes)
+
+	result, err := s.diffReleases(&image, oldRelease, newRelease)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	result.Apply(oldRelease, newRelease, api.DiffOptions{Sort: order, Hide: hide, Explain: c.Query("explain") == "true"})
+	if format != "" {
+		if err := s.writeChangelog(c, &image, oldRelease, newRelease, result, format, highlight); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	response := gin.H{
+		"_old_digest": result.OldDigest,
+		"_new_digest": result.NewDigest,
+		"added":       result.Added,
+		"upgraded":    result.Upgraded,
+		"downgraded":  result.Downgraded,
+		"removed":     result.Removed,
+		"relicensed":  result.Relicensed,
+		"size":        result.Size,
+	}
+	if result.Vulnerabilities != nil {
+		response["vulnerabilities"] = result.Vulnerabilities
+	}
+	if result.PulledIn != nil {
+		response["pulled_in"] = result.PulledIn
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// diffReleases diffs two releases of image, see core.DiffReleases.
+func (s *Server) diffReleases(image *types.Image, oldRelease, newRelease *types.Release) (*api.Diff, error) {
+	compare, err := s.Comparators.Get(image.Comparator)
+	if err != nil {
+		return nil, err
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"_old_digest": diffInput.OldDigest,
-		"_new_digest": diffInput.NewDigest,
-		"added":       added,
-		"upgraded":    upgraded,
-		"downgraded":  downgraded,
-		"removed":     removed,
-	})
+	return core.DiffReleases(s.DB, s.Cache, oldRelease, newRelease, compare)
 }
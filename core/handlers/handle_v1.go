@@ -0,0 +1,502 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/core"
+	"github.com/vanilla-os/differ/types"
+)
+
+// Handlers of the versioned API. They mirror the legacy handlers, but answer
+// through respond and respondError, and with status codes matching the
+// error: 404 for missing images and releases, 409 for duplicates.
+
+func (s *Server) v1Status(c *gin.Context) {
+	respond(c, http.StatusOK, api.Status{Status: "ok"})
+}
+
+func (s *Server) v1ListImages(c *gin.Context) {
+	var query types.ImageQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	images, next, err := types.ListImages(s.DB, query)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respondPage(c, images, next)
+}
+
+func (s *Server) v1GetImage(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, image)
+}
+
+func (s *Server) v1AddImage(c *gin.Context) {
+	var input api.NewImage
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := s.Comparators.Get(input.Comparator); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := input.Policy.Validate(); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	image := types.Image{
+		Name:       input.Name,
+		URL:        input.URL,
+		Comparator: input.Comparator,
+		Retention:  input.Retention,
+		Policy:     input.Policy,
+	}
+	if err := types.NewImage(s.DB, &image); err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	s.notifyImage(&image)
+
+	respond(c, http.StatusCreated, image)
+}
+
+func (s *Server) v1UpdateImage(c *gin.Context) {
+	image, err := types.GetImageByName(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	var input types.ImageUpdate
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if input.Comparator != nil {
+		if _, err := s.Comparators.Get(*input.Comparator); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if input.Policy != nil {
+		if err := input.Policy.Validate(); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := image.Update(s.DB, input); err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	if input.Comparator != nil {
+		if err := s.Cache.InvalidateReleases(image.Digests()...); err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := s.Cache.InvalidateImage(image.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	respond(c, http.StatusOK, image)
+}
+
+func (s *Server) v1DeleteImage(c *gin.Context) {
+	image, err := types.GetImageByName(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	digests := image.Digests()
+	if err := image.Delete(s.DB); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := s.Cache.InvalidateReleases(digests...); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := s.Cache.InvalidateImage(image.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
+
+func (s *Server) v1ListReleases(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	var query types.ReleaseQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	releases, next, err := image.ListReleases(s.DB, query)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respondPage(c, releases, next)
+}
+
+func (s *Server) v1AddRelease(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	var input api.NewRelease
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if input.Date.IsZero() {
+		input.Date = time.Now()
+	}
+
+	release := &types.Release{
+		Digest:          input.Digest,
+		ImageID:         image.ID,
+		Date:            input.Date,
+		ReleaseMetadata: input.ReleaseMetadata,
+		Packages:        input.Packages,
+	}
+	if status, err := s.checkPolicy(c, &image, release, input.Override); err != nil {
+		respondError(c, status, err)
+		return
+	}
+
+	release, err = image.NewRelease(s.DB, release)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	if err := s.Cache.InvalidateImage(image.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	s.notifyRelease(&image, release)
+
+	respond(c, http.StatusCreated, release)
+}
+
+func (s *Server) v1GetRelease(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	var release *types.Release
+	if ref := c.Param("digest"); ref == types.LatestRef {
+		release, err = image.ResolveChannel(s.DB, c.Query("channel"))
+	} else {
+		release, err = image.ResolveRelease(s.DB, ref)
+	}
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, release)
+}
+
+func (s *Server) v1GetReleaseByVersion(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	release, err := image.GetReleaseByVersion(s.DB, c.Param("version"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, release)
+}
+
+func (s *Server) v1UpdateRelease(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	release, err := image.GetReleaseByDigest(s.DB, c.Param("digest"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	var input types.ReleaseUpdate
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	wasYanked := release.Yanked
+	if err := release.Update(s.DB, input); err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	if input.Packages != nil {
+		if err := s.Cache.InvalidateReleases(release.Digest); err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := s.Cache.InvalidateImage(image.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if release.Yanked && !wasYanked {
+		s.notifyYank(&image, release)
+	}
+
+	respond(c, http.StatusOK, release)
+}
+
+func (s *Server) v1DeleteRelease(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	digest := c.Param("digest")
+	if err := image.DeleteRelease(s.DB, digest); err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	if err := s.Cache.InvalidateReleases(digest); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := s.Cache.InvalidateImage(image.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
+
+func (s *Server) v1Diff(c *gin.Context) {
+	var query struct {
+		From    string `form:"from" binding:"required"`
+		To      string `form:"to" binding:"required"`
+		Explain bool   `form:"explain"`
+		Sort    string `form:"sort" binding:"omitempty,oneof=name size"`
+		Hide    string `form:"hide"`
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	hide, err := api.ParseChanges(query.Hide)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	format, highlight, err := changelogFormat(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	oldRelease, err := image.ResolveRelease(s.DB, query.From)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	newRelease, err := image.ResolveRelease(s.DB, query.To)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	result, err := s.diffReleases(&image, oldRelease, newRelease)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	result.Apply(oldRelease, newRelease, api.DiffOptions{Sort: query.Sort, Hide: hide, Explain: query.Explain})
+	if format != "" {
+		if err := s.writeChangelog(c, &image, oldRelease, newRelease, result, format, highlight); err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	respond(c, http.StatusOK, result)
+}
+
+func (s *Server) v1ListTags(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	tags, err := image.ListTags(s.DB)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, tags)
+}
+
+func (s *Server) v1GetTag(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	tag, err := image.FindTag(s.DB, c.Param("tag"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, tag)
+}
+
+func (s *Server) v1SetTag(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	var input api.SetTag
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var previous string
+	if current, err := image.FindTag(s.DB, c.Param("tag")); err == nil {
+		previous = current.Digest
+	}
+	tag, err := image.SetTag(s.DB, c.Param("tag"), input.Digest, time.Now())
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	if tag.Digest != previous {
+		s.notifyTag(&image, tag, previous)
+	}
+
+	respond(c, http.StatusOK, tag)
+}
+
+func (s *Server) v1DeleteTag(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	if err := image.DeleteTag(s.DB, c.Param("tag"), time.Now()); err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
+
+func (s *Server) v1TagHistory(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	history, err := image.TagHistory(s.DB, c.Param("tag"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, history)
+}
+
+func (s *Server) v1SearchPackages(c *gin.Context) {
+	var query types.PackageQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	matches, next, err := types.SearchPackages(s.DB, query)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respondPage(c, matches, next)
+}
+
+func (s *Server) v1PackageHistory(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	spans, err := image.PackageHistory(s.DB, c.Param("package"), c.Query("arch"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, spans)
+}
+
+func (s *Server) v1RunGC(c *gin.Context) {
+	result, err := core.RunGC(s.DB, s.Cache)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	respond(c, http.StatusOK, result)
+}
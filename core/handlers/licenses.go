@@ -0,0 +1,35 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/types"
+)
+
+func (s *Server) v1ReleaseLicenses(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	release, err := image.ResolveRelease(s.DB, c.Param("digest"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, api.LicenseSummary{
+		Digest:   release.Digest,
+		Packages: len(release.Packages),
+		Licenses: types.CountLicenses(release.Packages),
+	})
+}
@@ -0,0 +1,198 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3gen"
+	"github.com/vanilla-os/differ/api"
+)
+
+// pathParams describes the path parameters used by the routes.
+var pathParams = map[string]string{
+	"name":     "Image name",
+	"package":  "Package name",
+	"tag":      "Tag name",
+	"version":  "Version label of a release",
+	"id":       "Webhook ID",
+	"delivery": "Webhook delivery ID",
+	"digest":   "Release digest. Reading endpoints also accept a tag, or \"latest\", the most recent non-yanked release",
+}
+
+// openAPIDocument generates the OpenAPI 3 document of routes served under
+// prefix. Schemas are generated from the Body and Data of each route.
+func openAPIDocument(prefix string, routes []Route) (*openapi3.T, error) {
+	errorSchema, err := schemaFor(struct {
+		Error api.Error `json:"error"`
+	}{}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:       "Differ",
+			Description: "Successful responses wrap their payload in a \"data\" field, failed ones describe the failure in an \"error\" field.",
+			Version:     "1",
+		},
+		Paths: openapi3.NewPaths(),
+		Components: &openapi3.Components{
+			Schemas: openapi3.Schemas{"Error": errorSchema},
+			SecuritySchemes: openapi3.SecuritySchemes{
+				"basicAuth": &openapi3.SecuritySchemeRef{Value: openapi3.NewSecurityScheme().WithType("http").WithScheme("basic")},
+			},
+		},
+	}
+	errorRef := &openapi3.SchemaRef{Ref: "#/components/schemas/Error", Value: errorSchema.Value}
+
+	for _, route := range routes {
+		op := openapi3.NewOperation()
+		op.OperationID = route.OperationID
+		op.Summary = route.Summary
+
+		path := prefix
+		hasPathParams := false
+		for _, segment := range strings.Split(strings.Trim(route.Path, "/"), "/") {
+			if name, ok := strings.CutPrefix(segment, ":"); ok {
+				op.AddParameter(openapi3.NewPathParameter(name).WithDescription(pathParams[name]).WithSchema(openapi3.NewStringSchema()))
+				segment = "{" + name + "}"
+				hasPathParams = true
+			}
+			path += "/" + segment
+		}
+		for _, param := range route.Query {
+			schema := openapi3.NewStringSchema()
+			switch param.Type {
+			case "integer":
+				schema = openapi3.NewIntegerSchema()
+			case "boolean":
+				schema = openapi3.NewBoolSchema()
+			case "date-time":
+				schema = openapi3.NewDateTimeSchema()
+			}
+			op.AddParameter(openapi3.NewQueryParameter(param.Name).WithDescription(param.Description).WithRequired(param.Required).WithSchema(schema))
+		}
+
+		if route.Body != nil {
+			bodySchema, err := schemaFor(route.Body, true)
+			if err != nil {
+				return nil, err
+			}
+			op.RequestBody = &openapi3.RequestBodyRef{Value: openapi3.NewRequestBody().WithRequired(true).WithJSONSchemaRef(bodySchema)}
+		}
+
+		success := openapi3.NewResponse().WithDescription(http.StatusText(route.Status))
+		if route.Data != nil {
+			dataSchema, err := schemaFor(route.Data, false)
+			if err != nil {
+				return nil, err
+			}
+			envelope := openapi3.NewObjectSchema().WithPropertyRef("data", dataSchema)
+			envelope.Required = []string{"data"}
+			if route.Paginated {
+				nextCursor := openapi3.NewStringSchema()
+				nextCursor.Description = "Cursor of the next page, absent on the last one"
+				envelope.WithProperty("next_cursor", nextCursor)
+			}
+			success.WithJSONSchema(envelope)
+		}
+		for _, format := range route.Formats {
+			success.Content[format] = openapi3.NewMediaType().WithSchema(openapi3.NewStringSchema())
+		}
+		op.Responses = openapi3.NewResponses(openapi3.WithStatus(route.Status, &openapi3.ResponseRef{Value: success}))
+
+		statuses := append([]int{http.StatusInternalServerError}, route.Errors...)
+		if route.Body != nil || len(route.Query) > 0 {
+			statuses = append(statuses, http.StatusBadRequest)
+		}
+		if route.Auth {
+			statuses = append(statuses, http.StatusUnauthorized)
+			op.Security = &openapi3.SecurityRequirements{{"basicAuth": []string{}}}
+		}
+		if hasPathParams {
+			statuses = append(statuses, http.StatusNotFound)
+		}
+		slices.Sort(statuses)
+		for _, status := range statuses {
+			op.AddResponse(status, openapi3.NewResponse().WithDescription(http.StatusText(status)).WithJSONSchemaRef(errorRef))
+		}
+
+		if doc.Paths.Value(path) == nil {
+			doc.Paths.Set(path, &openapi3.PathItem{})
+		}
+		doc.Paths.Value(path).SetOperation(route.Method, op)
+	}
+
+	return doc, nil
+}
+
+// schemaFor generates the schema of value. Request schemas require the
+// fields bound with `binding:"required"`, and response schemas those always
+// present, that is neither pointers nor omitempty.
+func schemaFor(value any, request bool) (*openapi3.SchemaRef, error) {
+	schema, err := openapi3gen.NewSchemaRefForValue(value, nil)
+	if err != nil {
+		return nil, err
+	}
+	markRequired(schema.Value, reflect.TypeOf(value), request)
+
+	return schema, nil
+}
+
+func markRequired(schema *openapi3.Schema, t reflect.Type, request bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if schema == nil {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if schema.Items != nil {
+			markRequired(schema.Items.Value, t.Elem(), request)
+		}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return
+		}
+
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if field.Anonymous && name == "" {
+				markRequired(schema, field.Type, request)
+				continue
+			}
+
+			property := schema.Properties[name]
+			if property == nil {
+				continue
+			}
+			markRequired(property.Value, field.Type, request)
+
+			required := field.Type.Kind() != reflect.Pointer && !strings.Contains(options, "omitempty")
+			if request {
+				required = strings.Contains(field.Tag.Get("binding"), "required")
+			}
+			if required && !slices.Contains(schema.Required, name) {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+	}
+}
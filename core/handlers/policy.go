@@ -0,0 +1,47 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/types"
+)
+
+// errOverrideForbidden is returned when a user who isn't an admin asks to
+// override a publish policy.
+var errOverrideForbidden = errors.New("overriding the publish policy requires admin rights")
+
+// checkPolicy returns the status and error to answer with when release can't
+// be published to image, either because it violates the publish policy of the
+// image or because override is set by a user who isn't an admin.
+func (s *Server) checkPolicy(c *gin.Context, image *types.Image, release *types.Release, override bool) (int, error) {
+	if override {
+		user := c.GetString(gin.AuthUserKey)
+		if !s.Config.Auth.IsAdmin(user) {
+			return http.StatusForbidden, errOverrideForbidden
+		}
+		if image.Policy.Enabled() {
+			slog.Warn("Publish policy overridden", "image", image.Name, "release", release.Digest, "user", user)
+		}
+		return http.StatusOK, nil
+	}
+
+	compare, err := s.Comparators.Get(image.Comparator)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := image.CheckPolicy(s.DB, release, compare); err != nil {
+		return errorStatus(err), err
+	}
+
+	return http.StatusOK, nil
+}
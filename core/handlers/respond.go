@@ -0,0 +1,90 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/types"
+	"gorm.io/gorm"
+)
+
+// respond writes a successful response of the versioned API, wrapping data
+// in the "data" field. A nil data writes an empty response.
+func respond(c *gin.Context, status int, data any) {
+	if data == nil {
+		c.Status(status)
+		return
+	}
+
+	c.JSON(status, gin.H{"data": data})
+}
+
+// respondPage writes a page of a listing of the versioned API, alongside the
+// cursor of the next page unless it is the last one.
+func respondPage(c *gin.Context, data any, next string) {
+	response := gin.H{"data": data}
+	if next != "" {
+		response["next_cursor"] = next
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// respondError writes a failed response of the versioned API, listing the
+// violations of policy errors.
+func respondError(c *gin.Context, status int, err error) {
+	apiErr := api.Error{
+		Code:    api.ErrorCode(status),
+		Message: err.Error(),
+	}
+	var policyErr *types.PolicyError
+	if errors.As(err, &policyErr) {
+		apiErr.Violations = policyErr.Violations
+	}
+
+	c.AbortWithStatusJSON(status, gin.H{"error": apiErr})
+}
+
+// errorStatus returns the HTTP status matching an error of the types
+// package.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, types.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey), errors.Is(err, types.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, types.ErrInvalidQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, types.ErrPolicyViolation):
+		return http.StatusUnprocessableEntity
+	}
+
+	return http.StatusInternalServerError
+}
+
+// BasicAuth is gin.BasicAuth answering with an error of the versioned API
+// when credentials are missing or wrong.
+func BasicAuth(accounts gin.Accounts) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, password, ok := c.Request.BasicAuth()
+		if ok {
+			if expected, found := accounts[user]; found && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1 {
+				c.Set(gin.AuthUserKey, user)
+				return
+			}
+		}
+
+		c.Header("WWW-Authenticate", `Basic realm="Authorization Required"`)
+		respondError(c, http.StatusUnauthorized, errors.New("missing or invalid credentials"))
+	}
+}
@@ -0,0 +1,316 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/types"
+)
+
+// Route describes an endpoint of the versioned API. Both the router and the
+// OpenAPI document are built from the routes, so the documentation cannot
+// drift from what is served.
+type Route struct {
+	Method      string
+	Path        string // relative to the API prefix, with gin path parameters
+	OperationID string
+	Summary     string
+	// Auth marks routes modifying data or managing webhooks, which require
+	// authentication and are not registered in read-only mode.
+	Auth  bool
+	Query []Param
+	// Body and Data are sample values of the request body and of the "data"
+	// field of the response, from which schemas are generated. A nil Data
+	// means an empty response.
+	Body   any
+	Data   any
+	Status int
+	// Paginated marks listings, whose responses carry the cursor of the
+	// next page in "next_cursor".
+	Paginated bool
+	// Formats lists the media types the response can be rendered in besides
+	// JSON, as plain strings.
+	Formats []string
+	Errors  []int // error statuses besides those implied by the route
+	Handler gin.HandlerFunc
+}
+
+// Param is a query parameter of a Route.
+type Param struct {
+	Name        string
+	Description string
+	Required    bool
+	Type        string // "integer", "boolean" or "date-time", a plain string when empty
+}
+
+// pageParams returns the query parameters common to paginated listings,
+// sortable by the given columns, if any.
+func pageParams(columns string) []Param {
+	params := []Param{
+		{Name: "limit", Description: fmt.Sprintf("Maximum number of items, from 1 to %d, defaults to %d", types.MaxPageSize, types.DefaultPageSize), Type: "integer"},
+		{Name: "cursor", Description: "The next_cursor of the previous page"},
+	}
+	if columns == "" {
+		return params
+	}
+
+	return append([]Param{
+		{Name: "sort", Description: "Sort order, one of " + columns + ", prefixed by \"-\" for descending order"},
+	}, params...)
+}
+
+// v1Routes returns the routes of the versioned API.
+func (s *Server) v1Routes() []Route {
+	return []Route{
+		{
+			Method: http.MethodGet, Path: "/status", OperationID: "getStatus",
+			Summary: "Check whether the server is up",
+			Data:    api.Status{}, Status: http.StatusOK, Handler: s.v1Status,
+		},
+		{
+			Method: http.MethodGet, Path: "/packages", OperationID: "searchPackages",
+			Summary: "Search packages shipped by releases, sorted by name",
+			Query: append([]Param{
+				{Name: "name", Description: "Package name, or pattern depending on match", Required: true},
+				{Name: "match", Description: "How name is matched: exact (the default), prefix, or glob, where \"*\" matches any sequence of characters and \"?\" any single character"},
+				{Name: "version", Description: "Only find this version"},
+				{Name: "arch", Description: "Only find this architecture"},
+				{Name: "image", Description: "Only search the releases of this image"},
+			}, pageParams("")...),
+			Data: []types.PackageMatch{}, Status: http.StatusOK, Paginated: true, Handler: s.v1SearchPackages,
+		},
+		{
+			Method: http.MethodPost, Path: "/gc", OperationID: "runGC",
+			Summary: "Enforce retention policies and remove orphaned packages",
+			Auth:    true, Data: api.GCResult{}, Status: http.StatusOK, Handler: s.v1RunGC,
+		},
+		{
+			Method: http.MethodGet, Path: "/images", OperationID: "listImages",
+			Summary: "List images",
+			Query: append([]Param{
+				{Name: "prefix", Description: "Only list images whose name starts with prefix"},
+			}, pageParams("name (the default) or created")...),
+			Data: []types.Image{}, Status: http.StatusOK, Paginated: true, Handler: s.v1ListImages,
+		},
+		{
+			Method: http.MethodPost, Path: "/images", OperationID: "createImage",
+			Summary: "Create an image",
+			Auth:    true, Body: api.NewImage{}, Data: types.Image{}, Status: http.StatusCreated,
+			Errors: []int{http.StatusConflict}, Handler: s.v1AddImage,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name", OperationID: "getImage",
+			Summary: "Get an image",
+			Data:    types.Image{}, Status: http.StatusOK, Handler: s.v1GetImage,
+		},
+		{
+			Method: http.MethodPatch, Path: "/images/:name", OperationID: "updateImage",
+			Summary: "Edit an image",
+			Auth:    true, Body: types.ImageUpdate{}, Data: types.Image{}, Status: http.StatusOK,
+			Errors: []int{http.StatusConflict}, Handler: s.v1UpdateImage,
+		},
+		{
+			Method: http.MethodDelete, Path: "/images/:name", OperationID: "deleteImage",
+			Summary: "Delete an image and all of its releases",
+			Auth:    true, Status: http.StatusNoContent, Handler: s.v1DeleteImage,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/diff", OperationID: "diffReleases",
+			Summary: "Diff the packages of two releases of an image",
+			Query: []Param{
+				{Name: "from", Description: "Digest or tag of the old release, or \"latest\"", Required: true},
+				{Name: "to", Description: "Digest or tag of the new release, or \"latest\"", Required: true},
+				{Name: "format", Description: "Render the diff as a changelog in markdown, html or text, or as json (the default), overriding the Accept header"},
+				{Name: "highlight", Description: "With \"major\", highlight the packages whose epoch or major version changed in changelogs"},
+				{Name: "explain", Description: "Explain the added packages with the dependency chains that pulled them in", Type: "boolean"},
+				{Name: "sort", Description: "Sort the packages by \"name\" (the default), or by \"size\" for the biggest size change first"},
+				{Name: "hide", Description: "Comma-separated version changes whose upgrades and downgrades are left out, unless they fix vulnerabilities, among epoch, major, minor, patch, revision, rebuild and unknown"},
+			},
+			Data: api.Diff{}, Status: http.StatusOK, Formats: []string{mimeMarkdown, gin.MIMEHTML, gin.MIMEPlain},
+			Handler: s.v1Diff,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/diff/files", OperationID: "diffReleaseFiles",
+			Summary: "Diff the files of two releases of an image, both having file manifests",
+			Query: []Param{
+				{Name: "from", Description: "Digest or tag of the old release, or \"latest\"", Required: true},
+				{Name: "to", Description: "Digest or tag of the new release, or \"latest\"", Required: true},
+				{Name: "prefix", Description: "Only report the files whose path starts with prefix, such as /etc/"},
+			},
+			Data: api.FileDiff{}, Status: http.StatusOK, Handler: s.v1DiffFiles,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/releases", OperationID: "listReleases",
+			Summary: "List releases of an image, without their packages",
+			Query: append([]Param{
+				{Name: "since", Description: "Only list releases dated from this date, included", Type: "date-time"},
+				{Name: "until", Description: "Only list releases dated before this date", Type: "date-time"},
+			}, pageParams("date (the default is -date, newest first)")...),
+			Data: []types.Release{}, Status: http.StatusOK, Paginated: true, Handler: s.v1ListReleases,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/packages/:package/history", OperationID: "getPackageHistory",
+			Summary: "Get the versions of a package shipped by the non-yanked releases of an image, from oldest to newest",
+			Query: []Param{
+				{Name: "arch", Description: "Only report this architecture"},
+			},
+			Data: []types.VersionSpan{}, Status: http.StatusOK, Handler: s.v1PackageHistory,
+		},
+		{
+			Method: http.MethodPost, Path: "/images/:name/releases", OperationID: "createRelease",
+			Summary: "Create a release of an image, unless it violates the publish policy of the image",
+			Auth:    true, Body: api.NewRelease{}, Data: types.Release{}, Status: http.StatusCreated,
+			Errors: []int{http.StatusConflict, http.StatusForbidden, http.StatusUnprocessableEntity}, Handler: s.v1AddRelease,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/releases/:digest", OperationID: "getRelease",
+			Summary: "Get a release and its packages, by digest, tag or as \"latest\"",
+			Query: []Param{
+				{Name: "channel", Description: "With \"latest\", get the release tagged channel instead of the most recent one"},
+			},
+			Data: types.Release{}, Status: http.StatusOK, Handler: s.v1GetRelease,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/releases/:digest/vulnerabilities", OperationID: "getReleaseVulnerabilities",
+			Summary: "Summarize the known vulnerabilities affecting the packages of a release, by digest, tag or as \"latest\"",
+			Data:    api.VulnerabilitySummary{}, Status: http.StatusOK, Handler: s.v1ReleaseVulnerabilities,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/releases/:digest/licenses", OperationID: "getReleaseLicenses",
+			Summary: "Count the packages of a release under each license, by digest, tag or as \"latest\"",
+			Data:    api.LicenseSummary{}, Status: http.StatusOK, Handler: s.v1ReleaseLicenses,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/releases/:digest/packages/:package/reverse-dependencies", OperationID: "getReverseDependencies",
+			Summary: "List the packages of a release depending on a package, by digest, tag or as \"latest\"",
+			Data:    api.ReverseDependencies{}, Status: http.StatusOK, Handler: s.v1ReverseDependencies,
+		},
+		{
+			Method: http.MethodPut, Path: "/images/:name/releases/:digest/files", OperationID: "setReleaseFiles",
+			Summary: "Replace the file manifests of a release, by package",
+			Auth:    true, Body: api.ReleaseFiles{}, Data: api.FilesSummary{}, Status: http.StatusOK, Handler: s.v1SetReleaseFiles,
+		},
+		{
+			Method: http.MethodPatch, Path: "/images/:name/releases/:digest", OperationID: "updateRelease",
+			Summary: "Edit, yank or change the metadata of a release",
+			Auth:    true, Body: types.ReleaseUpdate{}, Data: types.Release{}, Status: http.StatusOK,
+			Errors: []int{http.StatusConflict}, Handler: s.v1UpdateRelease,
+		},
+		{
+			Method: http.MethodDelete, Path: "/images/:name/releases/:digest", OperationID: "deleteRelease",
+			Summary: "Delete a release",
+			Auth:    true, Status: http.StatusNoContent, Handler: s.v1DeleteRelease,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/versions/:version", OperationID: "getReleaseByVersion",
+			Summary: "Get a release and its packages by version label",
+			Data:    types.Release{}, Status: http.StatusOK, Handler: s.v1GetReleaseByVersion,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/tags", OperationID: "listTags",
+			Summary: "List the tags of an image, sorted by name",
+			Data:    []types.Tag{}, Status: http.StatusOK, Handler: s.v1ListTags,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/tags/:tag", OperationID: "getTag",
+			Summary: "Get a tag of an image",
+			Data:    types.Tag{}, Status: http.StatusOK, Handler: s.v1GetTag,
+		},
+		{
+			Method: http.MethodPut, Path: "/images/:name/tags/:tag", OperationID: "setTag",
+			Summary: "Point a tag at a release, creating the tag if needed",
+			Auth:    true, Body: api.SetTag{}, Data: types.Tag{}, Status: http.StatusOK,
+			Handler: s.v1SetTag,
+		},
+		{
+			Method: http.MethodDelete, Path: "/images/:name/tags/:tag", OperationID: "deleteTag",
+			Summary: "Delete a tag, keeping its history",
+			Auth:    true, Status: http.StatusNoContent, Handler: s.v1DeleteTag,
+		},
+		{
+			Method: http.MethodGet, Path: "/images/:name/tags/:tag/history", OperationID: "getTagHistory",
+			Summary: "List the past assignments of a tag, from newest to oldest",
+			Data:    []types.TagAssignment{}, Status: http.StatusOK, Handler: s.v1TagHistory,
+		},
+		{
+			Method: http.MethodGet, Path: "/webhooks", OperationID: "listWebhooks",
+			Summary: "List webhooks, without their secrets",
+			Auth:    true, Data: []types.Webhook{}, Status: http.StatusOK, Handler: s.v1ListWebhooks,
+		},
+		{
+			Method: http.MethodPost, Path: "/webhooks", OperationID: "createWebhook",
+			Summary: "Register a webhook, notified of the events of one image or of every image",
+			Auth:    true, Body: api.NewWebhook{}, Data: types.Webhook{}, Status: http.StatusCreated,
+			Errors: []int{http.StatusNotFound}, Handler: s.v1AddWebhook,
+		},
+		{
+			Method: http.MethodGet, Path: "/webhooks/:id", OperationID: "getWebhook",
+			Summary: "Get a webhook, without its secret",
+			Auth:    true, Data: types.Webhook{}, Status: http.StatusOK, Handler: s.v1GetWebhook,
+		},
+		{
+			Method: http.MethodDelete, Path: "/webhooks/:id", OperationID: "deleteWebhook",
+			Summary: "Delete a webhook and its deliveries",
+			Auth:    true, Status: http.StatusNoContent, Handler: s.v1DeleteWebhook,
+		},
+		{
+			Method: http.MethodGet, Path: "/webhooks/:id/deliveries", OperationID: "listWebhookDeliveries",
+			Summary: "List the deliveries of a webhook, newest first, without their payloads",
+			Auth:    true, Query: pageParams(""),
+			Data: []types.WebhookDelivery{}, Status: http.StatusOK, Paginated: true, Handler: s.v1ListDeliveries,
+		},
+		{
+			Method: http.MethodGet, Path: "/webhooks/:id/deliveries/:delivery", OperationID: "getWebhookDelivery",
+			Summary: "Get a delivery of a webhook and its payload",
+			Auth:    true, Data: types.WebhookDelivery{}, Status: http.StatusOK, Handler: s.v1GetDelivery,
+		},
+		{
+			Method: http.MethodPost, Path: "/webhooks/:id/deliveries/:delivery/redeliver", OperationID: "redeliverWebhook",
+			Summary: "Deliver the payload of a past delivery again, as a new delivery",
+			Auth:    true, Data: types.WebhookDelivery{}, Status: http.StatusAccepted, Handler: s.v1Redeliver,
+		},
+	}
+}
+
+// RegisterV1 registers the versioned API on group, alongside its OpenAPI
+// document at /openapi.json. Routes modifying data are guarded by
+// authRequired, or left out when readOnly is set.
+func (s *Server) RegisterV1(group *gin.RouterGroup, authRequired gin.HandlerFunc, readOnly bool) error {
+	routes := []Route{}
+	for _, route := range s.v1Routes() {
+		if route.Auth && readOnly {
+			continue
+		}
+		routes = append(routes, route)
+
+		handlers := []gin.HandlerFunc{route.Handler}
+		if route.Auth {
+			handlers = append([]gin.HandlerFunc{authRequired}, handlers...)
+		}
+		group.Handle(route.Method, route.Path, handlers...)
+	}
+
+	doc, err := openAPIDocument(group.BasePath(), routes)
+	if err != nil {
+		return err
+	}
+	spec, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	group.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", spec)
+	})
+
+	return nil
+}
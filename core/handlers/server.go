@@ -0,0 +1,67 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+
+	"github.com/vanilla-os/differ/changelog"
+	"github.com/vanilla-os/differ/core"
+	"github.com/vanilla-os/differ/diff"
+	"gorm.io/gorm"
+)
+
+// Server holds everything the handlers need to serve requests. Handlers are
+// methods on Server, so several independent servers can live in the same
+// process.
+type Server struct {
+	DB          *gorm.DB
+	Cache       *core.Cache
+	Comparators diff.Comparators
+	Changelogs  *changelog.Renderer
+	Webhooks    *core.Webhooks
+	Events      *core.EventBus
+	Config      core.Config
+}
+
+// NewServer loads the changelog templates and opens the storage and cache
+// described by config. Webhooks are delivered in the background until
+// Webhooks is closed.
+func NewServer(config core.Config) (*Server, error) {
+	changelogs, err := changelog.NewRenderer(config.Changelog.Templates)
+	if err != nil {
+		return nil, errors.New("Failed to load changelog templates: " + err.Error())
+	}
+
+	db, err := core.OpenStorage(config.Database.DSN)
+	if err != nil {
+		return nil, errors.New("Failed to init storage: " + err.Error())
+	}
+
+	// Create the admin user if the database has no authorized users yet
+	if config.Auth.AdminUser != "" {
+		if _, err := core.SeedAuthorization(db, config.Auth.AdminUser, config.Auth.AdminPassword); err != nil {
+			return nil, errors.New("Failed to create admin user: " + err.Error())
+		}
+	}
+
+	cache, err := core.NewCache(config.Cache)
+	if err != nil {
+		return nil, errors.New("Failed to init cache: " + err.Error())
+	}
+
+	return &Server{
+		DB:          db,
+		Cache:       cache,
+		Comparators: diff.DefaultComparators(),
+		Changelogs:  changelogs,
+		Webhooks:    core.NewWebhooks(db, config.Webhooks),
+		Events:      core.NewEventBus(int(config.Events.LogSize)),
+		Config:      config,
+	}, nil
+}
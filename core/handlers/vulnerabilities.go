@@ -0,0 +1,62 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/types"
+)
+
+func (s *Server) v1ReleaseVulnerabilities(c *gin.Context) {
+	image, err := types.FindImage(s.DB, c.Param("name"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	release, err := image.ResolveRelease(s.DB, c.Param("digest"))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+	compare, err := s.Comparators.Get(image.Comparator)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	summary := api.VulnerabilitySummary{Digest: release.Digest, BySeverity: map[string]int{}}
+	summary.Database, err = types.GetVulnerabilityImport(s.DB)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	summary.Vulnerabilities, err = types.MatchVulnerabilities(s.DB, compare, release.Packages)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Matches are sorted by ID, a vulnerability affecting several packages
+	// is only counted once
+	for i, match := range summary.Vulnerabilities {
+		if i > 0 && summary.Vulnerabilities[i-1].ID == match.ID {
+			continue
+		}
+		severity := match.Severity
+		if severity == "" {
+			severity = "unknown"
+		}
+		summary.Total++
+		summary.BySeverity[severity]++
+	}
+
+	respond(c, http.StatusOK, summary)
+}
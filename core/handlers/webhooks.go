@@ -0,0 +1,216 @@
+package handlers
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/types"
+)
+
+// notifyImage publishes the creation of image and notifies webhooks of it.
+// Failures are only logged, as the image exists regardless.
+func (s *Server) notifyImage(image *types.Image) {
+	s.publish(image, api.ActivityEvent{Event: types.EventImageCreated})
+	err := s.Webhooks.Notify(image.ID, types.EventImageCreated, func() (any, error) {
+		event := api.WebhookEvent{Event: types.EventImageCreated, Date: time.Now().UTC(), Image: *image}
+		event.Image.Releases = nil
+		return event, nil
+	})
+	if err != nil {
+		slog.Error("Failed to notify webhooks", "event", types.EventImageCreated, "image", image.Name, "error", err)
+	}
+}
+
+// notifyRelease publishes the creation of release and notifies webhooks of
+// it, along with its diff from the previous release. Failures are only
+// logged, as the release exists regardless.
+func (s *Server) notifyRelease(image *types.Image, release *types.Release) {
+	s.publish(image, api.ActivityEvent{Event: types.EventReleaseCreated, Release: withoutPackages(release)})
+	err := s.Webhooks.Notify(image.ID, types.EventReleaseCreated, func() (any, error) {
+		previous, err := image.GetPreviousRelease(s.DB, release)
+		if err != nil {
+			return nil, err
+		}
+		diffed := previous
+		if diffed == nil {
+			diffed = &types.Release{}
+		}
+		result, err := s.diffReleases(image, diffed, release)
+		if err != nil {
+			return nil, err
+		}
+
+		event := api.WebhookEvent{
+			Event:   types.EventReleaseCreated,
+			Date:    time.Now().UTC(),
+			Image:   *image,
+			Release: withoutPackages(release),
+			Diff:    result,
+		}
+		event.Image.Releases = nil
+		if previous != nil {
+			event.Previous = withoutPackages(previous)
+		}
+		return event, nil
+	})
+	if err != nil {
+		slog.Error("Failed to notify webhooks", "event", types.EventReleaseCreated, "image", image.Name, "release", release.Digest, "error", err)
+	}
+}
+
+// withoutPackages returns a copy of release without its packages.
+func withoutPackages(release *types.Release) *types.Release {
+	summary := *release
+	summary.Packages = nil
+	return &summary
+}
+
+// findWebhook returns the webhook given by the "id" path parameter, or
+// answers with an error and returns nil.
+func (s *Server) findWebhook(c *gin.Context) *types.Webhook {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return nil
+	}
+	hook, err := types.GetWebhook(s.DB, uint(id))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return nil
+	}
+
+	return hook
+}
+
+// findDelivery returns the delivery to hook given by the "delivery" path
+// parameter, or answers with an error and returns nil.
+func (s *Server) findDelivery(c *gin.Context, hook *types.Webhook) *types.WebhookDelivery {
+	id, err := strconv.ParseUint(c.Param("delivery"), 10, 0)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return nil
+	}
+	delivery, err := hook.GetDelivery(s.DB, uint(id))
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return nil
+	}
+
+	return delivery
+}
+
+func (s *Server) v1ListWebhooks(c *gin.Context) {
+	hooks, err := types.ListWebhooks(s.DB)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusOK, hooks)
+}
+
+func (s *Server) v1AddWebhook(c *gin.Context) {
+	var input api.NewWebhook
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	hook := types.Webhook{URL: input.URL, Secret: input.Secret, Events: input.Events}
+	if input.Image != "" {
+		image, err := types.FindImage(s.DB, input.Image)
+		if err != nil {
+			respondError(c, errorStatus(err), err)
+			return
+		}
+		hook.ImageID, hook.Image = image.ID, image.Name
+	}
+	if err := types.NewWebhook(s.DB, &hook); err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusCreated, hook)
+}
+
+func (s *Server) v1GetWebhook(c *gin.Context) {
+	if hook := s.findWebhook(c); hook != nil {
+		respond(c, http.StatusOK, hook)
+	}
+}
+
+func (s *Server) v1DeleteWebhook(c *gin.Context) {
+	hook := s.findWebhook(c)
+	if hook == nil {
+		return
+	}
+
+	if err := hook.Delete(s.DB); err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
+
+func (s *Server) v1ListDeliveries(c *gin.Context) {
+	hook := s.findWebhook(c)
+	if hook == nil {
+		return
+	}
+
+	var query types.DeliveryQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	deliveries, next, err := hook.ListDeliveries(s.DB, query)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respondPage(c, deliveries, next)
+}
+
+func (s *Server) v1GetDelivery(c *gin.Context) {
+	hook := s.findWebhook(c)
+	if hook == nil {
+		return
+	}
+
+	if delivery := s.findDelivery(c, hook); delivery != nil {
+		respond(c, http.StatusOK, delivery)
+	}
+}
+
+func (s *Server) v1Redeliver(c *gin.Context) {
+	hook := s.findWebhook(c)
+	if hook == nil {
+		return
+	}
+
+	delivery := s.findDelivery(c, hook)
+	if delivery == nil {
+		return
+	}
+	redelivery, err := s.Webhooks.Redeliver(hook, delivery)
+	if err != nil {
+		respondError(c, errorStatus(err), err)
+		return
+	}
+
+	respond(c, http.StatusAccepted, redelivery)
+}
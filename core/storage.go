@@ -10,55 +10,164 @@ package core
 import (
 	"errors"
 	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vanilla-os/differ/types"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
-var DB *gorm.DB
+// Authorization is a user allowed to manipulate images and releases through
+// the API.
+type Authorization struct {
+	ID   uint `gorm:"column:ID;primaryKey"`
+	Name string
+	Pass string
+}
+
+func (Authorization) TableName() string {
+	return "auth"
+}
+
+// OpenDialector returns the gorm dialector for a storage DSN. DSNs starting
+// with `postgres://` or `postgresql://` select PostgreSQL, while `sqlite://`
+// DSNs and plain paths select SQLite.
+func OpenDialector(dsn string) (gorm.Dialector, error) {
+	switch {
+	case dsn == "":
+		return nil, errors.New("empty storage DSN")
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return postgres.Open(dsn), nil
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return sqlite.Open(strings.TrimPrefix(dsn, "sqlite://")), nil
+	case strings.Contains(dsn, "://"):
+		return nil, fmt.Errorf("unsupported storage DSN scheme in %q", dsn)
+	default:
+		return sqlite.Open(dsn), nil
+	}
+}
+
+// OpenStorage opens the database from a given DSN, creating and migrating it
+// if necessary. See OpenDialector for the accepted DSNs.
+func OpenStorage(dsn string) (*gorm.DB, error) {
+	dialector, err := OpenDialector(dsn)
+	if err != nil {
+		return nil, err
+	}
 
-// InitStorage initializes the databse from a given path, creating it if necessary.
-func InitStorage(storagePath string) error {
-	db, err := gorm.Open(sqlite.Open(storagePath), &gorm.Config{TranslateError: true})
+	db, err := gorm.Open(dialector, &gorm.Config{
+		TranslateError: true,
+		// Missing records are reported to API users, not logged
+		Logger: logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{
+			SlowThreshold:             200 * time.Millisecond,
+			LogLevel:                  logger.Warn,
+			IgnoreRecordNotFoundError: true,
+			Colorful:                  true,
+		}),
+	})
 	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// migrate brings the database schema up to date.
+func migrate(db *gorm.DB) error {
+	// Packages used to be stored once per release. Older databases must have
+	// their duplicates merged before the identity index can be created.
+	migrator := db.Migrator()
+	if migrator.HasTable(&types.Package{}) && !migrator.HasIndex(&types.Package{}, "idx_packages_identity") {
+		if !migrator.HasColumn(&types.Package{}, "Arch") {
+			if err := migrator.AddColumn(&types.Package{}, "Arch"); err != nil {
+				return err
+			}
+		}
+		if err := types.DeduplicatePackages(db); err != nil {
+			return fmt.Errorf("failed to deduplicate packages: %v", err)
+		}
+	}
+
+	if migrator.HasTable(&types.Package{}) && !migrator.HasColumn(&types.Package{}, "Hash") {
+		if err := migrator.AddColumn(&types.Package{}, "Hash"); err != nil {
+			return err
+		}
+		if err := types.BackfillPackageHashes(db); err != nil {
+			return fmt.Errorf("failed to compute package hashes: %v", err)
+		}
+	}
+
+	// Release versions used to be only checked before writes, under a
+	// non-unique index replaced by idx_releases_unique_version.
+	if migrator.HasIndex(&types.Release{}, "idx_releases_image_version") {
+		if err := migrator.DropIndex(&types.Release{}, "idx_releases_image_version"); err != nil {
+			return err
+		}
+	}
+
+	// The auth table used to be created by hand, so it's only created here
+	// and never altered.
+	if !migrator.HasTable(&Authorization{}) {
+		if err := migrator.CreateTable(&Authorization{}); err != nil {
+			return err
+		}
+	}
+
+	if err := db.AutoMigrate(&types.Image{}, &types.Release{}, &types.Tag{}, &types.TagAssignment{}, &types.Webhook{}, &types.WebhookDelivery{}, &types.Vulnerability{}, &types.AffectedRange{}, &types.VulnerabilityImport{}, &types.Manifest{}, &types.ReleaseManifest{}); err != nil {
 		return err
 	}
 
-	db.AutoMigrate(&types.Image{}, &types.Release{})
+	return types.MigrateReleasePackages(db)
+}
 
-	DB = db
+// SeedAuthorization adds an authorized user if none exist yet, returning
+// whether it was added.
+func SeedAuthorization(db *gorm.DB, name, pass string) (bool, error) {
+	var count int64
+	if err := db.Model(&Authorization{}).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	return true, db.Create(&Authorization{Name: name, Pass: pass}).Error
+}
+
+// AddAuthorization adds an authorized user, failing if one with the same
+// name already exists.
+func AddAuthorization(db *gorm.DB, name, pass string) error {
+	var count int64
+	if err := db.Model(&Authorization{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("user %s already exists", name)
+	}
 
-	return nil
+	return db.Create(&Authorization{Name: name, Pass: pass}).Error
 }
 
 // FetchAuthorizations fetches from the database a Gin Accounts map for using in BasicAuth requests.
-// Authorizations must be added to the database beforehand in a table called `auth` with the following rows:
+// Authorizations are stored in a table called `auth`, which is created by
+// OpenStorage, with the following rows:
 //   - ID: int PK
 //   - name: text
 //   - pass: text
-//
-// The following statement can be used to create the auth table:
-//
-//	CREATE TABLE "auth" (
-//		"ID"	INTEGER,
-//		"name"	TEXT,
-//		"pass"	TEXT,
-//		PRIMARY KEY("ID")
-//	)
-func FetchAuthorizations() (gin.Accounts, error) {
-	// Assert database has been initialized
-	if DB == nil {
-		return nil, errors.New("db has not been initialized yet. You must call InitStorage first")
-	}
-
-	type AuthResult struct {
-		Name, Pass string
-	}
-
-	var result []AuthResult
-	err := DB.Raw("SELECT name, pass FROM auth").Scan(&result).Error
+func FetchAuthorizations(db *gorm.DB) (gin.Accounts, error) {
+	var result []Authorization
+	err := db.Find(&result).Error
 	if err != nil {
 		return nil, err
 	}
@@ -71,7 +180,7 @@ func FetchAuthorizations() (gin.Accounts, error) {
 	// Warn if auth database is empty.
 	// This isn't necessarily an error but we won't be able to add any images or releases via API.
 	if len(auths) == 0 {
-		fmt.Println("\033[1;33mWARN:\033[0m No authorized users found in database, starting API in read-only mode. If you intend to use the API for manipulating images/releases, please make sure to have at least one entry in the `auth` table.")
+		slog.Warn("No authorized users found in database, starting API in read-only mode. If you intend to use the API for manipulating images/releases, please make sure to have at least one entry in the `auth` table.")
 	}
 
 	return auths, nil
@@ -0,0 +1,197 @@
+package core
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vanilla-os/differ/types"
+	"gorm.io/gorm"
+)
+
+// Headers of webhook requests.
+const (
+	// EventHeader holds the event being notified.
+	EventHeader = "X-Differ-Event"
+	// DeliveryHeader holds the ID of the delivery, shared by its attempts.
+	DeliveryHeader = "X-Differ-Delivery"
+	// SignatureHeader holds the signature of the payload, see SignPayload.
+	SignatureHeader = "X-Differ-Signature-256"
+)
+
+// SignPayload returns the signature of a webhook payload, the hex-encoded
+// HMAC-SHA256 of the payload keyed by the secret of the webhook, prefixed by
+// "sha256=".
+func SignPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Webhooks delivers events to webhooks in the background. Failed deliveries
+// are retried with exponential backoff, and every delivery is recorded in
+// the database along with the outcome of its last attempt.
+type Webhooks struct {
+	db     *gorm.DB
+	config WebhooksConfig
+	client *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewWebhooks returns a Webhooks delivering the events of the images stored
+// in db, retrying failures as set by config.
+func NewWebhooks(db *gorm.DB, config WebhooksConfig) *Webhooks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Webhooks{
+		db:     db,
+		config: config,
+		client: &http.Client{Timeout: time.Duration(config.Timeout)},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Notify delivers event to the webhooks subscribed to it for the image with
+// the given ID. The payload is only built, by calling payload, when there is
+// at least one such webhook. Notify returns once the deliveries are
+// recorded, without waiting for them to complete.
+func (w *Webhooks) Notify(imageID uint, event string, payload func() (any, error)) error {
+	hooks, err := types.SubscribedWebhooks(w.db, imageID, event)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	content, err := payload()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	for i := range hooks {
+		delivery, err := hooks[i].NewDelivery(w.db, event, body, 0)
+		if err != nil {
+			return err
+		}
+		w.start(hooks[i], delivery)
+	}
+
+	return nil
+}
+
+// Redeliver delivers the payload of a past delivery to its webhook again, as
+// a new delivery, which is returned before it completes.
+func (w *Webhooks) Redeliver(hook *types.Webhook, delivery *types.WebhookDelivery) (*types.WebhookDelivery, error) {
+	redelivery, err := hook.NewDelivery(w.db, delivery.Event, delivery.Payload, delivery.ID)
+	if err != nil {
+		return nil, err
+	}
+	w.start(*hook, redelivery)
+
+	return redelivery, nil
+}
+
+// Close cancels ongoing attempts and pending retries, and waits for the
+// deliveries to stop. Deliveries interrupted this way are left pending.
+func (w *Webhooks) Close() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// start attempts the delivery in the background, on a copy of it so that
+// the caller can keep using its own.
+func (w *Webhooks) start(hook types.Webhook, delivery *types.WebhookDelivery) {
+	attempt := *delivery
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.deliver(hook, &attempt)
+	}()
+}
+
+// deliver attempts the delivery until it succeeds, the attempts run out or
+// Close is called.
+func (w *Webhooks) deliver(hook types.Webhook, delivery *types.WebhookDelivery) {
+	backoff := time.Duration(w.config.Backoff)
+	for {
+		delivery.Attempts++
+		status, err := w.post(hook, delivery)
+		delivery.ResponseStatus, delivery.Error = status, ""
+		switch {
+		case err == nil:
+			delivery.Status = types.DeliveryDelivered
+		case int64(delivery.Attempts) >= w.config.MaxAttempts:
+			delivery.Status = types.DeliveryFailed
+			fallthrough
+		default:
+			delivery.Error = err.Error()
+		}
+
+		if err := delivery.Record(w.db); err != nil {
+			slog.Error("Failed to record webhook delivery", "webhook", hook.ID, "delivery", delivery.ID, "error", err)
+		}
+		if delivery.Status != types.DeliveryPending {
+			if delivery.Status == types.DeliveryFailed {
+				slog.Warn("Webhook delivery failed", "webhook", hook.ID, "delivery", delivery.ID, "attempts", delivery.Attempts, "error", delivery.Error)
+			}
+			return
+		}
+
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends the payload of the delivery to the webhook, and returns the
+// status of the response, failing unless it is a 2xx one.
+func (w *Webhooks) post(hook types.Webhook, delivery *types.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Differ-Webhook")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(SignatureHeader, SignPayload(hook.Secret, delivery.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain the response so that the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
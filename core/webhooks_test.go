@@ -0,0 +1,128 @@
+package core
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+// receiver is a local webhook receiver failing the first failures requests.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if len(r.requests) <= r.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	db, err := OpenStorage(filepath.Join(t.TempDir(), "webhooks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := types.Image{Name: "webhooks", URL: "https://example.com/webhooks"}
+	if err := types.NewImage(db, &image); err != nil {
+		t.Fatal(err)
+	}
+
+	flaky, broken := &receiver{failures: 1}, &receiver{failures: 10}
+	flakyServer, brokenServer := httptest.NewServer(flaky), httptest.NewServer(broken)
+	defer flakyServer.Close()
+	defer brokenServer.Close()
+
+	hooks := []types.Webhook{
+		{URL: flakyServer.URL, Secret: "s3cret", Events: []string{types.EventReleaseCreated}},
+		{ImageID: image.ID, URL: brokenServer.URL, Secret: "other", Events: []string{types.EventReleaseCreated}},
+	}
+	for i := range hooks {
+		if err := types.NewWebhook(db, &hooks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	webhooks := NewWebhooks(db, WebhooksConfig{MaxAttempts: 3, Backoff: Duration(time.Millisecond), Timeout: Duration(time.Second)})
+	defer webhooks.Close()
+
+	err = webhooks.Notify(image.ID, types.EventImageCreated, func() (any, error) {
+		t.Fatal("payload built without subscribed webhooks")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = webhooks.Notify(image.ID, types.EventReleaseCreated, func() (any, error) {
+		return map[string]string{"release": "sha256:a"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks.wg.Wait()
+
+	// The flaky receiver gets the same delivery twice, signed with its secret
+	if len(flaky.requests) != 2 {
+		t.Fatalf("expected the flaky receiver to be retried once, got %d requests", len(flaky.requests))
+	}
+	for i, req := range flaky.requests {
+		if string(flaky.bodies[i]) != `{"release":"sha256:a"}` || req.Header.Get(EventHeader) != types.EventReleaseCreated || req.Header.Get(DeliveryHeader) != flaky.requests[0].Header.Get(DeliveryHeader) {
+			t.Errorf("unexpected request %d %v: %s", i, req.Header, flaky.bodies[i])
+		}
+		if signature := req.Header.Get(SignatureHeader); signature != SignPayload("s3cret", flaky.bodies[i]) {
+			t.Errorf("unexpected signature %s", signature)
+		}
+	}
+
+	deliveries, _, err := hooks[0].ListDeliveries(db, types.DeliveryQuery{})
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("unexpected deliveries %+v (%v)", deliveries, err)
+	}
+	if delivered := deliveries[0]; delivered.Status != types.DeliveryDelivered || delivered.Attempts != 2 || delivered.ResponseStatus != http.StatusOK || delivered.Error != "" {
+		t.Errorf("unexpected successful delivery %+v", delivered)
+	}
+
+	// The broken receiver runs out of attempts
+	deliveries, _, err = hooks[1].ListDeliveries(db, types.DeliveryQuery{})
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("unexpected deliveries %+v (%v)", deliveries, err)
+	}
+	failed, err := hooks[1].GetDelivery(db, deliveries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.Status != types.DeliveryFailed || failed.Attempts != 3 || failed.ResponseStatus != http.StatusInternalServerError || failed.Error == "" {
+		t.Errorf("unexpected failed delivery %+v", failed)
+	}
+
+	// Redeliveries are new deliveries of the same payload
+	redelivery, err := webhooks.Redeliver(&hooks[1], failed)
+	if err != nil || redelivery.ID == failed.ID || redelivery.RedeliveryOf != failed.ID {
+		t.Fatalf("unexpected redelivery %+v (%v)", redelivery, err)
+	}
+	webhooks.wg.Wait()
+	if len(broken.requests) != 6 || string(broken.bodies[5]) != `{"release":"sha256:a"}` || broken.requests[5].Header.Get(DeliveryHeader) == broken.requests[0].Header.Get(DeliveryHeader) {
+		t.Errorf("unexpected redelivery requests %d", len(broken.requests))
+	}
+}
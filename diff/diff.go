@@ -2,6 +2,7 @@ package diff
 
 import (
 	"cmp"
+	"fmt"
 	"regexp"
 	"strconv"
 	"sync"
@@ -15,34 +16,182 @@ const (
 
 type Package map[string]string
 
+// Comparator compares two package versions with the same semantics as
+// CompareVersions.
+type Comparator func(a, b string) int
+
+// DefaultComparator is the name of the comparator used by images that don't
+// choose one.
+const DefaultComparator = "default"
+
+// Comparators is a registry of version comparators indexed by name.
+type Comparators map[string]Comparator
+
+// DefaultComparators returns a registry holding the built-in comparators.
+func DefaultComparators() Comparators {
+	return Comparators{
+		DefaultComparator: CompareVersions,
+	}
+}
+
+// Get returns the comparator registered under name. An empty name selects
+// DefaultComparator.
+func (cs Comparators) Get(name string) (Comparator, error) {
+	if name == "" {
+		name = DefaultComparator
+	}
+
+	compare, ok := cs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown version comparator %s", name)
+	}
+
+	return compare, nil
+}
+
 type PackageDiff struct {
 	Name            string `json:"name"`
 	NewVersion      string `json:"new_version,omitempty"`
 	PreviousVersion string `json:"previous_version,omitempty"`
+	// Change is the most significant component changed by upgrades and
+	// downgrades.
+	Change Change `json:"change,omitempty"`
+	// Security is set by callers knowing about vulnerabilities when the
+	// change fixes at least one of them.
+	Security bool `json:"security,omitempty"`
+}
+
+// Change is the most significant component of a version changed between two
+// versions.
+type Change string
+
+const (
+	ChangeEpoch    Change = "epoch"
+	ChangeMajor    Change = "major"
+	ChangeMinor    Change = "minor"
+	ChangePatch    Change = "patch"
+	ChangeRevision Change = "revision" // packaging revision, such as the Debian revision
+	ChangeRebuild  Change = "rebuild"  // binNMU suffix only, such as +b1
+	ChangeUnknown  Change = "unknown"  // versions not understood by CompareVersions
+)
+
+// Changes lists every kind of change, from the most significant one.
+var Changes = []Change{ChangeEpoch, ChangeMajor, ChangeMinor, ChangePatch, ChangeRevision, ChangeRebuild, ChangeUnknown}
+
+// ParseChange returns the kind of change with the given name.
+func ParseChange(name string) (Change, error) {
+	for _, change := range Changes {
+		if string(change) == name {
+			return change, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown version change %q", name)
 }
 
 // This monstruosity is an adaptation of the regex for semver (available in https://semver.org/).
 // It SHOULD be able to capture every type of exoteric versioning scheme out there.
 var versionRegex = regexp.MustCompile(`^(?:(?P<prefix>\d+):)?(?P<major>\d+[a-zA-Z]?)(?:\.(?P<minor>\d+))?(?:\.(?P<patch>\d+))?(?:[-~](?P<prerelease>(?:\d+|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:\d+|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:[+.](?P<buildmetadata>[0-9a-zA-Z-+.~]+(?:\.[0-9a-zA-Z-]+)*))?$`)
 
-// compareVersions has the same behavior as cmp.Compare, but for package versions. It parses
-// both version strings and checks for differences in major, minor, patch, pre-release, etc.
-func CompareVersions(a, b string) int {
-	aMatchStr := versionRegex.FindStringSubmatch(a)
-	aMatch := make(map[string]string)
+// versionComponents returns the non-empty components of version matched by
+// versionRegex, indexed by name. Versions not matching it have none.
+func versionComponents(version string) map[string]string {
+	matchStr := versionRegex.FindStringSubmatch(version)
+	match := make(map[string]string)
+	if matchStr == nil {
+		return match
+	}
 	for i, name := range versionRegex.SubexpNames() {
-		if i != 0 && name != "" && aMatchStr[i] != "" {
-			aMatch[name] = aMatchStr[i]
+		if i != 0 && name != "" && matchStr[i] != "" {
+			match[name] = matchStr[i]
 		}
 	}
 
-	bMatchStr := versionRegex.FindStringSubmatch(b)
-	bMatch := make(map[string]string)
-	for i, name := range versionRegex.SubexpNames() {
-		if i != 0 && name != "" && bMatchStr[i] != "" {
-			bMatch[name] = bMatchStr[i]
+	return match
+}
+
+// binNMURegex matches the suffix of binary-only rebuilds of Debian packages,
+// such as +b1, at the end of the build metadata parsed by versionRegex.
+var binNMURegex = regexp.MustCompile(`(?:^|\+)b\d+$`)
+
+// sameComponent reports whether two values of a version component are equal,
+// numerically if both are numbers.
+func sameComponent(a, b string) bool {
+	aInt, aErr := strconv.Atoi(a)
+	bInt, bErr := strconv.Atoi(b)
+	if aErr == nil && bErr == nil {
+		return aInt == bInt
+	}
+
+	return a == b
+}
+
+// Classify returns the most significant component changed from oldVersion to
+// newVersion, as parsed by CompareVersions, or an empty change for versions
+// with equal components. The pre-release component is the packaging revision
+// of Debian, apk or rpm versions, such as the 1 of 2.7.3-1. Build metadata
+// differing only in their binNMU suffix make a rebuild, and otherwise a
+// revision, such as the +deb12u1 of Debian stable updates.
+func Classify(oldVersion, newVersion string) Change {
+	if oldVersion == newVersion {
+		return ""
+	}
+	oldMatch := versionComponents(oldVersion)
+	newMatch := versionComponents(newVersion)
+	if len(oldMatch) == 0 || len(newMatch) == 0 {
+		return ChangeUnknown
+	}
+
+	components := []struct {
+		name   string
+		change Change
+	}{
+		{"prefix", ChangeEpoch},
+		{"major", ChangeMajor},
+		{"minor", ChangeMinor},
+		{"patch", ChangePatch},
+		{"prerelease", ChangeRevision},
+	}
+	for _, comp := range components {
+		if !sameComponent(oldMatch[comp.name], newMatch[comp.name]) {
+			return comp.change
 		}
 	}
+	oldBuild, newBuild := oldMatch["buildmetadata"], newMatch["buildmetadata"]
+	switch {
+	case oldBuild == newBuild:
+		return ""
+	case binNMURegex.ReplaceAllString(oldBuild, "") != binNMURegex.ReplaceAllString(newBuild, ""):
+		return ChangeRevision
+	}
+
+	return ChangeRebuild
+}
+
+// Comparison is the structured result of comparing two versions.
+type Comparison struct {
+	Result int    // as returned by CompareVersions
+	Change Change // as returned by Classify
+}
+
+// Compare compares a and b with CompareVersions, and classifies the change
+// from a to b.
+func Compare(a, b string) Comparison {
+	return Comparison{Result: CompareVersions(a, b), Change: Classify(a, b)}
+}
+
+// MajorBump reports whether going from oldVersion to newVersion changes their
+// epoch prefix or major component, as parsed by CompareVersions.
+func MajorBump(oldVersion, newVersion string) bool {
+	change := Classify(oldVersion, newVersion)
+	return change == ChangeEpoch || change == ChangeMajor
+}
+
+// compareVersions has the same behavior as cmp.Compare, but for package versions. It parses
+// both version strings and checks for differences in major, minor, patch, pre-release, etc.
+func CompareVersions(a, b string) int {
+	aMatch := versionComponents(a)
+	bMatch := versionComponents(b)
 
 	compResult := 0
 
@@ -88,23 +237,29 @@ func CompareVersions(a, b string) int {
 	return compResult
 }
 
-func processPackage(pkg, oldVersion, newVersion string, c chan<- struct {
+func processPackage(compare Comparator, pkg, oldVersion, newVersion string, c chan<- struct {
 	PackageDiff
 	int
 }, wg *sync.WaitGroup,
 ) {
 	defer wg.Done()
 
-	result := CompareVersions(oldVersion, newVersion)
+	result := compare(oldVersion, newVersion)
 	c <- struct {
 		PackageDiff
 		int
-	}{PackageDiff{pkg, newVersion, oldVersion}, result}
+	}{PackageDiff{Name: pkg, NewVersion: newVersion, PreviousVersion: oldVersion, Change: Classify(oldVersion, newVersion)}, result}
 }
 
 // PackageDiff returns the difference in packages between two images, organized into
 // four slices: Added, Upgraded, Downgraded, and Removed packages, respectively.
 func DiffPackages(oldPackages, newPackages Package) ([]PackageDiff, []PackageDiff, []PackageDiff, []PackageDiff) {
+	return DiffPackagesWith(CompareVersions, oldPackages, newPackages)
+}
+
+// DiffPackagesWith behaves like DiffPackages, but compares versions with the
+// given comparator.
+func DiffPackagesWith(compare Comparator, oldPackages, newPackages Package) ([]PackageDiff, []PackageDiff, []PackageDiff, []PackageDiff) {
 	var wg sync.WaitGroup
 	c := make(chan struct {
 		PackageDiff
@@ -115,12 +270,12 @@ func DiffPackages(oldPackages, newPackages Package) ([]PackageDiff, []PackageDif
 		wg.Add(1)
 
 		if oldVersion, ok := oldPackages[pkg]; ok {
-			go processPackage(pkg, oldVersion, newVersion, c, &wg)
+			go processPackage(compare, pkg, oldVersion, newVersion, c, &wg)
 		} else {
 			c <- struct {
 				PackageDiff
 				int
-			}{PackageDiff{pkg, newVersion, ""}, Added}
+			}{PackageDiff{Name: pkg, NewVersion: newVersion}, Added}
 			wg.Done()
 		}
 	}
@@ -130,7 +285,7 @@ func DiffPackages(oldPackages, newPackages Package) ([]PackageDiff, []PackageDif
 	go func() {
 		for pkg, version := range oldPackages {
 			if _, ok := newPackages[pkg]; !ok {
-				removed = append(removed, PackageDiff{pkg, "", version})
+				removed = append(removed, PackageDiff{Name: pkg, PreviousVersion: version})
 			}
 		}
 		wg.Done()
@@ -23,8 +23,8 @@ func TestDiffPackages(t *testing.T) {
 		added, upgraded, downgraded, removed []PackageDiff
 	}{
 		[]PackageDiff{{Name: "libei1", NewVersion: "1.2.1-1"}},
-		[]PackageDiff{{Name: "xdg-desktop-portal-gnome", NewVersion: "46.2-1", PreviousVersion: "44.2-4+b1"}},
-		[]PackageDiff{{Name: "libdisplay-info1", NewVersion: "0.1.1-1", PreviousVersion: "0.1.1-2+b1"}},
+		[]PackageDiff{{Name: "xdg-desktop-portal-gnome", NewVersion: "46.2-1", PreviousVersion: "44.2-4+b1", Change: ChangeMajor}},
+		[]PackageDiff{{Name: "libdisplay-info1", NewVersion: "0.1.1-1", PreviousVersion: "0.1.1-2+b1", Change: ChangeRevision}},
 		[]PackageDiff{{Name: "libwinpr2-2t64", PreviousVersion: "2.11.5+dfsg1-1"}},
 	}
 
@@ -47,3 +47,57 @@ func TestDiffPackages(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestMajorBump(t *testing.T) {
+	cases := []struct {
+		old, new string
+		major    bool
+	}{
+		{"44.2-4+b1", "46.2-1", true},
+		{"0.1.1-2+b1", "0.1.1-1", false},
+		{"2.36-9", "2.37-1", false},
+		{"1:2.0", "2:2.0", true},
+		{"1.0", "not a version!", false},
+	}
+
+	for _, c := range cases {
+		if major := MajorBump(c.old, c.new); major != c.major {
+			t.Errorf("MajorBump(%q, %q) should be %t", c.old, c.new, c.major)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		old, new string
+		change   Change
+	}{
+		{"2.7.3", "2.7.3", ""},
+		{"1:2.0", "2:2.0", ChangeEpoch},
+		{"2.0", "1:2.0", ChangeEpoch},
+		{"44.2-4+b1", "46.2-1", ChangeMajor},
+		{"2.36-9", "2.37-1", ChangeMinor},
+		{"2.7.3", "2.7.4", ChangePatch},
+		{"2.7.03", "2.7.3", ""},
+		{"0.1.1-2+b1", "0.1.1-1", ChangeRevision},
+		{"1.2.3-r0", "1.2.3-r1", ChangeRevision},
+		{"2.36-9+deb12u3", "2.36-9+deb12u4", ChangeRevision},
+		{"2.11.5+dfsg1-1", "2.11.5+dfsg1-2", ChangeRevision},
+		{"2.7.3-1", "2.7.3-1+b1", ChangeRebuild},
+		{"2.11.5+dfsg1-1+b1", "2.11.5+dfsg1-1+b2", ChangeRebuild},
+		{"1.0", "not a version!", ChangeUnknown},
+	}
+
+	for _, c := range cases {
+		if change := Classify(c.old, c.new); change != c.change {
+			t.Errorf("Classify(%q, %q) should be %q, got %q", c.old, c.new, c.change, change)
+		}
+	}
+
+	if comparison := Compare("2.7.3", "2.8"); comparison != (Comparison{Result: Upgraded, Change: ChangeMinor}) {
+		t.Errorf("unexpected comparison %+v", comparison)
+	}
+	if _, err := ParseChange("security"); err == nil {
+		t.Error("ParseChange should reject unknown changes")
+	}
+}
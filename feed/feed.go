@@ -0,0 +1,191 @@
+// Package feed writes Atom and RSS feeds, used to follow the releases of an
+// image from a feed reader.
+package feed
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Format is a syndication format.
+type Format string
+
+const (
+	Atom Format = "atom"
+	RSS  Format = "rss"
+)
+
+// ContentType returns the media type of feeds written in the format.
+func (f Format) ContentType() string {
+	if f == RSS {
+		return "application/rss+xml; charset=utf-8"
+	}
+
+	return "application/atom+xml; charset=utf-8"
+}
+
+// Feed is a list of entries, newest first.
+type Feed struct {
+	// ID identifies the feed permanently, and must be an IRI.
+	ID    string
+	Title string
+	// Link is the URL of the resource described by the feed, and Self the
+	// URL of the feed itself.
+	Link    string
+	Self    string
+	Updated time.Time
+	Entries []Entry
+}
+
+// Entry is an item of a feed.
+type Entry struct {
+	// ID identifies the entry permanently, so that readers don't show it
+	// twice, and must be an IRI.
+	ID      string
+	Title   string
+	Link    string
+	Updated time.Time
+	// Content is an HTML fragment.
+	Content string
+}
+
+// Write writes the feed to w in the given format.
+func (f *Feed) Write(w io.Writer, format Format) error {
+	var doc any
+	switch format {
+	case Atom:
+		doc = f.atom()
+	case RSS:
+		doc = f.rss()
+	default:
+		return fmt.Errorf("unknown feed format %q", format)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Documents as serialized by encoding/xml, following RFC 4287 for Atom and
+// the RSS 2.0 specification.
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func (f *Feed) atom() *atomFeed {
+	doc := &atomFeed{
+		ID:      f.ID,
+		Title:   f.Title,
+		Links:   []atomLink{{Href: f.Self, Rel: "self", Type: "application/atom+xml"}, {Href: f.Link, Rel: "alternate"}},
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Entries: make([]atomEntry, 0, len(f.Entries)),
+	}
+	for _, entry := range f.Entries {
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:      entry.ID,
+			Title:   entry.Title,
+			Link:    atomLink{Href: entry.Link, Rel: "alternate"},
+			Updated: entry.Updated.UTC().Format(time.RFC3339),
+			Content: atomContent{Type: "html", Body: entry.Content},
+		})
+	}
+
+	return doc
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (f *Feed) rss() *rssFeed {
+	doc := &rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Title,
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+			Self:          atomLink{Href: f.Self, Rel: "self", Type: "application/rss+xml"},
+			Items:         make([]rssItem, 0, len(f.Entries)),
+		},
+	}
+	for _, entry := range f.Entries {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			GUID:        rssGUID{Value: entry.ID},
+			PubDate:     entry.Updated.UTC().Format(time.RFC1123Z),
+			Description: entry.Content,
+		})
+	}
+
+	return doc
+}
@@ -0,0 +1,80 @@
+package feed
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFeed() *Feed {
+	date := time.Date(2024, 2, 1, 12, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+	return &Feed{
+		ID:      "urn:differ:image:pico",
+		Title:   "pico releases",
+		Link:    "https://differ.example.com/v1/images/pico",
+		Self:    "https://differ.example.com/images/pico/feed.atom",
+		Updated: date,
+		Entries: []Entry{{
+			ID:      "urn:differ:image:pico:release:sha256:b",
+			Title:   "pico 2.0",
+			Link:    "https://differ.example.com/v1/images/pico/diff?from=sha256%3Aa&to=sha256%3Ab",
+			Updated: date,
+			Content: "<p>Upgraded <code>apt</code> & more</p>",
+		}},
+	}
+}
+
+func TestAtom(t *testing.T) {
+	var out strings.Builder
+	if err := newTestFeed().Write(&out, Atom); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc atomFeed
+	if err := xml.Unmarshal([]byte(out.String()), &doc); err != nil {
+		t.Fatalf("invalid Atom feed: %v\n%s", err, out.String())
+	}
+	if doc.XMLName.Space != "http://www.w3.org/2005/Atom" || doc.Updated != "2024-02-01T10:00:00Z" || len(doc.Entries) != 1 {
+		t.Fatalf("unexpected Atom feed %+v", doc)
+	}
+	entry := doc.Entries[0]
+	if entry.ID != "urn:differ:image:pico:release:sha256:b" || entry.Content.Type != "html" || entry.Content.Body != "<p>Upgraded <code>apt</code> & more</p>" {
+		t.Errorf("unexpected Atom entry %+v", entry)
+	}
+}
+
+func TestRSS(t *testing.T) {
+	var out strings.Builder
+	if err := newTestFeed().Write(&out, RSS); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `<atom:link href="https://differ.example.com/images/pico/feed.atom" rel="self" type="application/rss+xml"></atom:link>`) {
+		t.Errorf("RSS feed should link to itself:\n%s", out.String())
+	}
+
+	var doc rssFeed
+	if err := xml.Unmarshal([]byte(out.String()), &doc); err != nil {
+		t.Fatalf("invalid RSS feed: %v\n%s", err, out.String())
+	}
+	if doc.Version != "2.0" || doc.Channel.LastBuildDate != "Thu, 01 Feb 2024 10:00:00 +0000" || len(doc.Channel.Items) != 1 {
+		t.Fatalf("unexpected RSS feed %+v", doc)
+	}
+	item := doc.Channel.Items[0]
+	if item.GUID.IsPermaLink || item.GUID.Value != "urn:differ:image:pico:release:sha256:b" || item.Description != "<p>Upgraded <code>apt</code> & more</p>" {
+		t.Errorf("unexpected RSS item %+v", item)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if err := newTestFeed().Write(&strings.Builder{}, "json"); err == nil {
+		t.Error("unknown format should be rejected")
+	}
+}
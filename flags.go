@@ -0,0 +1,126 @@
+package main
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/vanilla-os/differ/core"
+	"github.com/vanilla-os/differ/types"
+)
+
+// bindConfigFlags registers the configuration flags on fs. The returned
+// function must be called after fs is parsed and returns the validated
+// configuration, with flags taking precedence over the configuration file
+// and environment variables.
+func bindConfigFlags(fs *flag.FlagSet) func() (core.Config, error) {
+	configPath := fs.String("config", os.Getenv("DIFFER_CONFIG"), "path to a YAML or TOML configuration `file`")
+	listen := fs.String("listen", "", "`address` the HTTP server binds to")
+	dsn := fs.String("dsn", "", "storage database path or DSN")
+	logLevel := fs.String("log-level", "", "one of debug, info, warn or error")
+	readOnly := fs.Bool("read-only", false, "disable endpoints that modify images or releases")
+	trustedProxies := fs.String("trusted-proxies", "", "comma-separated IPs or CIDRs allowed to set forwarding headers")
+	publicURL := fs.String("public-url", "", "`URL` clients reach the server at, used for the links of feeds")
+	authMode := fs.String("auth-mode", "", "authentication mode for write endpoints, basic or none")
+	gcInterval := fs.Duration("gc-interval", 0, "how often to run the garbage collector, 0 to disable")
+	cacheMaxCost := fs.Int64("cache-max-cost", 0, "maximum cost of the diff cache")
+	cacheTTL := fs.Duration("cache-ttl", 0, "how long diffs are cached, 0 to keep them until evicted")
+	changelogTemplates := fs.String("changelog-templates", "", "`directory` of templates replacing the default changelog templates")
+
+	return func() (core.Config, error) {
+		config, err := core.LoadConfig(*configPath)
+		if err != nil {
+			return config, err
+		}
+
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "listen":
+				config.Listen = *listen
+			case "dsn":
+				config.Database.DSN = *dsn
+			case "log-level":
+				config.LogLevel = *logLevel
+			case "read-only":
+				config.ReadOnly = *readOnly
+			case "trusted-proxies":
+				config.TrustedProxies = core.SplitList(*trustedProxies)
+			case "public-url":
+				config.PublicURL = *publicURL
+			case "auth-mode":
+				config.Auth.Mode = *authMode
+			case "gc-interval":
+				config.GCInterval = core.Duration(*gcInterval)
+			case "cache-max-cost":
+				config.Cache.MaxCost = *cacheMaxCost
+			case "cache-ttl":
+				config.Cache.TTL = core.Duration(*cacheTTL)
+			case "changelog-templates":
+				config.Changelog.Templates = *changelogTemplates
+			}
+		})
+
+		return config, config.Validate()
+	}
+}
+
+// bindDSNFlag registers the -dsn flag on fs. The returned function must
+// be called after fs is parsed and returns the storage DSN, read from the
+// configuration file given by DIFFER_CONFIG and the environment unless the
+// flag is set.
+func bindDSNFlag(fs *flag.FlagSet) func() (string, error) {
+	dsn := fs.String("dsn", "", "storage database path or DSN")
+
+	return func() (string, error) {
+		if *dsn != "" {
+			return *dsn, nil
+		}
+
+		config, err := core.LoadConfig(os.Getenv("DIFFER_CONFIG"))
+		if err != nil {
+			return "", err
+		}
+
+		if config.Database.DSN == "" {
+			return "", errors.New("database.dsn: no database was provided")
+		}
+
+		return config.Database.DSN, nil
+	}
+}
+
+// bindMetadataFlags registers the release metadata flags on fs. The returned
+// function must be called after fs is parsed and returns the metadata, with
+// the notes read from the given file.
+func bindMetadataFlags(fs *flag.FlagSet) func() (types.ReleaseMetadata, error) {
+	var metadata types.ReleaseMetadata
+	fs.StringVar(&metadata.Version, "version", "", "human version label of the release, such as 2.1.3")
+	fs.StringVar(&metadata.BuildID, "build-id", "", "identifier of the build that produced the release")
+	fs.StringVar(&metadata.GitCommit, "git-commit", "", "commit of the recipe the release was built from")
+	fs.StringVar(&metadata.Kernel, "kernel", "", "kernel version shipped by the release")
+	fs.StringVar(&metadata.BaseSnapshot, "base-snapshot", "", "snapshot of the base distribution")
+	notesPath := fs.String("notes", "", "Markdown `file` with the release notes, - for stdin")
+
+	return func() (types.ReleaseMetadata, error) {
+		var notes []byte
+		var err error
+		switch *notesPath {
+		case "":
+		case "-":
+			notes, err = io.ReadAll(os.Stdin)
+		default:
+			notes, err = os.ReadFile(*notesPath)
+		}
+		metadata.Notes = string(notes)
+
+		return metadata, err
+	}
+}
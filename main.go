@@ -9,113 +9,152 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"os"
-	"os/exec"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vanilla-os/differ/core"
 	"github.com/vanilla-os/differ/core/handlers"
 )
 
-func setupRouter(dbPath string) (*gin.Engine, error) {
-	// Create database if it doesn't exist
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		dbUser, ok := os.LookupEnv("admin_user")
-		if !ok {
-			return nil, errors.New("admin_user environment variable not found")
-		}
-
-		dbPass, ok := os.LookupEnv("admin_password")
-		if !ok {
-			return nil, errors.New("admin_password environment variable not found")
-		}
-
-		err = exec.Command(
-			"sh",
-			"-c",
-			fmt.Sprintf("sqlite3 %s \"create table auth(ID INTEGER, name, pass TEXT, PRIMARY KEY(ID)); insert into auth values(1, '%s', '%s');\"", dbPath, dbUser, dbPass),
-		).Run()
+func setupRouter(server *handlers.Server) (*gin.Engine, error) {
+	// Fetches authentications from storage
+	var auths gin.Accounts
+	if server.Config.Auth.Mode == core.AuthBasic && !server.Config.ReadOnly {
+		var err error
+		auths, err = core.FetchAuthorizations(server.DB)
 		if err != nil {
-			return nil, err
+			return nil, errors.New("Failed to fetch authorizations from storage: " + err.Error())
 		}
 	}
 
-	// Initialize storage database
-	err := core.InitStorage(dbPath)
-	if err != nil {
-		return nil, errors.New("Failed to init storage: " + err.Error())
+	// Endpoints modifying images or releases are disabled when Config.ReadOnly
+	// is set, or with basic auth when no user is authorized. Auth mode none
+	// leaves them open to anyone.
+	readOnly := server.Config.ReadOnly
+	var authRequired, v1AuthRequired gin.HandlerFunc
+	switch server.Config.Auth.Mode {
+	case core.AuthNone:
+		authRequired = func(c *gin.Context) { c.Next() }
+		v1AuthRequired = authRequired
+	default:
+		readOnly = readOnly || len(auths) == 0
+		if !readOnly {
+			authRequired = gin.BasicAuth(auths)
+			v1AuthRequired = handlers.BasicAuth(auths)
+		}
 	}
 
-	// Initialize cache
-	err = core.InitCache()
-	if err != nil {
-		return nil, errors.New("Failed to init cache: " + err.Error())
+	r := gin.New()
+	r.Use(gin.Recovery())
+	if server.Config.SlogLevel() <= slog.LevelInfo {
+		r.Use(gin.Logger())
 	}
-
-	// Fetches authentications from storage
-	auths, err := core.FetchAuthorizations()
-	if err != nil {
-		return nil, errors.New("Failed to fetch authorizations from storage: " + err.Error())
+	if err := r.SetTrustedProxies(server.Config.TrustedProxies); err != nil {
+		return nil, errors.New("Failed to set trusted proxies: " + err.Error())
 	}
 
-	// If auths is empty, we run the API in "read-only" mode.
-	// In other words, we won't be able to add any images or releases via the API.
-	readOnly := len(auths) == 0
-
-	var authRequired gin.HandlerFunc
-	if !readOnly {
-		authRequired = gin.BasicAuth(auths)
+	// Versioned API, documented at /v1/openapi.json
+	if err := server.RegisterV1(r.Group("/v1"), v1AuthRequired, readOnly); err != nil {
+		return nil, errors.New("Failed to register API: " + err.Error())
 	}
 
-	r := gin.Default()
-	r.SetTrustedProxies(nil)
-
+	// Unversioned endpoints, kept for existing clients
 	// Endpoint to check if API is running
-	r.GET("/status", handlers.HandleStatus)
+	r.GET("/status", server.HandleStatus)
+	// Streams the activity of images as Server-Sent Events
+	r.GET("/events", server.HandleGetEvents)
+	// Enforces retention policies and removes orphaned packages (Auth required)
+	if !readOnly {
+		r.POST("/gc", authRequired, server.HandleRunGC)
+	}
 
 	// Manipulate images
 	images := r.Group("/images")
 	{
 		// List all images
-		images.GET("/", handlers.HandleGetImages)
+		images.GET("/", server.HandleGetImages)
 		// List specific image
-		images.GET("/:name", handlers.HandleFindImage)
+		images.GET("/:name", server.HandleFindImage)
 		// Creates new image (Auth required)
 		if !readOnly {
-			images.POST("/new", authRequired, handlers.HandleAddImage)
+			images.POST("/new", authRequired, server.HandleAddImage)
+			// Edits or deletes an image and all its releases (Auth required)
+			images.PATCH("/:name", authRequired, server.HandleUpdateImage)
+			images.DELETE("/:name", authRequired, server.HandleDeleteImage)
 		}
 
 		// Release-related endpoints
 		// Diffs two releases
-		images.GET("/:name/diff", handlers.HandleGetReleaseDiff)
+		images.GET("/:name/diff", server.HandleGetReleaseDiff)
 		// Gets latest release
-		images.GET("/:name/latest", handlers.HandleGetLatestRelease)
+		images.GET("/:name/latest", server.HandleGetLatestRelease)
+		// Feeds of the latest releases and their changes
+		images.GET("/:name/feed.atom", server.HandleGetAtomFeed)
+		images.GET("/:name/feed.rss", server.HandleGetRSSFeed)
 		// Gets specific release with digest
-		images.GET("/:name/:digest", handlers.HandleFindRelease)
+		images.GET("/:name/:digest", server.HandleFindRelease)
+		// Gets specific release with version label
+		images.GET("/:name/versions/:version", server.HandleFindReleaseByVersion)
 		// Creates new release (Auth required)
 		if !readOnly {
-			images.POST("/:name/new", authRequired, handlers.HandleAddRelease)
+			images.POST("/:name/new", authRequired, server.HandleAddRelease)
+			// Edits, yanks or deletes a release (Auth required)
+			images.PATCH("/:name/:digest", authRequired, server.HandleUpdateRelease)
+			images.DELETE("/:name/:digest", authRequired, server.HandleDeleteRelease)
 		}
 	}
 
 	return r, nil
 }
 
+// commands is the root of the CLI command tree.
+var commands = &command{
+	name: "differ",
+	subcommands: []*command{
+		serveCommand,
+		configCommand,
+		imageCommand,
+		releaseCommand,
+		diffCommand,
+		publishCommand,
+		userCommand,
+		dbCommand,
+		vulnCommand,
+	},
+}
+
+// isLegacyServeArg reports whether arg, the first command line argument, is a
+// flag or database path rather than a command.
+func isLegacyServeArg(arg string) bool {
+	if commands.find(arg) != nil || arg == "help" || arg == "-h" || arg == "-help" || arg == "--help" {
+		return false
+	}
+
+	return strings.HasPrefix(arg, "-") || strings.ContainsAny(arg, "/.:")
+}
+
 func main() {
-	var dbPath string
-	if len(os.Args) > 1 {
-		dbPath = os.Args[1]
-	} else if dbPathArg, ok := os.LookupEnv("db_path"); ok {
-		dbPath = dbPathArg
+	args := os.Args[1:]
+
+	// Running without a command, or with only flags and a database path as
+	// older versions did, starts the server.
+	var err error
+	if len(args) == 0 || isLegacyServeArg(args[0]) {
+		err = runServe(args)
 	} else {
-		panic("No path to DB was provided. You must either pass it as a positional argument or by setting the 'db_path' environment variable")
+		err = commands.execute("differ", args)
 	}
 
-	router, err := setupRouter(dbPath)
-	if err != nil {
-		panic(err)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
-
-	router.Run()
 }
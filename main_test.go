@@ -8,42 +8,61 @@ package main
  */
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"os/exec"
+	"strings"
 	"testing"
-)
 
-var testDBPath string = "test.db"
+	"github.com/gin-gonic/gin"
+	"github.com/vanilla-os/differ/api"
+	"github.com/vanilla-os/differ/core"
+	"github.com/vanilla-os/differ/core/handlers"
+	"github.com/vanilla-os/differ/types"
+)
 
 func TestMain(m *testing.M) {
-	_, err := exec.LookPath("sqlite3")
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// newTestServer returns a server backed by its own in-memory database, with
+// an `admin:admin` authorized user, and its router.
+func newTestServer(t *testing.T) (*handlers.Server, *gin.Engine) {
+	config := core.DefaultConfig()
+	config.LogLevel = "warn"
+	config.Database.DSN = fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	config.Auth.AdminUser = "admin"
+	config.Auth.AdminPassword = "admin"
+
+	server, err := handlers.NewServer(config)
 	if err != nil {
-		panic("sqlite3 binary not found.")
+		t.Fatal(err)
 	}
+	t.Cleanup(func() {
+		server.Webhooks.Close()
+		if sqlDB, err := server.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
 
-	err = exec.Command(
-		"sh",
-		"-c",
-		fmt.Sprintf("sqlite3 %s \"create table auth(ID INTEGER, name, pass TEXT, PRIMARY KEY(ID)); insert into auth values(1, 'admin', 'admin');\"", testDBPath),
-	).Run()
+	router, err := setupRouter(server)
 	if err != nil {
-		os.Remove(testDBPath)
-		panic("error setting up test db: " + err.Error())
+		t.Fatal(err)
 	}
 
-	status := m.Run()
-	os.Remove(testDBPath)
-	os.Exit(status)
+	return server, router
 }
 
 func TestServer(t *testing.T) {
-	router, err := setupRouter(testDBPath)
-	if err != nil {
-		t.Fatal(err)
-	}
+	t.Parallel()
+	_, router := newTestServer(t)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "/status", nil)
@@ -57,3 +76,375 @@ func TestServer(t *testing.T) {
 		t.Fatalf("/status request returned unexpected body '%s'", w.Body.String())
 	}
 }
+
+// doRequest performs an authenticated request against router and returns the recorded response.
+func doRequest(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, reader)
+	req.SetBasicAuth("admin", "admin")
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestReleaseLifecycle(t *testing.T) {
+	t.Parallel()
+	_, router := newTestServer(t)
+
+	steps := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPost, "/images/new", `{"name":"lifecycle","url":"https://example.com/lifecycle"}`, http.StatusOK},
+		{http.MethodPost, "/images/lifecycle/new", `{"digest":"sha256:old","date":"2024-01-01T00:00:00Z","version":"1.0","packages":[{"name":"apt","version":"2.7.3"}]}`, http.StatusOK},
+		{http.MethodPost, "/images/lifecycle/new", `{"digest":"sha256:new","date":"2024-02-01T00:00:00Z","packages":[{"name":"apt","version":"2.7.4"}]}`, http.StatusOK},
+		{http.MethodPost, "/images/lifecycle/new", `{"digest":"sha256:dup","version":"1.0","packages":[{"name":"apt","version":"2.7.3"}]}`, http.StatusBadRequest},
+		{http.MethodGet, "/images/lifecycle/versions/1.0", "", http.StatusOK},
+		{http.MethodGet, "/images/lifecycle/versions/2.0", "", http.StatusBadRequest},
+		{http.MethodPatch, "/images/lifecycle/sha256:new", `{"yanked":true}`, http.StatusOK},
+	}
+	for _, step := range steps {
+		if w := doRequest(router, step.method, step.path, step.body); w.Code != step.status {
+			t.Fatalf("%s %s returned status '%d': %s", step.method, step.path, w.Code, w.Body.String())
+		}
+	}
+
+	// Yanked releases are hidden from /latest...
+	var latest struct {
+		Release struct{ Digest string }
+	}
+	w := doRequest(router, http.MethodGet, "/images/lifecycle/latest", "")
+	json.Unmarshal(w.Body.Bytes(), &latest)
+	if latest.Release.Digest != "sha256:old" {
+		t.Fatalf("expected latest release to skip yanked release, got '%s'", latest.Release.Digest)
+	}
+
+	// ...and from the tags and channels pointing to them...
+	if w = doRequest(router, http.MethodPut, "/v1/images/lifecycle/tags/nightly", `{"digest":"sha256:new"}`); w.Code != http.StatusOK {
+		t.Fatalf("tagging returned status '%d': %s", w.Code, w.Body.String())
+	}
+	if w = doRequest(router, http.MethodGet, "/images/lifecycle/latest?channel=nightly", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected channel of yanked release not to resolve, got status '%d': %s", w.Code, w.Body.String())
+	}
+	if w = doRequest(router, http.MethodGet, "/v1/images/lifecycle/releases/latest?channel=nightly", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected channel of yanked release to conflict, got status '%d': %s", w.Code, w.Body.String())
+	}
+	if w = doRequest(router, http.MethodGet, "/v1/images/lifecycle/diff?from=sha256:old&to=nightly", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected tag of yanked release to conflict, got status '%d': %s", w.Code, w.Body.String())
+	}
+
+	// ...but can still be diffed by explicit digest.
+	w = doRequest(router, http.MethodGet, "/images/lifecycle/diff", `{"old_digest":"sha256:old","new_digest":"sha256:new"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "2.7.4") {
+		t.Fatalf("diff against yanked release failed with status '%d': %s", w.Code, w.Body.String())
+	}
+
+	// Diffs are rendered as changelogs on request.
+	req, _ := http.NewRequest(http.MethodGet, "/images/lifecycle/diff", strings.NewReader(`{"old_digest":"sha256:old","new_digest":"sha256:new"}`))
+	req.Header.Set("Accept", "text/markdown")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/markdown") || !strings.Contains(w.Body.String(), "- `apt` 2.7.3 → 2.7.4") {
+		t.Fatalf("unexpected Markdown changelog with status '%d': %s", w.Code, w.Body.String())
+	}
+
+	// Editing packages must invalidate the cached diff.
+	w = doRequest(router, http.MethodPatch, "/images/lifecycle/sha256:new", `{"packages":[{"name":"apt","version":"2.7.5"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("release update returned status '%d': %s", w.Code, w.Body.String())
+	}
+	w = doRequest(router, http.MethodGet, "/images/lifecycle/diff", `{"old_digest":"sha256:old","new_digest":"sha256:new"}`)
+	if !strings.Contains(w.Body.String(), "2.7.5") {
+		t.Fatalf("diff served stale cache entry: %s", w.Body.String())
+	}
+
+	if w = doRequest(router, http.MethodDelete, "/images/lifecycle/sha256:new", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("release deletion returned status '%d': %s", w.Code, w.Body.String())
+	}
+	if w = doRequest(router, http.MethodGet, "/images/lifecycle/sha256:new", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("deleted release is still reachable, status '%d'", w.Code)
+	}
+
+	if w = doRequest(router, http.MethodDelete, "/images/lifecycle", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("image deletion returned status '%d': %s", w.Code, w.Body.String())
+	}
+	if w = doRequest(router, http.MethodGet, "/images/lifecycle", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("deleted image is still reachable, status '%d'", w.Code)
+	}
+}
+
+func TestPublishPolicy(t *testing.T) {
+	t.Parallel()
+	server, _ := newTestServer(t)
+	// Publishers can add releases, but not override policies
+	if err := server.DB.Create(&core.Authorization{Name: "publisher", Pass: "publisher"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	router, err := setupRouter(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(user, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/images/policy/new", strings.NewReader(body))
+		req.SetBasicAuth(user, user)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	policy := `{"forbidden_removals":["systemd*"],"max_package_count_change":1,"pinned_versions":{"linux-image-*":"6.1.*"}}`
+	if w := doRequest(router, http.MethodPost, "/images/new", `{"name":"policy","url":"https://example.com/policy","policy":`+policy+`}`); w.Code != http.StatusOK {
+		t.Fatalf("image creation returned status '%d': %s", w.Code, w.Body.String())
+	}
+	first := `{"digest":"sha256:first","date":"2024-01-01T00:00:00Z","packages":[{"name":"systemd","version":"252"},{"name":"linux-image-amd64","version":"6.1.76"}]}`
+	if w := publish("publisher", first); w.Code != http.StatusOK {
+		t.Fatalf("release creation returned status '%d': %s", w.Code, w.Body.String())
+	}
+
+	second := `{"digest":"sha256:second","date":"2024-02-01T00:00:00Z","packages":[{"name":"linux-image-amd64","version":"6.6.15"},{"name":"bash","version":"5.2"},{"name":"apt","version":"2.6.1"},{"name":"tar","version":"1.34"}]}`
+	w := publish("publisher", second)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("release violating the policy returned status '%d': %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Violations []types.PolicyViolation
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	rules := []string{}
+	for _, violation := range response.Violations {
+		rules = append(rules, violation.Rule)
+	}
+	if strings.Join(rules, ",") != "forbidden_removal,max_package_count_change,pinned_version" {
+		t.Fatalf("unexpected violations: %s", w.Body.String())
+	}
+
+	override := strings.Replace(second, "{", `{"override":true,`, 1)
+	if w := publish("publisher", override); w.Code != http.StatusForbidden {
+		t.Fatalf("override by a publisher returned status '%d': %s", w.Code, w.Body.String())
+	}
+	if w := publish("admin", override); w.Code != http.StatusOK {
+		t.Fatalf("override by an admin returned status '%d': %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	t.Parallel()
+	_, router := newTestServer(t)
+
+	doRequest(router, http.MethodPost, "/images/new", `{"name":"feed","url":"https://example.com/feed"}`)
+	doRequest(router, http.MethodPost, "/images/feed/new", `{"digest":"sha256:a","date":"2024-01-01T00:00:00Z","packages":[{"name":"apt","version":"2.7.3"}]}`)
+	doRequest(router, http.MethodPost, "/images/feed/new", `{"digest":"sha256:b","date":"2024-02-01T00:00:00Z","version":"2.0","packages":[{"name":"apt","version":"2.7.4"}]}`)
+
+	var atom struct {
+		Entries []struct {
+			ID      string `xml:"id"`
+			Title   string `xml:"title"`
+			Content string `xml:"content"`
+		} `xml:"entry"`
+	}
+	w := doRequest(router, http.MethodGet, "/images/feed/feed.atom", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/atom+xml") {
+		t.Fatalf("Atom feed returned status '%d': %s", w.Code, w.Body.String())
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil || len(atom.Entries) != 2 {
+		t.Fatalf("unexpected Atom feed (%v): %s", err, w.Body.String())
+	}
+	latest := atom.Entries[0]
+	if latest.ID != "urn:differ:image:feed:release:sha256:b" || latest.Title != "feed 2.0" || !strings.Contains(latest.Content, "<code>apt</code> 2.7.3 → 2.7.4") {
+		t.Fatalf("unexpected Atom entry %+v", latest)
+	}
+	if first := atom.Entries[1]; !strings.Contains(first.Content, "First release") {
+		t.Fatalf("unexpected first Atom entry %+v", first)
+	}
+
+	// Links don't depend on the headers of the request, as long as no public
+	// URL is configured
+	req, _ := http.NewRequest(http.MethodGet, "/images/feed/feed.atom", nil)
+	req.Host = "attacker.example.com"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if strings.Contains(w.Body.String(), "attacker.example.com") || !strings.Contains(w.Body.String(), `href="/v1/images/feed/diff?`) {
+		t.Fatalf("feed links depend on the request: %s", w.Body.String())
+	}
+
+	// New releases must invalidate the cached feeds
+	doRequest(router, http.MethodPost, "/images/feed/new", `{"digest":"sha256:c","date":"2024-03-01T00:00:00Z","packages":[{"name":"apt","version":"2.7.5"}]}`)
+	w = doRequest(router, http.MethodGet, "/images/feed/feed.rss", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/rss+xml") || strings.Count(w.Body.String(), "<item>") != 3 {
+		t.Fatalf("unexpected RSS feed with status '%d': %s", w.Code, w.Body.String())
+	}
+	atom.Entries = nil
+	w = doRequest(router, http.MethodGet, "/images/feed/feed.atom", "")
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil || len(atom.Entries) != 3 {
+		t.Fatalf("feed served stale cache entry: %s", w.Body.String())
+	}
+
+	if w = doRequest(router, http.MethodGet, "/images/missing/feed.atom", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("feed of missing image returned status '%d'", w.Code)
+	}
+}
+
+// sseEvent is an event read from a Server-Sent Events stream.
+type sseEvent struct {
+	id, name, data string
+}
+
+// readEvent reads the next event of stream, skipping comments.
+func readEvent(t *testing.T, stream *bufio.Reader) sseEvent {
+	t.Helper()
+	var event sseEvent
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("event stream ended: %v", err)
+		}
+		field, value, _ := strings.Cut(strings.TrimSuffix(line, "\n"), ": ")
+		switch field {
+		case "":
+			if event.name != "" {
+				return event
+			}
+		case "id":
+			event.id = value
+		case "event":
+			event.name = value
+		case "data":
+			event.data = value
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+	_, router := newTestServer(t)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// openStream subscribes to the events of the "events" image, resuming
+	// after lastID unless empty
+	openStream := func(lastID string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events?image=events", nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("event stream returned status '%d'", resp.StatusCode)
+		}
+		return bufio.NewReader(resp.Body), func() {
+			cancel()
+			resp.Body.Close()
+		}
+	}
+
+	doRequest(router, http.MethodPost, "/images/new", `{"name":"other","url":"https://example.com/other"}`)
+	doRequest(router, http.MethodPost, "/images/new", `{"name":"events","url":"https://example.com/events"}`)
+	stream, closeStream := openStream("")
+
+	doRequest(router, http.MethodPost, "/images/other/new", `{"digest":"sha256:other","date":"2024-01-01T00:00:00Z","packages":[]}`)
+	doRequest(router, http.MethodPost, "/images/events/new", `{"digest":"sha256:a","date":"2024-01-01T00:00:00Z","packages":[{"name":"apt","version":"2.7.3"}]}`)
+	doRequest(router, http.MethodPatch, "/images/events/sha256:a", `{"yanked":true}`)
+	doRequest(router, http.MethodPut, "/v1/images/events/tags/stable", `{"digest":"sha256:a"}`)
+	// Setting a tag to the release it points to doesn't move it
+	doRequest(router, http.MethodPut, "/v1/images/events/tags/stable", `{"digest":"sha256:a"}`)
+	doRequest(router, http.MethodPost, "/images/events/new", `{"digest":"sha256:b","date":"2024-02-01T00:00:00Z","packages":[]}`)
+
+	var received []sseEvent
+	for _, name := range []string{types.EventReleaseCreated, types.EventReleaseYanked, types.EventTagMoved, types.EventReleaseCreated} {
+		event := readEvent(t, stream)
+		if event.name != name {
+			t.Fatalf("expected a %s event, got %+v", name, event)
+		}
+		received = append(received, event)
+	}
+	closeStream()
+
+	var created api.ActivityEvent
+	if err := json.Unmarshal([]byte(received[0].data), &created); err != nil || created.Image != "events" || created.Release == nil || created.Release.Digest != "sha256:a" || created.Release.Packages != nil {
+		t.Fatalf("unexpected release event (%v): %s", err, received[0].data)
+	}
+	var moved api.ActivityEvent
+	if err := json.Unmarshal([]byte(received[2].data), &moved); err != nil || moved.Tag == nil || moved.Tag.Name != "stable" || moved.Tag.Digest != "sha256:a" || moved.Previous != "" {
+		t.Fatalf("unexpected tag event (%v): %s", err, received[2].data)
+	}
+
+	// Reconnecting replays the missed events
+	stream, closeStream = openStream(received[1].id)
+	for _, expected := range received[2:] {
+		if event := readEvent(t, stream); event != expected {
+			t.Fatalf("expected replayed event %+v, got %+v", expected, event)
+		}
+	}
+	closeStream()
+
+	// Events from before a restart are unknown, all logged events are resent
+	stream, closeStream = openStream("1000")
+	if event := readEvent(t, stream); event.name != "reset" {
+		t.Fatalf("expected a reset event, got %+v", event)
+	}
+	if event := readEvent(t, stream); event.name != types.EventImageCreated {
+		t.Fatalf("expected the image creation to be resent, got %+v", event)
+	}
+	closeStream()
+
+	if w := doRequest(router, http.MethodGet, "/events?image=missing", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("events of missing image returned status '%d'", w.Code)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Last-Event-ID", "latest")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid Last-Event-ID returned status '%d'", w.Code)
+	}
+}
+
+func TestGarbageCollection(t *testing.T) {
+	t.Parallel()
+	server, router := newTestServer(t)
+
+	steps := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/images/new", `{"name":"gc","url":"https://example.com/gc"}`},
+		{http.MethodPost, "/images/gc/new", `{"digest":"sha256:gc1","date":"2024-01-01T00:00:00Z","packages":[{"name":"gc-only","version":"1"},{"name":"libc6","version":"2.36-9","arch":"amd64"}]}`},
+		{http.MethodPost, "/images/gc/new", `{"digest":"sha256:gc2","date":"2024-02-01T00:00:00Z","packages":[{"name":"libc6","version":"2.36-9","arch":"amd64"}]}`},
+		{http.MethodPost, "/images/gc/new", `{"digest":"sha256:gc3","date":"2024-03-01T00:00:00Z","packages":[{"name":"libc6","version":"2.36-9","arch":"amd64"}]}`},
+		{http.MethodPatch, "/images/gc", `{"retention":{"keep_last":2}}`},
+	}
+	for _, step := range steps {
+		if w := doRequest(router, step.method, step.path, step.body); w.Code != http.StatusOK {
+			t.Fatalf("%s %s returned status '%d': %s", step.method, step.path, w.Code, w.Body.String())
+		}
+	}
+
+	var count int64
+	server.DB.Model(&types.Package{}).Where("name = ? AND version = ?", "libc6", "2.36-9").Count(&count)
+	if count != 1 {
+		t.Fatalf("expected shared package to be stored once, found %d rows", count)
+	}
+
+	w := doRequest(router, http.MethodPost, "/gc", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("/gc returned status '%d': %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "sha256:gc1") || strings.Contains(w.Body.String(), "sha256:gc2") {
+		t.Fatalf("unexpected releases collected: %s", w.Body.String())
+	}
+
+	server.DB.Model(&types.Package{}).Where("name = ?", "gc-only").Count(&count)
+	if count != 0 {
+		t.Fatal("orphaned package was not collected")
+	}
+}
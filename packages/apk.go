@@ -0,0 +1,78 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+const apkInstalled = "lib/apk/db/installed"
+
+// apk reads the installed database of Alpine-based systems.
+type apk struct{}
+
+func (apk) Name() string {
+	return "apk"
+}
+
+func (apk) Detect(root string) bool {
+	return exists(join(root, apkInstalled))
+}
+
+// Collect parses the installed database, made of blank-line-separated
+// records of single-letter `K:value` lines.
+func (apk) Collect(root string) ([]types.Package, error) {
+	file, err := os.Open(join(root, apkInstalled))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pkgs := []types.Package{}
+	var pkg types.Package
+	flush := func() {
+		if pkg.Name != "" {
+			pkgs = append(pkgs, pkg)
+		}
+		pkg = types.Package{}
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "P":
+			pkg.Name = value
+		case "V":
+			pkg.Version = value
+		case "A":
+			pkg.Arch = value
+		case "L":
+			pkg.License = value
+		case "I":
+			pkg.InstalledSize = parseSize(value)
+		}
+	}
+	flush()
+
+	return pkgs, scanner.Err()
+}
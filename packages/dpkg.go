@@ -0,0 +1,136 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+const (
+	dpkgStatus    = "var/lib/dpkg/status"
+	dpkgStatusDir = "var/lib/dpkg/status.d"
+	dpkgDocDir    = "usr/share/doc"
+)
+
+// dpkg reads the status database of Debian-based systems. Distroless images,
+// which ship one status file per package in status.d, are supported too.
+// Licenses are read from the machine-readable copyright files of packages.
+type dpkg struct{}
+
+func (dpkg) Name() string {
+	return "dpkg"
+}
+
+func (dpkg) Detect(root string) bool {
+	return exists(join(root, dpkgStatus)) || exists(join(root, dpkgStatusDir))
+}
+
+func (dpkg) Collect(root string) ([]types.Package, error) {
+	files := []string{}
+	if exists(join(root, dpkgStatus)) {
+		files = append(files, join(root, dpkgStatus))
+	}
+	if entries, err := os.ReadDir(join(root, dpkgStatusDir)); err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() && !strings.HasSuffix(entry.Name(), ".md5sums") {
+				files = append(files, filepath.Join(root, dpkgStatusDir, entry.Name()))
+			}
+		}
+	}
+
+	pkgs := []types.Package{}
+	for _, file := range files {
+		filePkgs, err := parseDpkgStatus(file)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, filePkgs...)
+	}
+
+	for i := range pkgs {
+		license, err := readDebianCopyright(filepath.Join(root, dpkgDocDir, pkgs[i].Name, "copyright"))
+		if err != nil {
+			return nil, err
+		}
+		pkgs[i].License = license
+	}
+
+	return pkgs, nil
+}
+
+// parseDpkgStatus returns the installed packages of a dpkg status file,
+// which is made of blank-line-separated stanzas of `Field: value` lines.
+// Pre-Depends are merged into Depends, since both must be installed, and
+// Installed-Size is given in KiB.
+func parseDpkgStatus(path string) ([]types.Package, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pkgs := []types.Package{}
+	fields := map[string]string{}
+	flush := func() {
+		// Only the last word of Status tells whether the package is installed,
+		// the others are the desired action and error flags.
+		status := strings.Fields(fields["Status"])
+		installed := len(status) == 0 || status[len(status)-1] == "installed"
+		if fields["Package"] != "" && installed {
+			pkgs = append(pkgs, types.Package{
+				Name:          fields["Package"],
+				Version:       fields["Version"],
+				Arch:          fields["Architecture"],
+				InstalledSize: parseSize(fields["Installed-Size"]) * 1024,
+				Depends:       append(splitDpkgRelations(fields["Pre-Depends"]), splitDpkgRelations(fields["Depends"])...),
+				Recommends:    splitDpkgRelations(fields["Recommends"]),
+				Provides:      splitDpkgRelations(fields["Provides"]),
+			})
+		}
+		fields = map[string]string{}
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		// Continuation of a multi-line field such as Description
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	flush()
+
+	return pkgs, scanner.Err()
+}
+
+// splitDpkgRelations splits a relationship field such as Depends into its
+// comma-separated clauses, returning nil for an empty field.
+func splitDpkgRelations(field string) []string {
+	var clauses []string
+	for _, clause := range strings.Split(field, ",") {
+		if clause = strings.TrimSpace(clause); clause != "" {
+			clauses = append(clauses, clause)
+		}
+	}
+
+	return clauses
+}
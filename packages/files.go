@@ -0,0 +1,216 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+const dpkgInfoDir = "var/lib/dpkg/info"
+
+// ErrNoFileLists is returned by CollectFiles for systems without dpkg file
+// lists, whose files can only be read from an OCI image layout.
+var ErrNoFileLists = errors.New("no dpkg file lists found, file manifests can only be read from an OCI image layout")
+
+// isDpkgFileList reports whether name, relative to the root, is a file
+// listing the files of a dpkg package: a .list or .md5sums file of the info
+// directory, or an .md5sums file of distroless status.d directories.
+func isDpkgFileList(name string) bool {
+	dir, base := path.Split(name)
+	switch strings.TrimSuffix(dir, "/") {
+	case dpkgInfoDir:
+		return strings.HasSuffix(base, ".list") || strings.HasSuffix(base, ".md5sums")
+	case dpkgStatusDir:
+		return strings.HasSuffix(base, ".md5sums")
+	}
+
+	return false
+}
+
+// parseDpkgFileList returns the package listed by a dpkg file list, named
+// after it and its architecture, such as libc6:amd64.list, and the absolute
+// paths it lists. Lists hold a path per line, and md5sums files a digest
+// followed by the path relative to the root.
+func parseDpkgFileList(name, content string) (string, []string) {
+	base := path.Base(name)
+	md5sums := strings.HasSuffix(base, ".md5sums")
+	pkg, _, _ := strings.Cut(strings.TrimSuffix(strings.TrimSuffix(base, ".md5sums"), ".list"), ":")
+
+	paths := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		if md5sums {
+			_, line, _ = strings.Cut(line, " ")
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		paths = append(paths, path.Clean("/"+line))
+	}
+
+	return pkg, paths
+}
+
+// fileOwners maps the paths listed by dpkg file lists, indexed by name, to
+// the package owning them. Lists are walked by name, so that the same owner
+// is picked for paths claimed by several packages, such as diverted files.
+func fileOwners(lists map[string]string) map[string]string {
+	names := make([]string, 0, len(lists))
+	for name := range lists {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	owners := map[string]string{}
+	for _, name := range names {
+		pkg, paths := parseDpkgFileList(name, lists[name])
+		for _, p := range paths {
+			if _, ok := owners[p]; !ok {
+				owners[p] = pkg
+			}
+		}
+	}
+
+	return owners
+}
+
+// groupFiles splits files into the manifests of the packages owning them,
+// sorted by package and path. Files without owner are gathered under an empty
+// package.
+func groupFiles(files map[string]types.FileEntry, owners map[string]string) []types.PackageFiles {
+	byPackage := map[string][]types.FileEntry{}
+	for p, file := range files {
+		pkg := owners[p]
+		byPackage[pkg] = append(byPackage[pkg], file)
+	}
+
+	manifests := make([]types.PackageFiles, 0, len(byPackage))
+	for pkg, entries := range byPackage {
+		slices.SortFunc(entries, func(a, b types.FileEntry) int { return cmp.Compare(a.Path, b.Path) })
+		manifests = append(manifests, types.PackageFiles{Package: pkg, Files: entries})
+	}
+	slices.SortFunc(manifests, func(a, b types.PackageFiles) int { return cmp.Compare(a.Package, b.Package) })
+
+	return manifests
+}
+
+// unixMode converts the permissions and type of mode to a Unix mode.
+func unixMode(mode fs.FileMode) uint32 {
+	unix := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		unix |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		unix |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		unix |= 0o1000
+	}
+	if mode&fs.ModeSymlink != 0 {
+		return unix | types.ModeSymlink
+	}
+
+	return unix | types.ModeRegular
+}
+
+// hashReader returns the hex-encoded SHA-256 digest of the content of r.
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CollectFiles returns the file manifests of the dpkg packages installed
+// under root, from the paths listed by their .list and .md5sums files. Files
+// are read from root to get their mode and digest, and those missing, such as
+// excluded documentation, are left out along with directories.
+func CollectFiles(root string) ([]types.PackageFiles, error) {
+	lists := map[string]string{}
+	for _, dir := range []string{dpkgInfoDir, dpkgStatusDir} {
+		entries, err := os.ReadDir(join(root, dir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := path.Join(dir, entry.Name())
+			if entry.IsDir() || !isDpkgFileList(name) {
+				continue
+			}
+			content, err := os.ReadFile(join(root, name))
+			if err != nil {
+				return nil, err
+			}
+			lists[name] = string(content)
+		}
+	}
+	if len(lists) == 0 {
+		return nil, ErrNoFileLists
+	}
+
+	owners := fileOwners(lists)
+	files := make(map[string]types.FileEntry, len(owners))
+	for p := range owners {
+		file, err := statFile(root, p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			files[p] = *file
+		}
+	}
+
+	return groupFiles(files, owners), nil
+}
+
+// statFile returns the entry of the file at the absolute path p under root,
+// or nil for directories and special files.
+func statFile(root, p string) (*types.FileEntry, error) {
+	full := filepath.Join(root, filepath.FromSlash(p))
+	info, err := os.Lstat(full)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := types.FileEntry{Path: p, Mode: unixMode(info.Mode())}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(full)
+		if err != nil {
+			return nil, err
+		}
+		entry.SHA256, err = hashReader(strings.NewReader(target))
+		return &entry, err
+	case info.Mode().IsRegular():
+		file, err := os.Open(full)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		entry.SHA256, err = hashReader(file)
+		return &entry, err
+	}
+
+	return nil, nil
+}
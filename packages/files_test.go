@@ -0,0 +1,196 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCollectFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, dpkgInfoDir+"/apt.list", "/.\n/etc\n/etc/apt/sources.list\n/usr/bin/apt\n/usr/share/doc/apt/changelog.gz\n")
+	writeFile(t, root, dpkgInfoDir+"/libc6:amd64.md5sums", "d41d8cd98f00b204e9800998ecf8427e  usr/lib/x86_64-linux-gnu/libc.so.6\n")
+	writeFile(t, root, dpkgInfoDir+"/apt.postinst", "#!/bin/sh\n")
+	writeFile(t, root, "etc/apt/sources.list", "deb http://deb.debian.org/debian bookworm main\n")
+	writeFile(t, root, "usr/bin/apt", "apt")
+	writeFile(t, root, "usr/lib/x86_64-linux-gnu/libc.so.6", "libc")
+	if err := os.Chmod(filepath.Join(root, "usr/bin/apt"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The excluded changelog is left out, as are directories
+	expected := []types.PackageFiles{
+		{Package: "apt", Files: []types.FileEntry{
+			{Path: "/etc/apt/sources.list", Mode: types.ModeRegular | 0o644, SHA256: sha256Hex("deb http://deb.debian.org/debian bookworm main\n")},
+			{Path: "/usr/bin/apt", Mode: types.ModeRegular | 0o755, SHA256: sha256Hex("apt")},
+		}},
+		{Package: "libc6", Files: []types.FileEntry{
+			{Path: "/usr/lib/x86_64-linux-gnu/libc.so.6", Mode: types.ModeRegular | 0o644, SHA256: sha256Hex("libc")},
+		}},
+	}
+	manifests, err := CollectFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifests, expected) {
+		t.Errorf("expected %+v, got %+v", expected, manifests)
+	}
+
+	if _, err := CollectFiles(t.TempDir()); err != ErrNoFileLists {
+		t.Errorf("expected ErrNoFileLists, got %v", err)
+	}
+}
+
+// layerEntry is a file of a test layer, regular unless typeflag is set.
+type layerEntry struct {
+	name, content, link string
+	typeflag            byte
+	mode                int64
+}
+
+// writeBlob stores content in the OCI layout at root, returning its digest.
+func writeBlob(t *testing.T, root string, content []byte) string {
+	t.Helper()
+	hash := sha256.Sum256(content)
+	writeFile(t, root, "blobs/sha256/"+hex.EncodeToString(hash[:]), string(content))
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// writeLayer stores a layer made of entries, compressed when gzipped is set,
+// returning its descriptor.
+func writeLayer(t *testing.T, root string, entries []layerEntry, gzipped bool) ociDescriptor {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: entry.mode, Typeflag: entry.typeflag, Linkname: entry.link, Size: int64(len(entry.content))}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !gzipped {
+		return ociDescriptor{MediaType: mediaTypeOCILayer, Digest: writeBlob(t, root, buf.Bytes())}
+	}
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write(buf.Bytes())
+	gw.Close()
+	return ociDescriptor{MediaType: mediaTypeOCILayerGzip, Digest: writeBlob(t, root, compressed.Bytes())}
+}
+
+func TestReadOCILayout(t *testing.T) {
+	root := t.TempDir()
+	base := writeLayer(t, root, []layerEntry{
+		{name: "etc/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "etc/hostname", content: "base", mode: 0o644},
+		{name: "etc/apt/sources.list", content: "bookworm", mode: 0o644},
+		{name: "usr/bin/apt", content: "apt", mode: 0o755},
+		{name: "usr/bin/apt-get", typeflag: tar.TypeLink, link: "usr/bin/apt"},
+		{name: "opt/old/a", content: "a", mode: 0o644},
+		{name: "srv/data/x", content: "x", mode: 0o600},
+		{name: dpkgInfoDir + "/apt.list", content: "/etc/apt/sources.list\n/usr/bin/apt\n/usr/bin/apt-get\n", mode: 0o644},
+	}, true)
+	upper := writeLayer(t, root, []layerEntry{
+		{name: "etc/.wh.hostname"},
+		{name: "opt/.wh.old"},
+		{name: "srv/data/.wh..wh..opq"},
+		{name: "srv/data/y", content: "y", mode: 0o600},
+		{name: "etc/apt/sources.list", content: "trixie", mode: 0o644},
+		{name: "usr/bin/vi", typeflag: tar.TypeSymlink, link: "vim.basic", mode: 0o777},
+		{name: dpkgInfoDir + "/vim.list", content: "/usr/bin/vi\n", mode: 0o644},
+	}, false)
+
+	manifest, _ := json.Marshal(map[string]any{"schemaVersion": 2, "layers": []ociDescriptor{base, upper}})
+	nested, _ := json.Marshal(map[string]any{"schemaVersion": 2, "manifests": []ociDescriptor{{MediaType: mediaTypeOCIManifest, Digest: writeBlob(t, root, manifest)}}})
+	index, _ := json.Marshal(map[string]any{"schemaVersion": 2, "manifests": []ociDescriptor{{MediaType: mediaTypeOCIIndex, Digest: writeBlob(t, root, nested)}}})
+	writeFile(t, root, "index.json", string(index))
+
+	expected := []types.PackageFiles{
+		{Package: "", Files: []types.FileEntry{
+			{Path: "/srv/data/y", Mode: types.ModeRegular | 0o600, SHA256: sha256Hex("y")},
+			{Path: "/" + dpkgInfoDir + "/apt.list", Mode: types.ModeRegular | 0o644, SHA256: sha256Hex("/etc/apt/sources.list\n/usr/bin/apt\n/usr/bin/apt-get\n")},
+			{Path: "/" + dpkgInfoDir + "/vim.list", Mode: types.ModeRegular | 0o644, SHA256: sha256Hex("/usr/bin/vi\n")},
+		}},
+		{Package: "apt", Files: []types.FileEntry{
+			{Path: "/etc/apt/sources.list", Mode: types.ModeRegular | 0o644, SHA256: sha256Hex("trixie")},
+			{Path: "/usr/bin/apt", Mode: types.ModeRegular | 0o755, SHA256: sha256Hex("apt")},
+			{Path: "/usr/bin/apt-get", Mode: types.ModeRegular | 0o755, SHA256: sha256Hex("apt")},
+		}},
+		{Package: "vim", Files: []types.FileEntry{
+			{Path: "/usr/bin/vi", Mode: types.ModeSymlink | 0o777, SHA256: sha256Hex("vim.basic")},
+		}},
+	}
+	manifests, err := ReadOCILayout(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifests, expected) {
+		t.Errorf("expected %+v, got %+v", expected, manifests)
+	}
+
+	// Whiteouts leave the files added by their own layer, and those at the
+	// root hide every file of lower layers
+	root = t.TempDir()
+	base = writeLayer(t, root, []layerEntry{
+		{name: "etc/hostname", content: "base", mode: 0o644},
+		{name: "opt/old/a", content: "a", mode: 0o644},
+	}, false)
+	upper = writeLayer(t, root, []layerEntry{
+		{name: "opt/new/b", content: "b", mode: 0o644},
+		{name: "opt/.wh.new"},
+		{name: "etc/motd", content: "motd", mode: 0o644},
+		{name: "etc/.wh.motd"},
+		{name: ".wh..wh..opq"},
+	}, false)
+	manifest, _ = json.Marshal(map[string]any{"schemaVersion": 2, "layers": []ociDescriptor{base, upper}})
+	index, _ = json.Marshal(map[string]any{"schemaVersion": 2, "manifests": []ociDescriptor{{MediaType: mediaTypeOCIManifest, Digest: writeBlob(t, root, manifest)}}})
+	writeFile(t, root, "index.json", string(index))
+
+	expected = []types.PackageFiles{
+		{Package: "", Files: []types.FileEntry{
+			{Path: "/etc/motd", Mode: types.ModeRegular | 0o644, SHA256: sha256Hex("motd")},
+			{Path: "/opt/new/b", Mode: types.ModeRegular | 0o644, SHA256: sha256Hex("b")},
+		}},
+	}
+	if manifests, err = ReadOCILayout(root); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifests, expected) {
+		t.Errorf("expected %+v, got %+v", expected, manifests)
+	}
+
+	if _, err := ReadOCILayout(t.TempDir()); err == nil {
+		t.Error("reading a directory without OCI layout should fail")
+	}
+}
@@ -0,0 +1,197 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// spdxLicenses maps lowercased names to the SPDX identifiers of common
+// licenses, including the Debian names that differ from them.
+var spdxLicenses = map[string]string{
+	"0bsd":              "0BSD",
+	"afl-3.0":           "AFL-3.0",
+	"apache-1.1":        "Apache-1.1",
+	"apache-2.0":        "Apache-2.0",
+	"artistic-1.0":      "Artistic-1.0",
+	"artistic-1.0-perl": "Artistic-1.0-Perl",
+	"artistic-2.0":      "Artistic-2.0",
+	"bsd-2-clause":      "BSD-2-Clause",
+	"bsd-3-clause":      "BSD-3-Clause",
+	"bsd-4-clause":      "BSD-4-Clause",
+	"bsl-1.0":           "BSL-1.0",
+	"cc-by-3.0":         "CC-BY-3.0",
+	"cc-by-4.0":         "CC-BY-4.0",
+	"cc-by-sa-3.0":      "CC-BY-SA-3.0",
+	"cc-by-sa-4.0":      "CC-BY-SA-4.0",
+	"cc0-1.0":           "CC0-1.0",
+	"cddl-1.0":          "CDDL-1.0",
+	"cddl-1.1":          "CDDL-1.1",
+	"epl-1.0":           "EPL-1.0",
+	"epl-2.0":           "EPL-2.0",
+	"isc":               "ISC",
+	"lppl-1.3c":         "LPPL-1.3c",
+	"mit":               "MIT",
+	"mpl-1.1":           "MPL-1.1",
+	"mpl-2.0":           "MPL-2.0",
+	"ofl-1.1":           "OFL-1.1",
+	"openssl":           "OpenSSL",
+	"php-3.01":          "PHP-3.01",
+	"psf-2.0":           "PSF-2.0",
+	"python-2.0":        "Python-2.0",
+	"ruby":              "Ruby",
+	"unicode-dfs-2016":  "Unicode-DFS-2016",
+	"unlicense":         "Unlicense",
+	"wtfpl":             "WTFPL",
+	"x11":               "X11",
+	"zlib":              "Zlib",
+	"zpl-2.1":           "ZPL-2.1",
+	// Debian names
+	"expat":     "MIT",
+	"cc0":       "CC0-1.0",
+	"boost-1.0": "BSL-1.0",
+	"psf-2":     "PSF-2.0",
+	"python":    "Python-2.0",
+}
+
+// gnuLicense matches the Debian names of versioned GNU licenses, such as
+// GPL-2 or LGPL-2.1+.
+var gnuLicense = regexp.MustCompile(`(?i)^(A?GPL|LGPL|GFDL)-(\d+)(\.\d+)?(\+)?$`)
+
+// licenseRefInvalid matches the characters not allowed in SPDX license
+// references.
+var licenseRefInvalid = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// spdxLicense returns the SPDX identifier of a single license name, as used
+// in Debian copyright files. Unknown licenses are returned as references,
+// such as LicenseRef-public-domain.
+func spdxLicense(name string) string {
+	if match := gnuLicense.FindStringSubmatch(name); match != nil {
+		minor := match[3]
+		if minor == "" {
+			minor = ".0"
+		}
+		suffix := "-only"
+		if match[4] != "" {
+			suffix = "-or-later"
+		}
+		return strings.ToUpper(match[1]) + "-" + match[2] + minor + suffix
+	}
+
+	orLater := strings.HasSuffix(name, "+")
+	if id, ok := spdxLicenses[strings.ToLower(strings.TrimSuffix(name, "+"))]; ok {
+		if orLater {
+			return id + "+"
+		}
+		return id
+	}
+
+	return "LicenseRef-" + strings.Trim(licenseRefInvalid.ReplaceAllString(name, "-"), "-")
+}
+
+// spdxExpression converts the License field of a Debian copyright file, such
+// as "GPL-2+ or Artistic", to an SPDX license expression. Exceptions, which
+// SPDX only allows from its own list, are left out.
+func spdxExpression(field string) string {
+	var terms []string
+	exception := false
+	for _, word := range strings.Fields(field) {
+		word = strings.TrimRight(word, ",;")
+		switch strings.ToLower(word) {
+		case "":
+		case "or", "and":
+			exception = false
+			if len(terms) > 0 && terms[len(terms)-1] != "OR" && terms[len(terms)-1] != "AND" {
+				terms = append(terms, strings.ToUpper(word))
+			}
+		case "with":
+			exception = true
+		default:
+			if !exception {
+				terms = append(terms, spdxLicense(word))
+			}
+		}
+	}
+	if len(terms) > 0 && (terms[len(terms)-1] == "OR" || terms[len(terms)-1] == "AND") {
+		terms = terms[:len(terms)-1]
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// joinLicenses returns the conjunction of the distinct non-empty license
+// expressions, parenthesizing the compound ones.
+func joinLicenses(expressions []string) string {
+	var terms []string
+	for _, expression := range expressions {
+		expression = strings.TrimSpace(expression)
+		if expression == "" || slices.Contains(terms, expression) || slices.Contains(terms, "("+expression+")") {
+			continue
+		}
+		if len(expressions) > 1 && strings.Contains(expression, " OR ") {
+			expression = "(" + expression + ")"
+		}
+		terms = append(terms, expression)
+	}
+
+	return strings.Join(terms, " AND ")
+}
+
+// readDebianCopyright returns the license expression of a machine-readable
+// Debian copyright file, as the conjunction of the licenses of its Files
+// paragraphs. Copyright files in free form have no known license.
+func readDebianCopyright(path string) (string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var licenses []string
+	fields := map[string]string{}
+	flush := func() {
+		if _, ok := fields["Files"]; ok {
+			licenses = append(licenses, spdxExpression(fields["License"]))
+		}
+		fields = map[string]string{}
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			first = false
+			if !strings.HasPrefix(line, "Format:") {
+				return "", nil
+			}
+		}
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		// Continuation lines hold the text of licenses and copyrights
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	flush()
+
+	return joinLicenses(licenses), scanner.Err()
+}
@@ -0,0 +1,65 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSpdxExpression(t *testing.T) {
+	tests := map[string]string{
+		"GPL-2":                         "GPL-2.0-only",
+		"LGPL-2.1+":                     "LGPL-2.1-or-later",
+		"GPL-2+ or Artistic-2.0":        "GPL-2.0-or-later OR Artistic-2.0",
+		"BSD-3-clause, and Expat":       "BSD-3-Clause AND MIT",
+		"GPL-3+ with OpenSSL exception": "GPL-3.0-or-later",
+		"MPL-1.1+":                      "MPL-1.1+",
+		"public-domain":                 "LicenseRef-public-domain",
+		"permissive/custom":             "LicenseRef-permissive-custom",
+	}
+	for field, expected := range tests {
+		if expression := spdxExpression(field); expression != expected {
+			t.Errorf("%q: expected %q, got %q", field, expected, expression)
+		}
+	}
+
+	if license := joinLicenses([]string{"MIT", "", "GPL-2.0-only OR MIT", "MIT"}); license != "MIT AND (GPL-2.0-only OR MIT)" {
+		t.Errorf("unexpected joined license %q", license)
+	}
+}
+
+func TestReadDebianCopyright(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "copyright", `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+
+Files: *
+Copyright: 2024 Someone
+License: GPL-2+ or Artistic
+ Some text.
+Comment:
+ License: Ignored
+
+Files: debian/*
+License: public-domain
+
+Files: lib/*
+License: GPL-2+ or Artistic
+
+License: Artistic
+ The Artistic License.
+`)
+
+	license, err := readDebianCopyright(filepath.Join(root, "copyright"))
+	if err != nil || license != "(GPL-2.0-or-later OR LicenseRef-Artistic) AND LicenseRef-public-domain" {
+		t.Fatalf("unexpected license %q (%v)", license, err)
+	}
+	if license, err := readDebianCopyright(filepath.Join(root, "missing")); err != nil || license != "" {
+		t.Fatalf("unexpected license of a missing file %q (%v)", license, err)
+	}
+}
@@ -0,0 +1,239 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+// Media types of the OCI and Docker manifests, indexes and layers.
+const (
+	mediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCILayer       = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeOCILayerGzip   = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerLayer    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// Prefixes of whiteout files, which delete files of the lower layers.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// ociDescriptor points to a blob of an OCI image layout.
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// ociIndex holds the fields of OCI indexes and manifests used by Differ.
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// ociLayout is an OCI image layout directory, such as those written by
+// skopeo copy or buildah push to an oci: destination.
+type ociLayout string
+
+// open opens the blob with the given digest.
+func (l ociLayout) open(digest string) (*os.File, error) {
+	algorithm, hash, ok := strings.Cut(digest, ":")
+	if !ok || strings.ContainsAny(hash, `/\`) || strings.ContainsAny(algorithm, `/\`) {
+		return nil, fmt.Errorf("invalid digest %s", digest)
+	}
+
+	return os.Open(filepath.Join(string(l), "blobs", algorithm, hash))
+}
+
+// readIndex decodes the index or manifest with the given digest, or the
+// index.json of the layout for an empty digest.
+func (l ociLayout) readIndex(digest string) (*ociIndex, error) {
+	var content []byte
+	var err error
+	if digest == "" {
+		content, err = os.ReadFile(filepath.Join(string(l), "index.json"))
+	} else {
+		var file *os.File
+		if file, err = l.open(digest); err == nil {
+			defer file.Close()
+			content, err = io.ReadAll(file)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var index ociIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("invalid OCI index %s: %v", digest, err)
+	}
+
+	return &index, nil
+}
+
+// layers returns the layers of the single image of the layout, following
+// nested indexes.
+func (l ociLayout) layers() ([]ociDescriptor, error) {
+	index, err := l.readIndex("")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if len(index.Manifests) != 1 {
+			return nil, fmt.Errorf("expected a single image in OCI layout %s, found %d", l, len(index.Manifests))
+		}
+		descriptor := index.Manifests[0]
+		if index, err = l.readIndex(descriptor.Digest); err != nil {
+			return nil, err
+		}
+		switch descriptor.MediaType {
+		case mediaTypeOCIManifest, mediaTypeDockerManifest:
+			return index.Layers, nil
+		case mediaTypeOCIIndex:
+		default:
+			return nil, fmt.Errorf("unsupported manifest media type %s", descriptor.MediaType)
+		}
+	}
+}
+
+// ReadOCILayout returns the file manifests of the image stored in an OCI
+// image layout directory, walking its layers in order and applying their
+// whiteouts. Files are assigned to packages from the dpkg file lists found in
+// the image, the others being gathered under an empty package. Directories
+// and special files are left out.
+func ReadOCILayout(dir string) ([]types.PackageFiles, error) {
+	layout := ociLayout(dir)
+	layers, err := layout.layers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Files and dpkg file lists, along with the layer that added them
+	files := map[string]types.FileEntry{}
+	lists := map[string]string{}
+	layerOf := map[string]int{}
+	// hide deletes the files beneath dir added by layers below layer
+	hide := func(dir string, layer int) {
+		prefix := strings.TrimSuffix(dir, "/") + "/"
+		for name := range files {
+			if layerOf[name] < layer && strings.HasPrefix(name, prefix) {
+				delete(files, name)
+				delete(lists, strings.TrimPrefix(name, "/"))
+			}
+		}
+	}
+
+	for i, descriptor := range layers {
+		err := layout.walkLayer(descriptor, func(header *tar.Header, content io.Reader) error {
+			p := path.Clean("/" + header.Name)
+			dir, base := path.Split(p)
+			if base == whiteoutOpaque {
+				hide(path.Clean(dir), i)
+				return nil
+			}
+			if strings.HasPrefix(base, whiteoutPrefix) {
+				// Whiteouts only apply to lower layers. Those of directories,
+				// which aren't recorded, hide their whole content
+				deleted := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+				if _, ok := files[deleted]; !ok {
+					hide(deleted, i)
+				} else if layerOf[deleted] < i {
+					delete(files, deleted)
+					delete(lists, strings.TrimPrefix(deleted, "/"))
+				}
+				return nil
+			}
+
+			entry := types.FileEntry{Path: p, Mode: uint32(header.Mode) & 0o7777}
+			switch header.Typeflag {
+			case tar.TypeReg:
+				entry.Mode |= types.ModeRegular
+				if name := strings.TrimPrefix(p, "/"); isDpkgFileList(name) {
+					list, err := io.ReadAll(content)
+					if err != nil {
+						return err
+					}
+					lists[name] = string(list)
+					content = strings.NewReader(lists[name])
+				}
+				var err error
+				if entry.SHA256, err = hashReader(content); err != nil {
+					return err
+				}
+			case tar.TypeSymlink:
+				entry.Mode |= types.ModeSymlink
+				entry.SHA256, _ = hashReader(strings.NewReader(header.Linkname))
+			case tar.TypeLink:
+				target, ok := files[path.Clean("/"+header.Linkname)]
+				if !ok {
+					return nil
+				}
+				entry.Mode, entry.SHA256 = target.Mode, target.SHA256
+			default:
+				return nil
+			}
+			files[p] = entry
+			layerOf[p] = i
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %v", descriptor.Digest, err)
+		}
+	}
+
+	return groupFiles(files, fileOwners(lists)), nil
+}
+
+// walkLayer calls fn for every entry of the layer, along with its content.
+func (l ociLayout) walkLayer(descriptor ociDescriptor, fn func(header *tar.Header, content io.Reader) error) error {
+	file, err := l.open(descriptor.Digest)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	switch descriptor.MediaType {
+	case mediaTypeOCILayer:
+	case mediaTypeOCILayerGzip, mediaTypeDockerLayer:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	default:
+		return fmt.Errorf("unsupported layer media type %s", descriptor.MediaType)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package packages collects the packages installed on a system from the
+// database of its package manager.
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+// Manager reads installed packages from the database of a package manager.
+type Manager interface {
+	// Name returns the name of the package manager, such as "dpkg".
+	Name() string
+	// Detect reports whether the package manager's database exists under root.
+	Detect(root string) bool
+	// Collect returns the packages installed under root.
+	Collect(root string) ([]types.Package, error)
+}
+
+// Managers lists the supported package managers, in detection order.
+var Managers = []Manager{dpkg{}, apk{}, pacman{}, rpm{}}
+
+// Get returns the package manager with the given name.
+func Get(name string) (Manager, error) {
+	for _, manager := range Managers {
+		if manager.Name() == name {
+			return manager, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported package manager %s", name)
+}
+
+// Detect returns the first package manager whose database exists under root.
+func Detect(root string) (Manager, error) {
+	for _, manager := range Managers {
+		if manager.Detect(root) {
+			return manager, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no supported package manager found in %s", root)
+}
+
+// Collect detects the package manager under root and returns the installed
+// packages sorted by name and architecture.
+func Collect(root string) (Manager, []types.Package, error) {
+	manager, err := Detect(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkgs, err := manager.Collect(root)
+	if err != nil {
+		return manager, nil, err
+	}
+	Sort(pkgs)
+
+	return manager, pkgs, nil
+}
+
+// Sort orders pkgs by name and then architecture.
+func Sort(pkgs []types.Package) {
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		return pkgs[i].Arch < pkgs[j].Arch
+	})
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func join(root, path string) string {
+	return filepath.Join(root, path)
+}
+
+// parseSize parses a size field of a package database, which is unknown, and
+// zero, when missing or malformed.
+func parseSize(field string) int64 {
+	size, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+	if err != nil || size < 0 {
+		return 0
+	}
+
+	return size
+}
+
+// Digest returns a content digest of pkgs, for releases whose image digest is
+// not known. Identical package sets always produce the same digest.
+func Digest(pkgs []types.Package) string {
+	sorted := append([]types.Package(nil), pkgs...)
+	Sort(sorted)
+
+	h := sha256.New()
+	for _, pkg := range sorted {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\n", pkg.Name, pkg.Version, pkg.Arch)
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,182 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+func writeFile(t *testing.T, root, path, content string) {
+	t.Helper()
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// aptCopyright is a machine-readable copyright file, whose license applies to
+// apt in every case of TestCollect.
+const aptCopyright = `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+Upstream-Name: apt
+
+Files: *
+Copyright: 1997-1999 Jason Gunthorpe and others
+License: GPL-2+
+
+License: GPL-2+
+ This program is free software.
+`
+
+func TestCollect(t *testing.T) {
+	cases := []struct {
+		manager string
+		files   map[string]string
+	}{
+		{
+			manager: "dpkg",
+			files: map[string]string{
+				dpkgStatus: `Package: zlib1g
+Status: install ok installed
+Architecture: amd64
+Version: 1:1.2.13.dfsg-1
+Description: compression library - runtime
+ zlib is a library implementing the deflate compression method.
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+
+Package: apt
+Status: install ok installed
+Architecture: amd64
+Version: 2.7.3"quoted
+Installed-Size: 2048
+`,
+				dpkgDocDir + "/apt/copyright":    aptCopyright,
+				dpkgDocDir + "/zlib1g/copyright": "Copyright in free form.\n\nLicense: Zlib\n",
+			},
+		},
+		{
+			manager: "dpkg",
+			files: map[string]string{
+				dpkgStatusDir + "/zlib1g":         "Package: zlib1g\nVersion: 1:1.2.13.dfsg-1\nArchitecture: amd64\n",
+				dpkgStatusDir + "/apt":            "Package: apt\nVersion: 2.7.3\"quoted\nArchitecture: amd64\nInstalled-Size: 2048\n",
+				dpkgStatusDir + "/zlib1g.md5sums": "d41d8cd98f00b204e9800998ecf8427e  usr/lib/libz.so.1\n",
+				dpkgDocDir + "/apt/copyright":     aptCopyright,
+			},
+		},
+		{
+			manager: "apk",
+			files: map[string]string{
+				apkInstalled: "C:Q1abc=\nP:zlib1g\nV:1:1.2.13.dfsg-1\nA:amd64\nT:compression library\n\nP:apt\nV:2.7.3\"quoted\nA:amd64\nI:2097152\nL:GPL-2.0-or-later\n",
+			},
+		},
+		{
+			manager: "pacman",
+			files: map[string]string{
+				pacmanLocal + "/ALPM_DB_VERSION":              "9\n",
+				pacmanLocal + "/zlib1g-1.2.13-1/desc":         "%NAME%\nzlib1g\n\n%VERSION%\n1:1.2.13.dfsg-1\n\n%ARCH%\namd64\n",
+				pacmanLocal + "/apt-2.7.3-1/desc":             "%NAME%\napt\n\n%VERSION%\n2.7.3\"quoted\n\n%ARCH%\namd64\n\n%LICENSE%\nGPL-2.0-or-later\n\n%SIZE%\n2097152\n\n%DEPENDS%\nglibc\nzlib1g\n",
+				pacmanLocal + "/apt-2.7.3-1/files":            "%FILES%\nusr/bin/apt\n",
+				pacmanLocal + "/half-installed-1.0-1/install": "",
+			},
+		},
+	}
+	expected := []types.Package{
+		{Name: "apt", Version: "2.7.3\"quoted", Arch: "amd64", License: "GPL-2.0-or-later", InstalledSize: 2097152},
+		{Name: "zlib1g", Version: "1:1.2.13.dfsg-1", Arch: "amd64"},
+	}
+
+	for _, c := range cases {
+		root := t.TempDir()
+		for path, content := range c.files {
+			writeFile(t, root, path, content)
+		}
+
+		manager, pkgs, err := Collect(root)
+		if err != nil {
+			t.Fatalf("%s: %v", c.manager, err)
+		}
+		if manager.Name() != c.manager {
+			t.Errorf("expected %s to be detected, got %s", c.manager, manager.Name())
+		}
+		if !reflect.DeepEqual(pkgs, expected) {
+			t.Errorf("%s: expected %v, got %v", c.manager, expected, pkgs)
+		}
+	}
+
+	if _, _, err := Collect(t.TempDir()); err == nil {
+		t.Error("collecting from an empty root should fail")
+	}
+}
+
+func TestParseRpmOutput(t *testing.T) {
+	out := "bash\t5.2.26-3.fc40\tx86_64\tGPL-3.0-or-later\t8120304\ngpg-pubkey\t18b8e74c-62f2920f\t(none)\tpubkey\t0\ntzdata\t2:2024a-5.fc40\tnoarch\t(none)\t(none)\n"
+	expected := []types.Package{
+		{Name: "bash", Version: "5.2.26-3.fc40", Arch: "x86_64", License: "GPL-3.0-or-later", InstalledSize: 8120304},
+		{Name: "tzdata", Version: "2:2024a-5.fc40", Arch: "noarch"},
+	}
+
+	if pkgs := parseRpmOutput(out); !reflect.DeepEqual(pkgs, expected) {
+		t.Errorf("expected %v, got %v", expected, pkgs)
+	}
+}
+
+func TestParseDpkgRelations(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, dpkgStatus, `Package: mutt
+Status: install ok installed
+Architecture: amd64
+Version: 2.2.9-1
+Pre-Depends: init-system-helpers (>= 1.54~)
+Depends: libc6 (>= 2.34),, libgpgme11 (>= 1.2.0)
+Recommends: default-mta | mail-transport-agent, sensible-utils
+Provides: mail-reader, imap-client
+`)
+
+	expected := []types.Package{{
+		Name:       "mutt",
+		Version:    "2.2.9-1",
+		Arch:       "amd64",
+		Depends:    []string{"init-system-helpers (>= 1.54~)", "libc6 (>= 2.34)", "libgpgme11 (>= 1.2.0)"},
+		Recommends: []string{"default-mta | mail-transport-agent", "sensible-utils"},
+		Provides:   []string{"mail-reader", "imap-client"},
+	}}
+	pkgs, err := parseDpkgStatus(filepath.Join(root, dpkgStatus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pkgs, expected) {
+		t.Errorf("expected %v, got %v", expected, pkgs)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	a := []types.Package{{Name: "apt", Version: "2.7.3"}, {Name: "bash", Version: "5.2"}}
+	b := []types.Package{{Name: "bash", Version: "5.2"}, {Name: "apt", Version: "2.7.3"}}
+
+	if Digest(a) != Digest(b) {
+		t.Error("digest should not depend on package order")
+	}
+	if a[0].Name != "apt" || b[0].Name != "bash" {
+		t.Error("digest should not reorder its input")
+	}
+	b[0].Version = "5.3"
+	if Digest(a) == Digest(b) {
+		t.Error("digest should change with package versions")
+	}
+}
@@ -0,0 +1,86 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+const pacmanLocal = "var/lib/pacman/local"
+
+// pacman reads the local database of Arch-based systems, which holds a
+// directory per installed package.
+type pacman struct{}
+
+func (pacman) Name() string {
+	return "pacman"
+}
+
+func (pacman) Detect(root string) bool {
+	return exists(join(root, pacmanLocal))
+}
+
+func (pacman) Collect(root string) ([]types.Package, error) {
+	entries, err := os.ReadDir(join(root, pacmanLocal))
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := []types.Package{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(root, pacmanLocal, entry.Name(), "desc"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		desc := parsePacmanDesc(string(content))
+		pkgs = append(pkgs, types.Package{
+			Name:          desc["NAME"],
+			Version:       desc["VERSION"],
+			Arch:          desc["ARCH"],
+			License:       joinLicenses(strings.Split(desc["LICENSE"], "\n")),
+			InstalledSize: parseSize(desc["SIZE"]),
+		})
+	}
+
+	return pkgs, nil
+}
+
+// parsePacmanDesc parses a desc file, made of `%KEY%` lines followed by the
+// value lines of the key, returning the values of each key separated by
+// newlines.
+func parsePacmanDesc(content string) map[string]string {
+	desc := map[string]string{}
+	key := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%") && len(line) > 1:
+			key = strings.Trim(line, "%")
+		case line == "":
+			key = ""
+		case key != "":
+			if _, ok := desc[key]; ok {
+				desc[key] += "\n"
+			}
+			desc[key] += line
+		}
+	}
+
+	return desc
+}
@@ -0,0 +1,66 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+// rpmQueryFormat prints one package per line, prefixing the version with the
+// epoch only when there is one.
+const rpmQueryFormat = `%{NAME}\t%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\t%{ARCH}\t%{LICENSE}\t%{SIZE}\n`
+
+// rpm queries the database of RPM-based systems. Its database format varies
+// between distributions, so the rpm binary is used to read it.
+type rpm struct{}
+
+func (rpm) Name() string {
+	return "rpm"
+}
+
+func (rpm) Detect(root string) bool {
+	if _, err := exec.LookPath("rpm"); err != nil {
+		return false
+	}
+
+	return exists(join(root, "var/lib/rpm")) || exists(join(root, "usr/lib/sysimage/rpm"))
+}
+
+func (rpm) Collect(root string) ([]types.Package, error) {
+	out, err := exec.Command("rpm", "--root", root, "-qa", "--queryformat", rpmQueryFormat).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rpm database: %v", err)
+	}
+
+	return parseRpmOutput(string(out)), nil
+}
+
+func parseRpmOutput(out string) []types.Package {
+	pkgs := []types.Package{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+
+		arch, license := fields[2], fields[3]
+		if arch == "(none)" {
+			arch = ""
+		}
+		if license == "(none)" {
+			license = ""
+		}
+		pkgs = append(pkgs, types.Package{Name: fields[0], Version: fields[1], Arch: arch, License: license, InstalledSize: parseSize(fields[4])})
+	}
+
+	return pkgs
+}
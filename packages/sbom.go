@@ -0,0 +1,167 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+// ErrNotSBOM is returned by ReadSBOM for documents that are neither SPDX nor
+// CycloneDX SBOMs.
+var ErrNotSBOM = errors.New("not an SPDX or CycloneDX SBOM")
+
+// sbom holds the fields of SPDX and CycloneDX JSON documents used by Differ.
+type sbom struct {
+	SPDXVersion string `json:"spdxVersion"`
+	Packages    []struct {
+		SPDXID           string `json:"SPDXID"`
+		Name             string `json:"name"`
+		VersionInfo      string `json:"versionInfo"`
+		LicenseConcluded string `json:"licenseConcluded"`
+		LicenseDeclared  string `json:"licenseDeclared"`
+		ExternalRefs     []struct {
+			ReferenceType    string `json:"referenceType"`
+			ReferenceLocator string `json:"referenceLocator"`
+		} `json:"externalRefs"`
+	} `json:"packages"`
+	Relationships []struct {
+		SPDXElementID      string `json:"spdxElementId"`
+		RelationshipType   string `json:"relationshipType"`
+		RelatedSPDXElement string `json:"relatedSpdxElement"`
+	} `json:"relationships"`
+
+	BOMFormat  string `json:"bomFormat"`
+	Components []struct {
+		BOMRef   string `json:"bom-ref"`
+		Name     string `json:"name"`
+		Version  string `json:"version"`
+		PURL     string `json:"purl"`
+		Licenses []struct {
+			Expression string `json:"expression"`
+			License    struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"license"`
+		} `json:"licenses"`
+	} `json:"components"`
+	Dependencies []struct {
+		Ref       string   `json:"ref"`
+		DependsOn []string `json:"dependsOn"`
+	} `json:"dependencies"`
+}
+
+// ReadSBOM returns the packages listed by an SPDX or CycloneDX SBOM in JSON
+// format, along with their license and the names of the packages they depend
+// on. Only packages identified by a package URL are kept, which leaves out
+// the image itself and the files it holds, and their architecture is taken
+// from its arch qualifier.
+func ReadSBOM(content []byte) ([]types.Package, error) {
+	var document sbom
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, ErrNotSBOM
+	}
+
+	pkgs := []types.Package{}
+	// Index in pkgs of the packages kept, by SPDX ID or bom-ref
+	refs := map[string]int{}
+	depend := func(ref, dependency string) {
+		i, ok := refs[ref]
+		j, found := refs[dependency]
+		if ref != "" && dependency != "" && ok && found && i != j && !slices.Contains(pkgs[i].Depends, pkgs[j].Name) {
+			pkgs[i].Depends = append(pkgs[i].Depends, pkgs[j].Name)
+		}
+	}
+
+	switch {
+	case document.SPDXVersion != "":
+		for _, pkg := range document.Packages {
+			purl := ""
+			for _, ref := range pkg.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					purl = ref.ReferenceLocator
+				}
+			}
+			if purl == "" {
+				continue
+			}
+
+			license := spdxValue(pkg.LicenseConcluded)
+			if license == "" {
+				license = spdxValue(pkg.LicenseDeclared)
+			}
+			refs[pkg.SPDXID] = len(pkgs)
+			pkgs = append(pkgs, types.Package{Name: pkg.Name, Version: pkg.VersionInfo, Arch: purlArch(purl), License: license})
+		}
+		for _, relationship := range document.Relationships {
+			switch relationship.RelationshipType {
+			case "DEPENDS_ON":
+				depend(relationship.SPDXElementID, relationship.RelatedSPDXElement)
+			case "DEPENDENCY_OF":
+				depend(relationship.RelatedSPDXElement, relationship.SPDXElementID)
+			}
+		}
+	case document.BOMFormat == "CycloneDX":
+		for _, component := range document.Components {
+			if component.PURL == "" {
+				continue
+			}
+
+			licenses := []string{}
+			for _, license := range component.Licenses {
+				switch {
+				case license.Expression != "":
+					licenses = append(licenses, license.Expression)
+				case license.License.ID != "":
+					licenses = append(licenses, license.License.ID)
+				case license.License.Name != "":
+					licenses = append(licenses, spdxLicense(license.License.Name))
+				}
+			}
+			refs[component.BOMRef] = len(pkgs)
+			pkgs = append(pkgs, types.Package{Name: component.Name, Version: component.Version, Arch: purlArch(component.PURL), License: joinLicenses(licenses)})
+		}
+		for _, dependency := range document.Dependencies {
+			for _, ref := range dependency.DependsOn {
+				depend(dependency.Ref, ref)
+			}
+		}
+	default:
+		return nil, ErrNotSBOM
+	}
+
+	return pkgs, nil
+}
+
+// spdxValue returns the license expression of an SPDX license field, which
+// is empty when set to NOASSERTION or NONE.
+func spdxValue(license string) string {
+	if license == types.NoAssertion || license == "NONE" {
+		return ""
+	}
+
+	return license
+}
+
+// purlArch returns the arch qualifier of a package URL, such as
+// pkg:deb/debian/apt@2.6.1?arch=amd64.
+func purlArch(purl string) string {
+	_, qualifiers, _ := strings.Cut(purl, "?")
+	qualifiers, _, _ = strings.Cut(qualifiers, "#")
+	values, err := url.ParseQuery(qualifiers)
+	if err != nil {
+		return ""
+	}
+
+	return values.Get("arch")
+}
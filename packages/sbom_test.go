@@ -0,0 +1,70 @@
+package packages
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+func TestReadSBOM(t *testing.T) {
+	expected := []types.Package{
+		{Name: "apt", Version: "2.6.1", Arch: "amd64", License: "GPL-2.0-or-later", Depends: []string{"zlib1g"}},
+		{Name: "zlib1g", Version: "1:1.2.13.dfsg-1", Arch: "amd64"},
+		{Name: "redis", Version: "7.4", License: "LicenseRef-RSALv2 OR SSPL-1.0"},
+	}
+
+	spdx := `{
+		"spdxVersion": "SPDX-2.3",
+		"packages": [
+			{"name": "pico", "versionInfo": "sha256:a99e", "licenseConcluded": "NOASSERTION"},
+			{"SPDXID": "SPDXRef-apt", "name": "apt", "versionInfo": "2.6.1", "licenseConcluded": "NOASSERTION", "licenseDeclared": "GPL-2.0-or-later",
+				"externalRefs": [{"referenceType": "cpe23Type", "referenceLocator": "cpe:2.3:a:apt:apt:2.6.1"}, {"referenceType": "purl", "referenceLocator": "pkg:deb/debian/apt@2.6.1?arch=amd64&distro=debian-12"}]},
+			{"SPDXID": "SPDXRef-zlib1g", "name": "zlib1g", "versionInfo": "1:1.2.13.dfsg-1", "licenseDeclared": "NONE",
+				"externalRefs": [{"referenceType": "purl", "referenceLocator": "pkg:deb/debian/zlib1g@1:1.2.13.dfsg-1?arch=amd64"}]},
+			{"name": "redis", "versionInfo": "7.4", "licenseConcluded": "LicenseRef-RSALv2 OR SSPL-1.0",
+				"externalRefs": [{"referenceType": "purl", "referenceLocator": "pkg:generic/redis@7.4"}]}
+		],
+		"relationships": [
+			{"spdxElementId": "SPDXRef-DOCUMENT", "relationshipType": "DESCRIBES", "relatedSpdxElement": "SPDXRef-apt"},
+			{"spdxElementId": "SPDXRef-zlib1g", "relationshipType": "DEPENDENCY_OF", "relatedSpdxElement": "SPDXRef-apt"},
+			{"spdxElementId": "SPDXRef-apt", "relationshipType": "DEPENDS_ON", "relatedSpdxElement": "SPDXRef-zlib1g"}
+		]
+	}`
+	if pkgs, err := ReadSBOM([]byte(spdx)); err != nil || !reflect.DeepEqual(pkgs, expected) {
+		t.Errorf("SPDX: expected %v, got %v (%v)", expected, pkgs, err)
+	}
+
+	cyclonedx := `{
+		"bomFormat": "CycloneDX",
+		"specVersion": "1.5",
+		"components": [
+			{"type": "operating-system", "name": "debian", "version": "12"},
+			{"bom-ref": "apt", "name": "apt", "version": "2.6.1", "purl": "pkg:deb/debian/apt@2.6.1?arch=amd64", "licenses": [{"license": {"id": "GPL-2.0-or-later"}}]},
+			{"bom-ref": "zlib1g", "name": "zlib1g", "version": "1:1.2.13.dfsg-1", "purl": "pkg:deb/debian/zlib1g@1:1.2.13.dfsg-1?arch=amd64"},
+			{"name": "redis", "version": "7.4", "purl": "pkg:generic/redis@7.4", "licenses": [{"license": {"name": "RSALv2"}}, {"license": {"id": "SSPL-1.0"}}]}
+		],
+		"dependencies": [
+			{"ref": "apt", "dependsOn": ["zlib1g", "missing"]},
+			{"ref": "zlib1g"}
+		]
+	}`
+	expected[2].License = "LicenseRef-RSALv2 AND SSPL-1.0"
+	if pkgs, err := ReadSBOM([]byte(cyclonedx)); err != nil || !reflect.DeepEqual(pkgs, expected) {
+		t.Errorf("CycloneDX: expected %v, got %v (%v)", expected, pkgs, err)
+	}
+
+	for _, document := range []string{`{"packages": []}`, `[]`, `not json`} {
+		if _, err := ReadSBOM([]byte(document)); !errors.Is(err, ErrNotSBOM) {
+			t.Errorf("%s: expected ErrNotSBOM, got %v", document, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// Relationships between packages, as reported by reverse dependencies.
+const (
+	RelationDepends    = "depends"
+	RelationRecommends = "recommends"
+)
+
+// Dependent is a package depending on another one in a release.
+type Dependent struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Relationship string `json:"relationship"` // RelationDepends or RelationRecommends
+	Clause       string `json:"clause"`       // clause naming the package, such as libc6 (>= 2.34)
+}
+
+// DependencyChain explains why a package was added to a release. Chain goes
+// from the package that pulled it in down to the added package, each
+// depending on the next one, and only holds the added package when nothing
+// depends on it, as for packages installed explicitly.
+type DependencyChain struct {
+	Name  string   `json:"name"`
+	Chain []string `json:"chain"`
+}
+
+// clauseNames returns the names of the packages that can satisfy a
+// dependency clause, such as "default-mta | mail-transport-agent", leaving
+// out version constraints and architecture qualifiers.
+func clauseNames(clause string) []string {
+	names := []string{}
+	for _, alternative := range strings.Split(clause, "|") {
+		name := strings.TrimSpace(alternative)
+		if i := strings.IndexAny(name, " (<>=[:"); i >= 0 {
+			name = name[:i]
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// dependencyGraph holds the dependencies between the packages of a release,
+// indexed by the package depended upon.
+type dependencyGraph struct {
+	packages   map[string]Package
+	dependents map[string][]Dependent
+}
+
+// newDependencyGraph resolves the dependencies of pkgs against the packages
+// themselves and the names they provide. Every shipped alternative of a
+// clause is depended upon, since any of them may have been picked.
+func newDependencyGraph(pkgs []Package) *dependencyGraph {
+	graph := &dependencyGraph{
+		packages:   make(map[string]Package, len(pkgs)),
+		dependents: map[string][]Dependent{},
+	}
+	providers := map[string][]string{}
+	for _, pkg := range pkgs {
+		graph.packages[pkg.Name] = pkg
+		providers[pkg.Name] = append(providers[pkg.Name], pkg.Name)
+		for _, provided := range pkg.Provides {
+			for _, name := range clauseNames(provided) {
+				if name != pkg.Name {
+					providers[name] = append(providers[name], pkg.Name)
+				}
+			}
+		}
+	}
+
+	for _, pkg := range pkgs {
+		for _, relation := range []struct {
+			name    string
+			clauses []string
+		}{
+			{RelationDepends, pkg.Depends},
+			{RelationRecommends, pkg.Recommends},
+		} {
+			for _, clause := range relation.clauses {
+				targets := []string{}
+				for _, name := range clauseNames(clause) {
+					for _, target := range providers[name] {
+						if target != pkg.Name && !slices.Contains(targets, target) {
+							targets = append(targets, target)
+						}
+					}
+				}
+				for _, target := range targets {
+					graph.dependents[target] = append(graph.dependents[target], Dependent{
+						Name:         pkg.Name,
+						Version:      pkg.Version,
+						Relationship: relation.name,
+						Clause:       clause,
+					})
+				}
+			}
+		}
+	}
+
+	// Hard dependencies come first, so that chains prefer them
+	for _, dependents := range graph.dependents {
+		slices.SortFunc(dependents, func(a, b Dependent) int {
+			return cmp.Or(cmp.Compare(a.Relationship, b.Relationship), cmp.Compare(a.Name, b.Name))
+		})
+	}
+
+	return graph
+}
+
+// ReverseDependencies returns the packages of the release depending on the
+// package with the given name, directly or through a name it provides,
+// depends before recommends, then by name.
+func (re *Release) ReverseDependencies(name string) ([]Dependent, error) {
+	graph := newDependencyGraph(re.Packages)
+	if _, ok := graph.packages[name]; !ok {
+		return nil, notFound("release %s doesn't ship %s", re.Digest, name)
+	}
+
+	return append([]Dependent{}, graph.dependents[name]...), nil
+}
+
+// ExplainAdded returns the dependency chains that pulled in the packages
+// shipped by other but not by re, sorted by name. Each chain is the shortest
+// one starting from a package that re already shipped, or that nothing
+// depends on.
+func (re *Release) ExplainAdded(other *Release) []DependencyChain {
+	previous := make(map[string]bool, len(re.Packages))
+	for _, pkg := range re.Packages {
+		previous[pkg.Name] = true
+	}
+
+	graph := newDependencyGraph(other.Packages)
+	chains := []DependencyChain{}
+	for _, pkg := range other.Packages {
+		if previous[pkg.Name] {
+			continue
+		}
+		chains = append(chains, DependencyChain{Name: pkg.Name, Chain: graph.chain(pkg.Name, previous)})
+	}
+	slices.SortFunc(chains, func(a, b DependencyChain) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return chains
+}
+
+// chain walks the dependents of name breadth-first, until reaching a package
+// in roots or one without dependents, and returns the path from it to name.
+// Cycles of new packages end the walk at the farthest package reached.
+func (g *dependencyGraph) chain(name string, roots map[string]bool) []string {
+	next := map[string]string{name: ""}
+	queue := []string{name}
+	current := name
+	for len(queue) > 0 {
+		current, queue = queue[0], queue[1:]
+		if current != name && roots[current] || len(g.dependents[current]) == 0 {
+			break
+		}
+		for _, dependent := range g.dependents[current] {
+			if _, seen := next[dependent.Name]; !seen {
+				next[dependent.Name] = current
+				queue = append(queue, dependent.Name)
+			}
+		}
+	}
+
+	chain := []string{}
+	for pkg := current; pkg != ""; pkg = next[pkg] {
+		chain = append(chain, pkg)
+	}
+
+	return chain
+}
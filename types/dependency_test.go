@@ -0,0 +1,87 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDependencies(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+
+	releases := []*Release{
+		{Digest: "sha256:first", Packages: []Package{
+			{Name: "libc6", Version: "2.36"},
+			{Name: "bash", Version: "5.2", Depends: []string{"libc6 (>= 2.36)"}},
+		}},
+		{Digest: "sha256:second", Packages: []Package{
+			{Name: "libc6", Version: "2.36"},
+			{Name: "bash", Version: "5.2", Depends: []string{"libc6 (>= 2.36)"}},
+			{Name: "mutt", Version: "2.2", Depends: []string{"libc6", "libgpgme11"}, Recommends: []string{"default-mta | mail-transport-agent"}},
+			{Name: "libgpgme11", Version: "1.18", Depends: []string{"gnupg:any", "libc6"}},
+			{Name: "gnupg", Version: "2.2"},
+			{Name: "exim4", Version: "4.96", Provides: []string{"mail-transport-agent"}},
+			{Name: "cycle-a", Version: "1", Depends: []string{"cycle-b"}},
+			{Name: "cycle-b", Version: "1", Depends: []string{"cycle-a"}},
+		}},
+	}
+	for i, release := range releases {
+		release.ImageID = image.ID
+		release.Date = time.Now().Add(time.Duration(i) * time.Hour)
+		if _, err := image.NewRelease(db, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Relationships are stored with the release, bash 5.2 being shared
+	first, err := image.GetReleaseByDigest(db, "sha256:first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := image.GetReleaseByDigest(db, "sha256:second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chains := []DependencyChain{
+		{Name: "cycle-a", Chain: []string{"cycle-b", "cycle-a"}},
+		{Name: "cycle-b", Chain: []string{"cycle-a", "cycle-b"}},
+		{Name: "exim4", Chain: []string{"mutt", "exim4"}},
+		{Name: "gnupg", Chain: []string{"mutt", "libgpgme11", "gnupg"}},
+		{Name: "libgpgme11", Chain: []string{"mutt", "libgpgme11"}},
+		{Name: "mutt", Chain: []string{"mutt"}},
+	}
+	if explained := first.ExplainAdded(second); !reflect.DeepEqual(explained, chains) {
+		t.Errorf("expected %+v, got %+v", chains, explained)
+	}
+
+	dependents := []Dependent{
+		{Name: "bash", Version: "5.2", Relationship: RelationDepends, Clause: "libc6 (>= 2.36)"},
+		{Name: "libgpgme11", Version: "1.18", Relationship: RelationDepends, Clause: "libc6"},
+		{Name: "mutt", Version: "2.2", Relationship: RelationDepends, Clause: "libc6"},
+	}
+	if rdepends, err := second.ReverseDependencies("libc6"); err != nil || !reflect.DeepEqual(rdepends, dependents) {
+		t.Errorf("expected %+v, got %+v (%v)", dependents, rdepends, err)
+	}
+	dependents = []Dependent{
+		{Name: "mutt", Version: "2.2", Relationship: RelationRecommends, Clause: "default-mta | mail-transport-agent"},
+	}
+	if rdepends, err := second.ReverseDependencies("exim4"); err != nil || !reflect.DeepEqual(rdepends, dependents) {
+		t.Errorf("expected %+v, got %+v (%v)", dependents, rdepends, err)
+	}
+	if rdepends, err := first.ReverseDependencies("bash"); err != nil || len(rdepends) != 0 {
+		t.Errorf("expected no dependents, got %+v (%v)", rdepends, err)
+	}
+	if _, err := first.ReverseDependencies("mutt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
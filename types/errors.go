@@ -0,0 +1,56 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound matches, through errors.Is, the errors returned when an
+	// image or release does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidQuery matches the errors returned for malformed listing
+	// queries, such as an unknown sort order or a corrupted cursor.
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrConflict matches the errors returned when a change would break a
+	// uniqueness rule, such as release versions, or when a tag points to a
+	// yanked release.
+	ErrConflict = errors.New("conflict")
+	// ErrPolicyViolation matches the PolicyError returned when a release
+	// breaks the publish policy of its image.
+	ErrPolicyViolation = errors.New("policy violation")
+)
+
+// kindError is an error matching one of the sentinel errors above, while
+// keeping its own message.
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func notFound(format string, args ...any) error {
+	return &kindError{kind: ErrNotFound, message: fmt.Sprintf(format, args...)}
+}
+
+func invalidQuery(format string, args ...any) error {
+	return &kindError{kind: ErrInvalidQuery, message: fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...any) error {
+	return &kindError{kind: ErrConflict, message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,308 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Unix file type bits of FileEntry.Mode.
+const (
+	ModeRegular = 0o100000
+	ModeSymlink = 0o120000
+)
+
+// FileEntry is a file installed by a release: a regular file, whose SHA256 is
+// the digest of its content, or a symbolic link, whose SHA256 is the digest
+// of its target. Directories are left out.
+type FileEntry struct {
+	Path   string `json:"path"` // absolute path, such as /etc/hostname
+	Mode   uint32 `json:"mode"` // Unix mode, type bits included, such as 0100644
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// PackageFiles is the manifest of the files installed by a package, Package
+// being empty for the files not owned by any package.
+type PackageFiles struct {
+	Package string      `json:"package"`
+	Files   []FileEntry `json:"files"`
+}
+
+// Manifest is a list of files stored only once, content-addressed by Hash,
+// and shared between the releases and packages installing the same files.
+type Manifest struct {
+	ID    uint        `gorm:"primarykey"`
+	Hash  string      `gorm:"uniqueIndex;size:64"`
+	Files []FileEntry `gorm:"serializer:json"`
+}
+
+// ReleaseManifest associates a release with the manifest of one of its
+// packages.
+type ReleaseManifest struct {
+	ReleaseID  uint   `gorm:"primaryKey;autoIncrement:false"`
+	Package    string `gorm:"primaryKey"`
+	ManifestID uint   `gorm:"index"`
+}
+
+// manifestHash returns the content address of files, which must be sorted by
+// path.
+func manifestHash(files []FileEntry) string {
+	h := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(h, "%s\x00%o\x00%s\n", file.Path, file.Mode, file.SHA256)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validateManifests checks that every package has a single manifest, and that
+// every file has a clean absolute path and a well-formed digest.
+func validateManifests(manifests []PackageFiles) error {
+	seen := make(map[string]bool, len(manifests))
+	for _, manifest := range manifests {
+		if seen[manifest.Package] {
+			return invalidQuery("duplicated manifest for package %q", manifest.Package)
+		}
+		seen[manifest.Package] = true
+
+		for _, file := range manifest.Files {
+			if !path.IsAbs(file.Path) || path.Clean(file.Path) != file.Path || file.Path == "/" {
+				return invalidQuery("invalid file path %q in manifest of %q", file.Path, manifest.Package)
+			}
+			if _, err := hex.DecodeString(file.SHA256); err != nil || file.SHA256 != "" && len(file.SHA256) != sha256.Size*2 {
+				return invalidQuery("invalid sha256 %q for %s", file.SHA256, file.Path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetFiles replaces the file manifests of the release. Files are sorted by
+// path, and identical manifests are stored once whatever the release.
+func (re *Release) SetFiles(db *gorm.DB, manifests []PackageFiles) error {
+	if err := validateManifests(manifests); err != nil {
+		return err
+	}
+
+	rows := make([]Manifest, 0, len(manifests))
+	for _, manifest := range manifests {
+		files := slices.Clone(manifest.Files)
+		slices.SortFunc(files, func(a, b FileEntry) int { return cmp.Compare(a.Path, b.Path) })
+		files = slices.CompactFunc(files, func(a, b FileEntry) bool { return a.Path == b.Path })
+		rows = append(rows, Manifest{Hash: manifestHash(files), Files: files})
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Inserted copies are used, as in storePackages
+		if inserted := slices.Clone(rows); len(inserted) > 0 {
+			err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "hash"}}, DoNothing: true}).
+				CreateInBatches(&inserted, packageBatchSize).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		hashes := make([]string, 0, len(rows))
+		for _, row := range rows {
+			hashes = append(hashes, row.Hash)
+		}
+		var stored []Manifest
+		if err := tx.Select("id", "hash").Where("hash IN ?", hashes).Find(&stored).Error; err != nil {
+			return err
+		}
+		ids := make(map[string]uint, len(stored))
+		for _, manifest := range stored {
+			ids[manifest.Hash] = manifest.ID
+		}
+
+		if err := tx.Where("release_id = ?", re.ID).Delete(&ReleaseManifest{}).Error; err != nil {
+			return err
+		}
+		links := make([]ReleaseManifest, 0, len(manifests))
+		for i, manifest := range manifests {
+			links = append(links, ReleaseManifest{ReleaseID: re.ID, Package: manifest.Package, ManifestID: ids[rows[i].Hash]})
+		}
+		if len(links) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&links, packageBatchSize).Error
+	})
+}
+
+// manifestIDs returns the IDs of the manifests of the release, by package.
+func (re *Release) manifestIDs(db *gorm.DB) (map[string]uint, error) {
+	var links []ReleaseManifest
+	if err := db.Where("release_id = ?", re.ID).Find(&links).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]uint, len(links))
+	for _, link := range links {
+		ids[link.Package] = link.ManifestID
+	}
+
+	return ids, nil
+}
+
+// Files returns the file manifests of the release, sorted by package.
+func (re *Release) Files(db *gorm.DB) ([]PackageFiles, error) {
+	ids, err := re.manifestIDs(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadManifests(db, ids)
+}
+
+// loadManifests fetches the manifests with the given IDs, by package, and
+// returns them sorted by package.
+func loadManifests(db *gorm.DB, ids map[string]uint) ([]PackageFiles, error) {
+	stored := make(map[uint][]FileEntry, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		unique = append(unique, id)
+	}
+	for start := 0; start < len(unique); start += packageBatchSize {
+		var batch []Manifest
+		if err := db.Where("id IN ?", unique[start:min(start+packageBatchSize, len(unique))]).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		for _, manifest := range batch {
+			stored[manifest.ID] = manifest.Files
+		}
+	}
+
+	manifests := make([]PackageFiles, 0, len(ids))
+	for pkg, id := range ids {
+		manifests = append(manifests, PackageFiles{Package: pkg, Files: stored[id]})
+	}
+	slices.SortFunc(manifests, func(a, b PackageFiles) int { return cmp.Compare(a.Package, b.Package) })
+
+	return manifests, nil
+}
+
+// FileChange is a file added, removed or modified between two releases,
+// along with the package installing it, in the new release unless removed.
+type FileChange struct {
+	Path           string `json:"path"`
+	Package        string `json:"package,omitempty"`
+	PreviousMode   uint32 `json:"previous_mode,omitempty"`
+	NewMode        uint32 `json:"new_mode,omitempty"`
+	PreviousSHA256 string `json:"previous_sha256,omitempty"`
+	NewSHA256      string `json:"new_sha256,omitempty"`
+}
+
+// FileDiff is the difference in files between two releases. Modified files
+// changed content, target or mode.
+type FileDiff struct {
+	Added    []FileChange `json:"added"`
+	Removed  []FileChange `json:"removed"`
+	Modified []FileChange `json:"modified"`
+}
+
+// DiffFiles diffs the files of re against other, keeping those whose path
+// starts with prefix. Both releases must have file manifests. Manifests shared
+// by the same package in both releases are skipped without being loaded, as
+// packages can't install the same files.
+func (re *Release) DiffFiles(db *gorm.DB, other *Release, prefix string) (*FileDiff, error) {
+	previousIDs, err := re.manifestIDs(db)
+	if err != nil {
+		return nil, err
+	}
+	newIDs, err := other.manifestIDs(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, release := range []struct {
+		digest string
+		ids    map[string]uint
+	}{{re.Digest, previousIDs}, {other.Digest, newIDs}} {
+		if len(release.ids) == 0 {
+			return nil, notFound("release %s has no file manifests", release.digest)
+		}
+	}
+	for pkg, id := range previousIDs {
+		if newIDs[pkg] == id {
+			delete(previousIDs, pkg)
+			delete(newIDs, pkg)
+		}
+	}
+
+	type owned struct {
+		FileEntry
+		pkg string
+	}
+	index := func(ids map[string]uint) (map[string]owned, error) {
+		manifests, err := loadManifests(db, ids)
+		if err != nil {
+			return nil, err
+		}
+		files := map[string]owned{}
+		for _, manifest := range manifests {
+			for _, file := range manifest.Files {
+				if strings.HasPrefix(file.Path, prefix) {
+					files[file.Path] = owned{file, manifest.Package}
+				}
+			}
+		}
+		return files, nil
+	}
+	previous, err := index(previousIDs)
+	if err != nil {
+		return nil, err
+	}
+	current, err := index(newIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &FileDiff{Added: []FileChange{}, Removed: []FileChange{}, Modified: []FileChange{}}
+	for filePath, file := range current {
+		old, ok := previous[filePath]
+		switch {
+		case !ok:
+			result.Added = append(result.Added, FileChange{Path: filePath, Package: file.pkg, NewMode: file.Mode, NewSHA256: file.SHA256})
+		case old.Mode != file.Mode || old.SHA256 != file.SHA256:
+			result.Modified = append(result.Modified, FileChange{
+				Path:           filePath,
+				Package:        file.pkg,
+				PreviousMode:   old.Mode,
+				NewMode:        file.Mode,
+				PreviousSHA256: old.SHA256,
+				NewSHA256:      file.SHA256,
+			})
+		}
+	}
+	for filePath, file := range previous {
+		if _, ok := current[filePath]; !ok {
+			result.Removed = append(result.Removed, FileChange{Path: filePath, Package: file.pkg, PreviousMode: file.Mode, PreviousSHA256: file.SHA256})
+		}
+	}
+	for _, changes := range [][]FileChange{result.Added, result.Removed, result.Modified} {
+		slices.SortFunc(changes, func(a, b FileChange) int { return cmp.Compare(a.Path, b.Path) })
+	}
+
+	return result, nil
+}
+
+// DeleteOrphanManifests permanently removes file manifests that no longer
+// belong to any release, returning how many were deleted.
+func DeleteOrphanManifests(db *gorm.DB) (int64, error) {
+	status := db.Where("id NOT IN (?)", db.Model(&ReleaseManifest{}).Select("manifest_id")).Delete(&Manifest{})
+	return status.RowsAffected, status.Error
+}
@@ -0,0 +1,117 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFiles(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+
+	digest := func(c string) string { return strings.Repeat(c, 64) }
+	bash := PackageFiles{Package: "bash", Files: []FileEntry{
+		{Path: "/usr/bin/bash", Mode: ModeRegular | 0o755, SHA256: digest("b")},
+		{Path: "/etc/bash.bashrc", Mode: ModeRegular | 0o644, SHA256: digest("1")},
+	}}
+	manifests := [][]PackageFiles{
+		{
+			bash,
+			{Package: "openssh-server", Files: []FileEntry{
+				{Path: "/etc/ssh/sshd_config", Mode: ModeRegular | 0o644, SHA256: digest("2")},
+				{Path: "/etc/ssh/moduli", Mode: ModeRegular | 0o644, SHA256: digest("3")},
+				{Path: "/usr/sbin/sshd", Mode: ModeRegular | 0o755, SHA256: digest("4")},
+			}},
+			{Package: "", Files: []FileEntry{{Path: "/etc/hostname", Mode: ModeRegular | 0o644, SHA256: digest("5")}}},
+		},
+		{
+			bash,
+			{Package: "openssh-server", Files: []FileEntry{
+				{Path: "/etc/ssh/sshd_config", Mode: ModeRegular | 0o600, SHA256: digest("2")},
+				{Path: "/usr/sbin/sshd", Mode: ModeRegular | 0o755, SHA256: digest("6")},
+				{Path: "/etc/ssh/sshd_config.d", Mode: ModeSymlink | 0o777, SHA256: digest("7")},
+			}},
+			{Package: "", Files: []FileEntry{{Path: "/etc/hostname", Mode: ModeRegular | 0o644, SHA256: digest("5")}}},
+		},
+	}
+
+	releases := []*Release{}
+	for i, digest := range []string{"sha256:first", "sha256:second"} {
+		release, err := image.NewRelease(db, &Release{Digest: digest, ImageID: image.ID, Date: time.Now().Add(time.Duration(i) * time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := release.DiffFiles(db, release, ""); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected releases without manifests to be reported, got %v", err)
+		}
+		if err := release.SetFiles(db, manifests[i]); err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	// The manifests of bash and unowned files are shared between releases
+	var count int64
+	if err := db.Model(&Manifest{}).Count(&count).Error; err != nil || count != 4 {
+		t.Errorf("expected 4 stored manifests, got %d (%v)", count, err)
+	}
+	files, err := releases[0].Files(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[0].Package != "" || files[1].Package != "bash" || files[1].Files[0].Path != "/etc/bash.bashrc" {
+		t.Errorf("expected manifests sorted by package and path, got %+v", files)
+	}
+
+	expected := &FileDiff{
+		Added: []FileChange{
+			{Path: "/etc/ssh/sshd_config.d", Package: "openssh-server", NewMode: ModeSymlink | 0o777, NewSHA256: digest("7")},
+		},
+		Removed: []FileChange{
+			{Path: "/etc/ssh/moduli", Package: "openssh-server", PreviousMode: ModeRegular | 0o644, PreviousSHA256: digest("3")},
+		},
+		Modified: []FileChange{
+			{Path: "/etc/ssh/sshd_config", Package: "openssh-server", PreviousMode: ModeRegular | 0o644, NewMode: ModeRegular | 0o600, PreviousSHA256: digest("2"), NewSHA256: digest("2")},
+		},
+	}
+	result, err := releases[0].DiffFiles(db, releases[1], "/etc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+	if result, err := releases[0].DiffFiles(db, releases[1], ""); err != nil || len(result.Modified) != 2 {
+		t.Errorf("expected sshd to be modified too, got %+v (%v)", result, err)
+	}
+
+	invalid := [][]PackageFiles{
+		{{Package: "bash", Files: []FileEntry{{Path: "usr/bin/bash"}}}},
+		{{Package: "bash", Files: []FileEntry{{Path: "/usr/bin/../bash"}}}},
+		{{Package: "bash", Files: []FileEntry{{Path: "/usr/bin/bash", SHA256: "abc"}}}},
+		{bash, bash},
+	}
+	for _, manifests := range invalid {
+		if err := releases[0].SetFiles(db, manifests); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("expected %+v to be rejected, got %v", manifests, err)
+		}
+	}
+
+	// Only the manifests of openssh-server in the first release are orphaned
+	if err := image.DeleteRelease(db, "sha256:first"); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := DeleteOrphanManifests(db); err != nil || deleted != 1 {
+		t.Errorf("expected 1 orphaned manifest, got %d (%v)", deleted, err)
+	}
+}
@@ -8,17 +8,47 @@ package types
  */
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"sort"
 
 	"gorm.io/gorm"
 )
 
+// LatestRef is the symbolic reference that resolves to the most recent
+// non-yanked release of an image.
+const LatestRef = "latest"
+
 type Image struct {
 	gorm.Model `json:"-"`
-	Name       string    `json:"name" gorm:"unique"`
-	URL        string    `json:"url" gorm:"unique"`
-	Releases   []Release `json:"releases,omitempty"`
+	Name       string          `json:"name" gorm:"unique"`
+	URL        string          `json:"url" gorm:"unique"`
+	Comparator string          `json:"comparator,omitempty"` // name of the version comparator, see diff.Comparators
+	Retention  RetentionPolicy `json:"retention" gorm:"embedded;embeddedPrefix:retention_"`
+	Policy     PublishPolicy   `json:"policy" gorm:"serializer:json"`
+	Releases   []Release       `json:"releases,omitempty"`
+}
+
+// NewImage stores image alongside any releases it already carries.
+func NewImage(db *gorm.DB, image *Image) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		releases := image.Releases
+		image.Releases = nil
+		if err := tx.Create(image).Error; err != nil {
+			return err
+		}
+
+		for i := range releases {
+			releases[i].ImageID = image.ID
+			newRelease, err := image.NewRelease(tx, &releases[i])
+			if err != nil {
+				return err
+			}
+			image.Releases = append(image.Releases, *newRelease)
+		}
+
+		return nil
+	})
 }
 
 func GetImages(db *gorm.DB) ([]Image, error) {
@@ -27,14 +57,26 @@ func GetImages(db *gorm.DB) ([]Image, error) {
 	return images, err
 }
 
-func GetImageByName(db *gorm.DB, name string) (Image, error) {
+// FindImage returns the image with the given name, without its releases.
+func FindImage(db *gorm.DB, name string) (Image, error) {
 	var image Image
 	err := db.First(&image, "name = ?", name).Error
 	if err == gorm.ErrRecordNotFound {
-		return image, fmt.Errorf("no image found with name %s", name)
+		return image, notFound("no image found with name %s", name)
+	}
+
+	return image, err
+}
+
+// GetImageByName returns the image with the given name and all of its
+// releases, without their packages, sorted from newest to oldest.
+func GetImageByName(db *gorm.DB, name string) (Image, error) {
+	image, err := FindImage(db, name)
+	if err != nil {
+		return image, err
 	}
 
-	err = db.Where("image_id = ?", image.ID).Find(&image.Releases).Error
+	err = db.Where("image_id = ?", image.ID).Order("date DESC, id DESC").Find(&image.Releases).Error
 	if err != nil {
 		return image, fmt.Errorf("failed to get releases for %s: %v", name, err)
 	}
@@ -42,43 +84,237 @@ func GetImageByName(db *gorm.DB, name string) (Image, error) {
 	return image, err
 }
 
-func (im *Image) GetLatestRelease() *Release {
-	if len(im.Releases) == 0 {
+// ImageUpdate holds the editable fields of an image. Nil fields are left
+// untouched.
+type ImageUpdate struct {
+	Name       *string          `json:"name"`
+	URL        *string          `json:"url"`
+	Comparator *string          `json:"comparator"`
+	Retention  *RetentionPolicy `json:"retention"`
+	Policy     *PublishPolicy   `json:"policy"`
+}
+
+// Update applies the non-nil fields of update to the image.
+func (im *Image) Update(db *gorm.DB, update ImageUpdate) error {
+	updates := map[string]any{}
+	if update.Name != nil {
+		updates["name"] = *update.Name
+	}
+	if update.URL != nil {
+		updates["url"] = *update.URL
+	}
+	if update.Comparator != nil {
+		updates["comparator"] = *update.Comparator
+	}
+	if update.Retention != nil {
+		updates["retention_keep_last"] = update.Retention.KeepLast
+		updates["retention_max_age_days"] = update.Retention.MaxAgeDays
+	}
+	if update.Policy != nil {
+		policy, err := json.Marshal(update.Policy)
+		if err != nil {
+			return err
+		}
+		updates["policy"] = string(policy)
+	}
+	if len(updates) == 0 {
 		return nil
 	}
 
-	sort.Slice(im.Releases, func(i, j int) bool {
-		return im.Releases[i].Date.After(im.Releases[j].Date)
+	return db.Model(im).Updates(updates).Error
+}
+
+// Digests returns the digests of every release of the image.
+func (im *Image) Digests() []string {
+	digests := make([]string, 0, len(im.Releases))
+	for _, release := range im.Releases {
+		digests = append(digests, release.Digest)
+	}
+
+	return digests
+}
+
+// Delete permanently removes the image and all of its releases, tags, tag
+// history and webhooks.
+func (im *Image) Delete(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		hooks := []uint{}
+		if err := tx.Model(&Webhook{}).Where("image_id = ?", im.ID).Pluck("id", &hooks).Error; err != nil {
+			return err
+		}
+		if err := deleteWebhooks(tx, hooks); err != nil {
+			return err
+		}
+		if err := tx.Where("image_id = ?", im.ID).Delete(&Tag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("image_id = ?", im.ID).Delete(&TagAssignment{}).Error; err != nil {
+			return err
+		}
+
+		for i := range im.Releases {
+			if err := im.Releases[i].delete(tx); err != nil {
+				return err
+			}
+		}
+
+		return tx.Unscoped().Delete(im).Error
 	})
+}
+
+// GetLatestRelease returns the most recent release of the image, without its
+// packages, ignoring yanked releases. It returns nil if there is none.
+func (im *Image) GetLatestRelease(db *gorm.DB) (*Release, error) {
+	var release Release
+	err := db.Where("image_id = ? AND yanked = ?", im.ID, false).Order("date DESC, id DESC").First(&release).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &release, nil
+}
+
+// GetPreviousRelease returns the most recent non-yanked release of the image
+// older than release, with its packages. It returns nil if there is none.
+func (im *Image) GetPreviousRelease(db *gorm.DB, release *Release) (*Release, error) {
+	var previous Release
+	date := release.Date.UTC()
+	err := db.Where("image_id = ? AND yanked = ? AND (date < ? OR (date = ? AND id < ?))", im.ID, false, date, date, release.ID).
+		Order("date DESC, id DESC").First(&previous).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	return &im.Releases[0]
+	return &previous, previous.loadPackages(db)
+}
+
+// GetRecentReleases returns up to count of the most recent releases of the
+// image with their packages, newest first, ignoring yanked releases.
+func (im *Image) GetRecentReleases(db *gorm.DB, count int) ([]Release, error) {
+	releases := []Release{}
+	err := db.Where("image_id = ? AND yanked = ?", im.ID, false).Order("date DESC, id DESC").Limit(count).Find(&releases).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range releases {
+		if err := releases[i].loadPackages(db); err != nil {
+			return nil, err
+		}
+	}
+
+	return releases, nil
 }
 
 func (im *Image) GetReleaseByDigest(db *gorm.DB, digest string) (*Release, error) {
-	if len(im.Releases) == 0 {
-		return nil, fmt.Errorf("no release found with digest %s", digest)
+	var release Release
+	err := db.First(&release, "digest = ? AND image_id = ?", digest, im.ID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, notFound("no release found with digest %s", digest)
+	}
+	if err != nil {
+		return nil, err
 	}
 
+	return &release, release.loadPackages(db)
+}
+
+// GetReleaseByVersion returns the release of the image labelled version.
+func (im *Image) GetReleaseByVersion(db *gorm.DB, version string) (*Release, error) {
 	var release Release
-	err := db.Preload("Packages").First(&release, "digest = ?", digest).Error
+	err := db.Where("image_id = ? AND version = ?", im.ID, version).Order("date DESC, id DESC").First(&release).Error
 	if err == gorm.ErrRecordNotFound {
-		return nil, fmt.Errorf("no release found with digest %s", digest)
+		return nil, notFound("no release found with version %s", version)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return &release, err
+	return &release, release.loadPackages(db)
 }
 
-func (im *Image) NewRelease(db *gorm.DB, release *Release) (*Release, error) {
-	status := db.Create(release)
-	if status.Error != nil {
-		return nil, status.Error
+// ResolveRelease returns the release pointed to by ref, which is either a
+// release digest, a tag of the image or LatestRef. Only digests resolve to
+// yanked releases.
+func (im *Image) ResolveRelease(db *gorm.DB, ref string) (*Release, error) {
+	if ref != LatestRef {
+		if ValidateTagName(ref) == nil {
+			tagged, err := im.GetTaggedRelease(db, ref)
+			if err == nil {
+				return tagged, tagged.loadPackages(db)
+			}
+			if !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+		}
+
+		return im.GetReleaseByDigest(db, ref)
+	}
+
+	latest, err := im.GetLatestRelease(db)
+	if err != nil {
+		return nil, err
 	}
+	if latest == nil {
+		return nil, notFound("image %s has no releases", im.Name)
+	}
+
+	return latest, latest.loadPackages(db)
+}
 
-	var newRelease Release
-	err := db.Preload("Packages").First(&newRelease, release.ID).Error
-	if status.Error != nil {
+// ResolveChannel returns the release tagged channel, or the latest one when
+// channel is empty.
+func (im *Image) ResolveChannel(db *gorm.DB, channel string) (*Release, error) {
+	if channel == "" {
+		return im.ResolveRelease(db, LatestRef)
+	}
+
+	release, err := im.GetTaggedRelease(db, channel)
+	if err != nil {
 		return nil, err
 	}
 
-	return &newRelease, nil
+	return release, release.loadPackages(db)
+}
+
+// NewRelease stores release and its packages in a single transaction.
+// Packages already known from other releases are reused.
+func (im *Image) NewRelease(db *gorm.DB, release *Release) (*Release, error) {
+	// SQLite compares dates as text, which only orders them correctly when
+	// they share the same time zone
+	release.Date = release.Date.UTC()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := checkVersion(tx, im.ID, 0, release.Version); err != nil {
+			return err
+		}
+		if err := storePackages(tx, &release.Packages); err != nil {
+			return err
+		}
+		if err := tx.Omit("Packages").Create(release).Error; err != nil {
+			return err
+		}
+		return linkPackages(tx, release.ID, release.Packages)
+	})
+	if err != nil {
+		return nil, versionConflict(db, err, im.ID, 0, release.Version)
+	}
+	release.InstalledSize = TotalInstalledSize(release.Packages)
+
+	return release, nil
+}
+
+// DeleteRelease permanently removes the release with the given digest.
+func (im *Image) DeleteRelease(db *gorm.DB, digest string) error {
+	release, err := im.GetReleaseByDigest(db, digest)
+	if err != nil {
+		return err
+	}
+
+	return release.delete(db)
 }
@@ -0,0 +1,88 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"cmp"
+	"slices"
+)
+
+// NoAssertion is the SPDX value standing for an unknown license, under which
+// packages without license are counted.
+const NoAssertion = "NOASSERTION"
+
+// LicenseChange is a package whose license differs between two releases,
+// whether or not its version changed too.
+type LicenseChange struct {
+	Name            string `json:"name"`
+	PreviousVersion string `json:"previous_version"`
+	NewVersion      string `json:"new_version"`
+	PreviousLicense string `json:"previous_license"`
+	NewLicense      string `json:"new_license"`
+}
+
+// DiffLicenses returns the packages shipped by both re and other whose
+// license changed, sorted by name. Packages are matched by name, as in
+// DiffPackages, and those whose license is unknown in either release are
+// skipped, since an unknown license doesn't tell whether it changed.
+func (re *Release) DiffLicenses(other *Release) []LicenseChange {
+	previous := make(map[string]Package, len(re.Packages))
+	for _, pkg := range re.Packages {
+		previous[pkg.Name] = pkg
+	}
+
+	changes := []LicenseChange{}
+	for _, pkg := range other.Packages {
+		old, ok := previous[pkg.Name]
+		if !ok || old.License == "" || pkg.License == "" || old.License == pkg.License {
+			continue
+		}
+		changes = append(changes, LicenseChange{
+			Name:            pkg.Name,
+			PreviousVersion: old.Version,
+			NewVersion:      pkg.Version,
+			PreviousLicense: old.License,
+			NewLicense:      pkg.License,
+		})
+	}
+	slices.SortFunc(changes, func(a, b LicenseChange) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return changes
+}
+
+// LicenseCount is the number of packages of a release under a license.
+type LicenseCount struct {
+	License  string `json:"license"`
+	Packages int    `json:"packages"`
+}
+
+// CountLicenses returns how many of pkgs are under each license expression,
+// most common first, then by license. Packages without license are counted
+// under NoAssertion.
+func CountLicenses(pkgs []Package) []LicenseCount {
+	counts := map[string]int{}
+	for _, pkg := range pkgs {
+		license := pkg.License
+		if license == "" {
+			license = NoAssertion
+		}
+		counts[license]++
+	}
+
+	licenses := make([]LicenseCount, 0, len(counts))
+	for license, count := range counts {
+		licenses = append(licenses, LicenseCount{License: license, Packages: count})
+	}
+	slices.SortFunc(licenses, func(a, b LicenseCount) int {
+		return cmp.Or(cmp.Compare(b.Packages, a.Packages), cmp.Compare(a.License, b.License))
+	})
+
+	return licenses
+}
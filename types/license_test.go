@@ -0,0 +1,77 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLicenses(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+
+	releases := []*Release{
+		{Digest: "sha256:first", Packages: []Package{
+			{Name: "bash", Version: "5.2", License: "GPL-3.0-or-later"},
+			{Name: "zlib", Version: "1.3"},
+			{Name: "redis", Version: "7.2", License: "BSD-3-Clause"},
+			{Name: "tar", Version: "1.34", License: "GPL-3.0-or-later"},
+		}},
+		// The license of zlib 1.3 was unknown, and is filled in
+		{Digest: "sha256:second", Packages: []Package{
+			{Name: "bash", Version: "5.2", License: "GPL-2.0-only"},
+			{Name: "zlib", Version: "1.3", License: "Zlib"},
+			{Name: "redis", Version: "7.4", License: "LicenseRef-RSALv2 OR SSPL-1.0"},
+			{Name: "tar", Version: "1.35"},
+		}},
+	}
+	for i, release := range releases {
+		release.ImageID = image.ID
+		release.Date = time.Now().Add(time.Duration(i) * time.Hour)
+		if _, err := image.NewRelease(db, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := image.GetReleaseByDigest(db, "sha256:first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := image.GetReleaseByDigest(db, "sha256:second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored packages keep their license, bash 5.2 being shared
+	expected := []LicenseChange{
+		{Name: "redis", PreviousVersion: "7.2", NewVersion: "7.4", PreviousLicense: "BSD-3-Clause", NewLicense: "LicenseRef-RSALv2 OR SSPL-1.0"},
+	}
+	if changes := first.DiffLicenses(second); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+
+	counts := []LicenseCount{
+		{License: "GPL-3.0-or-later", Packages: 2},
+		{License: "BSD-3-Clause", Packages: 1},
+		{License: "Zlib", Packages: 1},
+	}
+	if licenses := CountLicenses(first.Packages); !reflect.DeepEqual(licenses, counts) {
+		t.Errorf("expected %+v, got %+v", counts, licenses)
+	}
+	counts = []LicenseCount{
+		{License: "GPL-3.0-or-later", Packages: 1},
+		{License: "LicenseRef-RSALv2 OR SSPL-1.0", Packages: 1},
+		{License: NoAssertion, Packages: 1},
+		{License: "Zlib", Packages: 1},
+	}
+	if licenses := CountLicenses(second.Packages); !reflect.DeepEqual(licenses, counts) {
+		t.Errorf("expected %+v, got %+v", counts, licenses)
+	}
+}
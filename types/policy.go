@@ -0,0 +1,218 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/vanilla-os/differ/diff"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// Rules of a PublishPolicy, as reported by violations.
+const (
+	RuleForbiddenRemoval      = "forbidden_removal"
+	RuleForbiddenDowngrade    = "forbidden_downgrade"
+	RuleMaxPackageCountChange = "max_package_count_change"
+	RuleRequiredPackage       = "required_package"
+	RulePinnedVersion         = "pinned_version"
+)
+
+// PublishPolicy gates the releases published to an image, which must satisfy
+// every rule set. Package names and pinned versions are glob patterns, where
+// "*" matches any sequence of characters and "?" any single character.
+// Removals, downgrades and the package count change are checked against the
+// latest release, and only apply once the image has one.
+type PublishPolicy struct {
+	// ForbiddenRemovals lists the packages that must not be removed.
+	ForbiddenRemovals []string `json:"forbidden_removals,omitempty" yaml:"forbidden_removals,omitempty"`
+	// ForbiddenDowngrades lists the packages that must not be downgraded.
+	ForbiddenDowngrades []string `json:"forbidden_downgrades,omitempty" yaml:"forbidden_downgrades,omitempty"`
+	// MaxPackageCountChange is how much the number of packages can grow or
+	// shrink from the latest release. Zero disables the rule.
+	MaxPackageCountChange int `json:"max_package_count_change,omitempty" yaml:"max_package_count_change,omitempty"`
+	// RequiredPackages lists the packages every release must ship.
+	RequiredPackages []string `json:"required_packages,omitempty" yaml:"required_packages,omitempty"`
+	// PinnedVersions maps packages to the versions they must have, when
+	// shipped.
+	PinnedVersions map[string]string `json:"pinned_versions,omitempty" yaml:"pinned_versions,omitempty"`
+}
+
+// PolicyViolation is a rule of a PublishPolicy broken by a release.
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Package string `json:"package,omitempty"`
+	Message string `json:"message"`
+}
+
+// PolicyError is returned when publishing a release violating the policy of
+// its image.
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+
+	return "release violates the publish policy of the image: " + strings.Join(messages, "; ")
+}
+
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrPolicyViolation
+}
+
+// ParsePublishPolicy reads a policy written in YAML, rejecting unknown rules.
+func ParsePublishPolicy(content []byte) (PublishPolicy, error) {
+	var policy PublishPolicy
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&policy); err != nil && !errors.Is(err, io.EOF) {
+		return policy, invalidQuery("invalid publish policy: %v", err)
+	}
+
+	return policy, policy.Validate()
+}
+
+// Enabled reports whether the policy has any rule set.
+func (p PublishPolicy) Enabled() bool {
+	return len(p.ForbiddenRemovals) > 0 || len(p.ForbiddenDowngrades) > 0 || p.MaxPackageCountChange > 0 ||
+		len(p.RequiredPackages) > 0 || len(p.PinnedVersions) > 0
+}
+
+// Validate checks that every pattern of the policy is well-formed.
+func (p PublishPolicy) Validate() error {
+	if p.MaxPackageCountChange < 0 {
+		return invalidQuery("max_package_count_change must not be negative")
+	}
+
+	patterns := slices.Concat(p.ForbiddenRemovals, p.ForbiddenDowngrades, p.RequiredPackages)
+	for name, version := range p.PinnedVersions {
+		patterns = append(patterns, name, version)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return invalidQuery("invalid pattern %q in publish policy", pattern)
+		}
+	}
+
+	return nil
+}
+
+// matches reports whether value matches the glob pattern, which was
+// validated beforehand.
+func matches(pattern, value string) bool {
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+// matchesAny reports whether name matches one of patterns.
+func matchesAny(patterns []string, name string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool { return matches(pattern, name) })
+}
+
+// Evaluate returns the rules of the policy broken by release, diffed against
+// latest with compare. A nil latest skips the rules about changes.
+func (p PublishPolicy) Evaluate(latest, release *Release, compare diff.Comparator) []PolicyViolation {
+	violations := []PolicyViolation{}
+
+	if latest != nil {
+		_, _, downgraded, removed := latest.DiffPackages(release, compare)
+		slices.SortFunc(removed, func(a, b diff.PackageDiff) int { return strings.Compare(a.Name, b.Name) })
+		slices.SortFunc(downgraded, func(a, b diff.PackageDiff) int { return strings.Compare(a.Name, b.Name) })
+		for _, pkg := range removed {
+			if matchesAny(p.ForbiddenRemovals, pkg.Name) {
+				violations = append(violations, PolicyViolation{
+					Rule:    RuleForbiddenRemoval,
+					Package: pkg.Name,
+					Message: fmt.Sprintf("%s must not be removed", pkg.Name),
+				})
+			}
+		}
+		for _, pkg := range downgraded {
+			if matchesAny(p.ForbiddenDowngrades, pkg.Name) {
+				violations = append(violations, PolicyViolation{
+					Rule:    RuleForbiddenDowngrade,
+					Package: pkg.Name,
+					Message: fmt.Sprintf("%s must not be downgraded from %s to %s", pkg.Name, pkg.PreviousVersion, pkg.NewVersion),
+				})
+			}
+		}
+
+		change := len(release.Packages) - len(latest.Packages)
+		if p.MaxPackageCountChange > 0 && max(change, -change) > p.MaxPackageCountChange {
+			violations = append(violations, PolicyViolation{
+				Rule:    RuleMaxPackageCountChange,
+				Message: fmt.Sprintf("the package count changes by %+d, more than %d", change, p.MaxPackageCountChange),
+			})
+		}
+	}
+
+	for _, pattern := range p.RequiredPackages {
+		if !slices.ContainsFunc(release.Packages, func(pkg Package) bool { return matches(pattern, pkg.Name) }) {
+			violations = append(violations, PolicyViolation{
+				Rule:    RuleRequiredPackage,
+				Package: pattern,
+				Message: fmt.Sprintf("%s is required", pattern),
+			})
+		}
+	}
+
+	pinned := make([]string, 0, len(p.PinnedVersions))
+	for name := range p.PinnedVersions {
+		pinned = append(pinned, name)
+	}
+	slices.Sort(pinned)
+	for _, pkg := range release.Packages {
+		for _, pattern := range pinned {
+			if !matches(pattern, pkg.Name) || matches(p.PinnedVersions[pattern], pkg.Version) {
+				continue
+			}
+			violations = append(violations, PolicyViolation{
+				Rule:    RulePinnedVersion,
+				Package: pkg.Name,
+				Message: fmt.Sprintf("%s is pinned to %s, got %s", pkg.Name, p.PinnedVersions[pattern], pkg.Version),
+			})
+		}
+	}
+
+	return violations
+}
+
+// CheckPolicy returns a PolicyError listing the rules of the publish policy of
+// the image broken by release, which is compared with the latest release.
+func (im *Image) CheckPolicy(db *gorm.DB, release *Release, compare diff.Comparator) error {
+	if !im.Policy.Enabled() {
+		return nil
+	}
+
+	latest, err := im.GetLatestRelease(db)
+	if err != nil {
+		return err
+	}
+	if latest != nil {
+		if err := latest.loadPackages(db); err != nil {
+			return err
+		}
+	}
+
+	if violations := im.Policy.Evaluate(latest, release, compare); len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
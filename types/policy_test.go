@@ -0,0 +1,77 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vanilla-os/differ/diff"
+)
+
+func TestParsePublishPolicy(t *testing.T) {
+	policy, err := ParsePublishPolicy([]byte("forbidden_downgrades: [\"*\"]\npinned_versions:\n  linux-image-*: \"6.1.*\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := PublishPolicy{ForbiddenDowngrades: []string{"*"}, PinnedVersions: map[string]string{"linux-image-*": "6.1.*"}}
+	if !reflect.DeepEqual(policy, expected) {
+		t.Errorf("expected %+v, got %+v", expected, policy)
+	}
+
+	if policy, err := ParsePublishPolicy(nil); err != nil || policy.Enabled() {
+		t.Errorf("empty policy should be disabled, got %+v, %v", policy, err)
+	}
+	for _, content := range []string{"forbidden_upgrades: [apt]\n", "required_packages: [\"[\"]\n", "max_package_count_change: -1\n"} {
+		if _, err := ParsePublishPolicy([]byte(content)); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("invalid policy %q was accepted: %v", content, err)
+		}
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+	image.Policy = PublishPolicy{ForbiddenDowngrades: []string{"apt"}, RequiredPackages: []string{"bash"}}
+	compare := diff.CompareVersions
+
+	// Rules about changes don't apply to the first release
+	first := &Release{Digest: "sha256:first", ImageID: image.ID, Date: time.Now(), Packages: []Package{
+		{Name: "apt", Version: "2.7.4"},
+		{Name: "bash", Version: "5.2"},
+	}}
+	if err := image.CheckPolicy(db, first, compare); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := image.NewRelease(db, first); err != nil {
+		t.Fatal(err)
+	}
+
+	second := &Release{Digest: "sha256:second", ImageID: image.ID, Date: time.Now(), Packages: []Package{
+		{Name: "apt", Version: "2.7.3"},
+	}}
+	err := image.CheckPolicy(db, second, compare)
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || !errors.Is(err, ErrPolicyViolation) {
+		t.Fatalf("expected a policy error, got %v", err)
+	}
+	expected := []PolicyViolation{
+		{Rule: RuleForbiddenDowngrade, Package: "apt", Message: "apt must not be downgraded from 2.7.4 to 2.7.3"},
+		{Rule: RuleRequiredPackage, Package: "bash", Message: "bash is required"},
+	}
+	if !reflect.DeepEqual(policyErr.Violations, expected) {
+		t.Errorf("expected %+v, got %+v", expected, policyErr.Violations)
+	}
+
+	image.Policy = PublishPolicy{}
+	if err := image.CheckPolicy(db, second, compare); err != nil {
+		t.Errorf("disabled policy refused release: %v", err)
+	}
+}
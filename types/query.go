@@ -0,0 +1,188 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Page sizes of listings.
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// ImageQuery selects a page of images.
+type ImageQuery struct {
+	// Prefix restricts the listing to images whose name starts with it.
+	Prefix string `form:"prefix"`
+	// Sort is "name" (the default) or "created", prefixed by "-" for
+	// descending order.
+	Sort   string `form:"sort"`
+	Limit  int    `form:"limit"`
+	Cursor string `form:"cursor"`
+}
+
+// ReleaseQuery selects a page of releases of an image.
+type ReleaseQuery struct {
+	// Since and Until restrict the listing to releases dated from Since,
+	// included, to Until, excluded. Zero values leave the range open.
+	Since time.Time `form:"since"`
+	Until time.Time `form:"until"`
+	// Sort is "date" or "-date" (the default), for oldest or newest first.
+	Sort   string `form:"sort"`
+	Limit  int    `form:"limit"`
+	Cursor string `form:"cursor"`
+}
+
+// ListImages returns the page of images selected by query, and the cursor
+// of the next page, empty on the last one.
+func ListImages(db *gorm.DB, query ImageQuery) ([]Image, string, error) {
+	if query.Sort == "" {
+		query.Sort = "name"
+	}
+
+	tx := db.Model(&Image{})
+	if query.Prefix != "" {
+		tx = tx.Where("substr(name, 1, ?) = ?", utf8.RuneCountInString(query.Prefix), query.Prefix)
+	}
+
+	return listPage(tx, query.Sort, query.Limit, query.Cursor, map[string]sortColumn[Image]{
+		"name": {
+			name:   "name",
+			cursor: func(im *Image) cursor { return cursor{Key: im.Name, ID: im.ID} },
+			value:  func(c cursor) (any, error) { return c.Key, nil },
+		},
+		"created": {
+			name:   "id",
+			cursor: func(im *Image) cursor { return cursor{ID: im.ID} },
+		},
+	})
+}
+
+// ListReleases returns the page of releases of the image selected by query,
+// without their packages, and the cursor of the next page, empty on the last
+// one.
+func (im *Image) ListReleases(db *gorm.DB, query ReleaseQuery) ([]Release, string, error) {
+	if query.Sort == "" {
+		query.Sort = "-date"
+	}
+
+	tx := db.Model(&Release{}).Where("image_id = ?", im.ID)
+	if !query.Since.IsZero() {
+		tx = tx.Where("date >= ?", query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		tx = tx.Where("date < ?", query.Until.UTC())
+	}
+
+	return listPage(tx, query.Sort, query.Limit, query.Cursor, map[string]sortColumn[Release]{
+		"date": {
+			name:   "date",
+			cursor: func(re *Release) cursor { return cursor{Key: re.Date.UTC().Format(time.RFC3339Nano), ID: re.ID} },
+			value: func(c cursor) (any, error) {
+				return time.Parse(time.RFC3339Nano, c.Key)
+			},
+		},
+	})
+}
+
+// cursor points at the last row of a page, the next page starting right
+// after it. It is handed to clients encoded by encode, as an opaque string.
+type cursor struct {
+	Key string `json:"k,omitempty"` // value of the sort column
+	ID  uint   `json:"id"`
+}
+
+func (c cursor) encode() string {
+	content, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+func decodeCursor(encoded string) (cursor, error) {
+	var c cursor
+	content, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(content, &c)
+	}
+	if err != nil {
+		return c, invalidQuery("invalid cursor %s", encoded)
+	}
+
+	return c, nil
+}
+
+// sortColumn is a column a listing of T can be sorted by. Rows are also
+// sorted by ID, which breaks ties and makes cursors unambiguous.
+type sortColumn[T any] struct {
+	name   string
+	cursor func(*T) cursor
+	// value returns the value of the column at a cursor. It is nil when the
+	// column is the ID itself.
+	value func(cursor) (any, error)
+}
+
+// listPage returns the page of tx following encodedCursor in the given sort
+// order, one of columns optionally prefixed by "-" for descending order, and
+// the cursor of the next page.
+func listPage[T any](tx *gorm.DB, sort string, limit int, encodedCursor string, columns map[string]sortColumn[T]) ([]T, string, error) {
+	column, ok := columns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return nil, "", invalidQuery("unsupported sort order %s", sort)
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	} else if limit > MaxPageSize {
+		return nil, "", invalidQuery("limit must not exceed %d", MaxPageSize)
+	}
+
+	op, direction := ">", "ASC"
+	if strings.HasPrefix(sort, "-") {
+		op, direction = "<", "DESC"
+	}
+
+	if encodedCursor != "" {
+		after, err := decodeCursor(encodedCursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if column.value == nil {
+			tx = tx.Where("id "+op+" ?", after.ID)
+		} else {
+			value, err := column.value(after)
+			if err != nil {
+				return nil, "", invalidQuery("invalid cursor %s", encodedCursor)
+			}
+			tx = tx.Where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column.name, op), value, value, after.ID)
+		}
+	}
+
+	if column.value != nil {
+		tx = tx.Order(column.name + " " + direction)
+	}
+	rows := []T{}
+	if err := tx.Order("id " + direction).Limit(limit + 1).Find(&rows).Error; err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(rows) > limit {
+		rows = rows[:limit]
+		next = column.cursor(&rows[limit-1]).encode()
+	}
+
+	return rows, next, nil
+}
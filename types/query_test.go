@@ -0,0 +1,139 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListImages(t *testing.T) {
+	db := openTestDB(t)
+	for _, name := range []string{"vanilla-desktop", "pico", "vanilla-core", "vanilla_x", "nvidia"} {
+		if err := NewImage(db, &Image{Name: name, URL: "https://example.com/" + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		query    ImageQuery
+		expected []string
+	}{
+		{ImageQuery{}, []string{"nvidia", "pico", "vanilla-core", "vanilla-desktop", "vanilla_x"}},
+		{ImageQuery{Sort: "-name"}, []string{"vanilla_x", "vanilla-desktop", "vanilla-core", "pico", "nvidia"}},
+		{ImageQuery{Sort: "created"}, []string{"vanilla-desktop", "pico", "vanilla-core", "vanilla_x", "nvidia"}},
+		{ImageQuery{Sort: "-created"}, []string{"nvidia", "vanilla_x", "vanilla-core", "pico", "vanilla-desktop"}},
+		{ImageQuery{Prefix: "vanilla-"}, []string{"vanilla-core", "vanilla-desktop"}},
+		{ImageQuery{Prefix: "Vanilla"}, []string{}},
+	}
+
+	for _, c := range cases {
+		// Walk every page, two images at a time
+		names := []string{}
+		query := c.query
+		query.Limit = 2
+		for {
+			images, next, err := ListImages(db, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, image := range images {
+				names = append(names, image.Name)
+			}
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%+v: expected %v, got %v", c.query, c.expected, names)
+		}
+	}
+
+	for _, query := range []ImageQuery{{Sort: "url"}, {Cursor: "bogus"}, {Limit: MaxPageSize + 1}} {
+		if _, _, err := ListImages(db, query); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%+v: expected an invalid query error, got %v", query, err)
+		}
+	}
+}
+
+func TestListReleases(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+
+	// Releases share dates two by two, so that pages break ties by ID
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 6 {
+		release := &Release{
+			Digest:   fmt.Sprintf("sha256:%d", i),
+			ImageID:  image.ID,
+			Date:     start.AddDate(0, 0, i/2).In(time.FixedZone("UTC+2", 2*60*60)),
+			Packages: makePackages(1, 0, 0),
+		}
+		if _, err := image.NewRelease(db, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		query    ReleaseQuery
+		expected []string
+	}{
+		{ReleaseQuery{}, []string{"sha256:5", "sha256:4", "sha256:3", "sha256:2", "sha256:1", "sha256:0"}},
+		{ReleaseQuery{Sort: "date"}, []string{"sha256:0", "sha256:1", "sha256:2", "sha256:3", "sha256:4", "sha256:5"}},
+		{ReleaseQuery{Since: start.AddDate(0, 0, 1)}, []string{"sha256:5", "sha256:4", "sha256:3", "sha256:2"}},
+		{ReleaseQuery{Since: start.AddDate(0, 0, 1), Until: start.AddDate(0, 0, 2), Sort: "date"}, []string{"sha256:2", "sha256:3"}},
+	}
+
+	for _, c := range cases {
+		digests := []string{}
+		query := c.query
+		query.Limit = 3
+		for {
+			releases, next, err := image.ListReleases(db, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, release := range releases {
+				if len(release.Packages) != 0 {
+					t.Fatal("listed releases must not carry their packages")
+				}
+				digests = append(digests, release.Digest)
+			}
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+
+		if !reflect.DeepEqual(digests, c.expected) {
+			t.Errorf("%+v: expected %v, got %v", c.query, c.expected, digests)
+		}
+	}
+
+	latest, err := image.GetLatestRelease(db)
+	if err != nil || latest.Digest != "sha256:5" {
+		t.Fatalf("expected sha256:5 to be the latest release, got %v (%v)", latest, err)
+	}
+	yanked := true
+	if err := latest.Update(db, ReleaseUpdate{Yanked: &yanked}); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err = image.GetLatestRelease(db); err != nil || latest.Digest != "sha256:4" {
+		t.Fatalf("expected yanked release to be skipped, got %v (%v)", latest, err)
+	}
+
+	recent, err := image.GetRecentReleases(db, 2)
+	if err != nil || len(recent) != 2 || recent[0].Digest != "sha256:4" || recent[1].Digest != "sha256:3" || len(recent[1].Packages) == 0 {
+		t.Fatalf("unexpected recent releases %+v (%v)", recent, err)
+	}
+}
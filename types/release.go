@@ -8,27 +8,399 @@ package types
  */
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/vanilla-os/differ/diff"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// packageBatchSize is how many rows are written or queried per statement when
+// storing packages, keeping well below the bound variables limit of SQLite.
+const packageBatchSize = 1000
+
+// Package is a single package version shipped by one or more releases.
+// Packages are content-addressed by Hash, so the same (name, version, arch)
+// triple is only ever stored once and shared between releases.
+//
+// Depends, Recommends and Provides are the relationships of the package in
+// a release, stored along with its association to the release. They hold
+// clauses such as "libc6 (>= 2.34)" or "default-mta | mail-transport-agent".
+//
+// InstalledSize is the disk space taken by the package once installed, in
+// bytes, zero standing for an unknown size.
 type Package struct {
-	gorm.Model `json:"-"`
-	Name       string `json:"name"`
-	Version    string `json:"version"`
+	gorm.Model    `json:"-"`
+	Name          string   `json:"name" gorm:"uniqueIndex:idx_packages_identity"`
+	Version       string   `json:"version" gorm:"uniqueIndex:idx_packages_identity"`
+	Arch          string   `json:"arch,omitempty" gorm:"uniqueIndex:idx_packages_identity;not null;default:''"`
+	License       string   `json:"license,omitempty" gorm:"not null;default:''"` // SPDX license expression, such as GPL-2.0-or-later
+	InstalledSize int64    `json:"installed_size,omitempty" gorm:"not null;default:0"`
+	Depends       []string `json:"depends,omitempty" gorm:"->;-:migration;serializer:json"`
+	Recommends    []string `json:"recommends,omitempty" gorm:"->;-:migration;serializer:json"`
+	Provides      []string `json:"provides,omitempty" gorm:"->;-:migration;serializer:json"`
+	Hash          string   `json:"-" gorm:"uniqueIndex;size:64"`
+}
+
+// PackageHash returns the content address of a package.
+func PackageHash(name, version, arch string) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + version + "\x00" + arch))
+	return hex.EncodeToString(sum[:])
+}
+
+// releasePackage is a row of the join table between releases and packages.
+// Its primary key, created by gorm, starts with the release, so packages are
+// indexed separately for finding the releases shipping them.
+type releasePackage struct {
+	ReleaseID  uint
+	PackageID  uint     `gorm:"index:idx_release_packages_package"`
+	Depends    []string `gorm:"serializer:json"`
+	Recommends []string `gorm:"serializer:json"`
+	Provides   []string `gorm:"serializer:json"`
+}
+
+func (releasePackage) TableName() string {
+	return "release_packages"
+}
+
+// storePackages upserts pkgs in batches and fills in their IDs. Packages that
+// are already stored are left untouched, except for their license and
+// installed size when they were unknown, and duplicated entries in pkgs are
+// removed. Relationships are kept for linkPackages.
+func storePackages(db *gorm.DB, pkgs *[]Package) error {
+	unique := make([]Package, 0, len(*pkgs))
+	seen := make(map[string]bool, len(*pkgs))
+	for _, pkg := range *pkgs {
+		pkg.Hash = PackageHash(pkg.Name, pkg.Version, pkg.Arch)
+		if seen[pkg.Hash] {
+			continue
+		}
+		seen[pkg.Hash] = true
+		unique = append(unique, Package{
+			Name:          pkg.Name,
+			Version:       pkg.Version,
+			Arch:          pkg.Arch,
+			License:       pkg.License,
+			InstalledSize: pkg.InstalledSize,
+			Depends:       pkg.Depends,
+			Recommends:    pkg.Recommends,
+			Provides:      pkg.Provides,
+			Hash:          pkg.Hash,
+		})
+	}
+
+	// Inserted copies are used since IDs reported back by the driver can't be
+	// trusted for rows skipped by the conflict clause.
+	rows := make([]Package, len(unique))
+	copy(rows, unique)
+	if len(rows) > 0 {
+		err := db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "hash"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "license"}, Value: clause.Expr{SQL: "CASE WHEN packages.license = '' THEN excluded.license ELSE packages.license END"}},
+				{Column: clause.Column{Name: "installed_size"}, Value: clause.Expr{SQL: "CASE WHEN packages.installed_size = 0 THEN excluded.installed_size ELSE packages.installed_size END"}},
+			},
+			Where: clause.Where{Exprs: []clause.Expression{clause.Expr{
+				SQL: "(packages.license = '' AND excluded.license <> '') OR (packages.installed_size = 0 AND excluded.installed_size <> 0)",
+			}}},
+		}).CreateInBatches(&rows, packageBatchSize).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	ids := make(map[string]uint, len(unique))
+	for start := 0; start < len(unique); start += packageBatchSize {
+		end := min(start+packageBatchSize, len(unique))
+		hashes := make([]string, 0, end-start)
+		for _, pkg := range unique[start:end] {
+			hashes = append(hashes, pkg.Hash)
+		}
+
+		var stored []Package
+		if err := db.Select("id", "hash").Where("hash IN ?", hashes).Find(&stored).Error; err != nil {
+			return err
+		}
+		for _, pkg := range stored {
+			ids[pkg.Hash] = pkg.ID
+		}
+	}
+
+	for i := range unique {
+		unique[i].ID = ids[unique[i].Hash]
+	}
+	*pkgs = unique
+
+	return nil
+}
+
+// linkPackages associates already stored packages with a release, along with
+// their relationships in it.
+func linkPackages(db *gorm.DB, releaseID uint, pkgs []Package) error {
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	rows := make([]releasePackage, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		rows = append(rows, releasePackage{
+			ReleaseID:  releaseID,
+			PackageID:  pkg.ID,
+			Depends:    pkg.Depends,
+			Recommends: pkg.Recommends,
+			Provides:   pkg.Provides,
+		})
+	}
+
+	return db.CreateInBatches(&rows, packageBatchSize).Error
+}
+
+// loadPackages fetches the packages of the release with a single join, which
+// is considerably faster than preloading for large releases. Timestamps are
+// not exposed by the API and are skipped to save on parsing.
+func (re *Release) loadPackages(db *gorm.DB) error {
+	err := db.Select("packages.id", "packages.name", "packages.version", "packages.arch", "packages.license", "packages.installed_size", "packages.hash",
+		"release_packages.depends", "release_packages.recommends", "release_packages.provides").
+		Joins("JOIN release_packages ON release_packages.package_id = packages.id").
+		Where("release_packages.release_id = ?", re.ID).
+		Find(&re.Packages).Error
+	re.InstalledSize = TotalInstalledSize(re.Packages)
+
+	return err
+}
+
+// MigrateReleasePackages creates the indexes and relationship columns of the
+// join table between releases and packages, which is created by gorm from
+// Release.Packages without them.
+func MigrateReleasePackages(db *gorm.DB) error {
+	migrator := db.Migrator()
+	for _, column := range []string{"Depends", "Recommends", "Provides"} {
+		if !migrator.HasColumn(&releasePackage{}, column) {
+			if err := migrator.AddColumn(&releasePackage{}, column); err != nil {
+				return err
+			}
+		}
+	}
+	if migrator.HasIndex(&releasePackage{}, "idx_release_packages_package") {
+		return nil
+	}
+
+	return migrator.CreateIndex(&releasePackage{}, "idx_release_packages_package")
+}
+
+// BackfillPackageHashes computes the content address of packages stored
+// before packages were content-addressed.
+func BackfillPackageHashes(db *gorm.DB) error {
+	var batch []Package
+	return db.Where("hash IS NULL OR hash = ''").FindInBatches(&batch, packageBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, pkg := range batch {
+			err := tx.Model(&Package{}).Where("id = ?", pkg.ID).
+				Update("hash", PackageHash(pkg.Name, pkg.Version, pkg.Arch)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// DeduplicatePackages merges package rows sharing the same name, version and
+// architecture into the oldest of them, pointing every release at the
+// surviving row. Databases created before packages were shared must be
+// deduplicated before the unique identity index can be created.
+func DeduplicatePackages(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`CREATE TEMPORARY TABLE package_canon AS
+				SELECT p.id AS id, c.canon AS canon FROM packages p
+				JOIN (SELECT name, version, arch, MIN(id) AS canon FROM packages GROUP BY name, version, arch) c
+				ON p.name = c.name AND p.version = c.version AND p.arch = c.arch
+				WHERE p.id <> c.canon`,
+			`INSERT INTO release_packages (release_id, package_id)
+				SELECT DISTINCT rp.release_id, pc.canon FROM release_packages rp
+				JOIN package_canon pc ON rp.package_id = pc.id
+				WHERE NOT EXISTS (SELECT 1 FROM release_packages x WHERE x.release_id = rp.release_id AND x.package_id = pc.canon)`,
+			`DELETE FROM release_packages WHERE package_id IN (SELECT id FROM package_canon)`,
+			`DELETE FROM packages WHERE id IN (SELECT id FROM package_canon)`,
+			`DROP TABLE package_canon`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DeleteOrphanPackages permanently removes packages that no longer belong to
+// any release, returning how many were deleted.
+func DeleteOrphanPackages(db *gorm.DB) (int64, error) {
+	status := db.Unscoped().
+		Where("id NOT IN (?)", db.Model(&releasePackage{}).Select("package_id")).
+		Delete(&Package{})
+	return status.RowsAffected, status.Error
+}
+
+// ReleaseMetadata describes how a release was built. Every field is optional,
+// and Version, when set, is unique among the releases of an image.
+type ReleaseMetadata struct {
+	Version      string `json:"version,omitempty" gorm:"uniqueIndex:idx_releases_unique_version,where:version <> '' AND deleted_at IS NULL"` // human version label, such as 2.1.3
+	BuildID      string `json:"build_id,omitempty"`
+	GitCommit    string `json:"git_commit,omitempty"` // commit of the recipe the release was built from
+	Kernel       string `json:"kernel,omitempty"`
+	BaseSnapshot string `json:"base_snapshot,omitempty"` // snapshot of the base distribution
+	Notes        string `json:"notes,omitempty"`         // release notes in Markdown
 }
 
 type Release struct {
 	gorm.Model `json:"-"`
 	Digest     string    `json:"digest" gorm:"unique"`
-	ImageID    uint      `json:"-"` // foreign key for Image
-	Date       time.Time `json:"date"`
-	Packages   []Package `json:"packages,omitempty" gorm:"many2many:release_packages;"`
+	ImageID    uint      `json:"-" gorm:"index:idx_releases_image_date;uniqueIndex:idx_releases_unique_version"` // foreign key for Image
+	Date       time.Time `json:"date" gorm:"index:idx_releases_image_date"`
+	Yanked     bool      `json:"yanked"`
+	ReleaseMetadata
+	Packages []Package `json:"packages,omitempty" gorm:"many2many:release_packages;"`
+	// InstalledSize is the total installed size of the packages, in bytes,
+	// set along with them.
+	InstalledSize int64 `json:"installed_size,omitempty" gorm:"-"`
+}
+
+// ReleaseUpdate holds the editable fields of a release. Nil fields are left
+// untouched, and empty metadata strings clear the field.
+type ReleaseUpdate struct {
+	Date         *time.Time `json:"date"`
+	Yanked       *bool      `json:"yanked"`
+	Version      *string    `json:"version"`
+	BuildID      *string    `json:"build_id"`
+	GitCommit    *string    `json:"git_commit"`
+	Kernel       *string    `json:"kernel"`
+	BaseSnapshot *string    `json:"base_snapshot"`
+	Notes        *string    `json:"notes"`
+	Packages     *[]Package `json:"packages"`
+}
+
+// checkVersion fails with ErrConflict if another release of the image than
+// the one with the given ID already has version. Versions are also unique in
+// the database, see versionConflict, this check only giving a friendlier
+// error.
+func checkVersion(db *gorm.DB, imageID, releaseID uint, version string) error {
+	if version == "" {
+		return nil
+	}
+
+	var count int64
+	err := db.Model(&Release{}).Where("image_id = ? AND version = ? AND id <> ?", imageID, version, releaseID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return conflict("another release already has version %s", version)
+	}
+
+	return nil
+}
+
+// versionConflict returns the error of checkVersion for err, the failure to
+// write a release of the image, when it broke the unique version index after
+// a concurrent write passed checkVersion. Other errors are returned as is.
+func versionConflict(db *gorm.DB, err error, imageID, releaseID uint, version string) error {
+	if !errors.Is(err, gorm.ErrDuplicatedKey) || version == "" {
+		return err
+	}
+	if versionErr := checkVersion(db, imageID, releaseID, version); versionErr != nil {
+		return versionErr
+	}
+
+	return err
+}
+
+// Update applies the non-nil fields of update to the release. Replacing the
+// package list discards the previous associations.
+func (re *Release) Update(db *gorm.DB, update ReleaseUpdate) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]any{}
+		if update.Date != nil {
+			updates["date"] = update.Date.UTC()
+		}
+		if update.Yanked != nil {
+			updates["yanked"] = *update.Yanked
+		}
+		if update.Version != nil {
+			if err := checkVersion(tx, re.ImageID, re.ID, *update.Version); err != nil {
+				return err
+			}
+		}
+		for column, value := range map[string]*string{
+			"version":       update.Version,
+			"build_id":      update.BuildID,
+			"git_commit":    update.GitCommit,
+			"kernel":        update.Kernel,
+			"base_snapshot": update.BaseSnapshot,
+			"notes":         update.Notes,
+		} {
+			if value != nil {
+				updates[column] = *value
+			}
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(re).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		if update.Packages != nil {
+			if err := storePackages(tx, update.Packages); err != nil {
+				return err
+			}
+			if err := tx.Where("release_id = ?", re.ID).Delete(&releasePackage{}).Error; err != nil {
+				return err
+			}
+			if err := linkPackages(tx, re.ID, *update.Packages); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.First(re, re.ID).Error; err != nil {
+			return err
+		}
+		re.Packages = nil
+		return re.loadPackages(tx)
+	})
+	if err != nil && update.Version != nil {
+		return versionConflict(db, err, re.ImageID, re.ID, *update.Version)
+	}
+
+	return err
+}
+
+// delete permanently removes the release and its package and file manifest
+// associations, as well as the tags pointing to it.
+func (re *Release) delete(db *gorm.DB) error {
+	if err := db.Where("release_id = ?", re.ID).Delete(&releasePackage{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("release_id = ?", re.ID).Delete(&ReleaseManifest{}).Error; err != nil {
+		return err
+	}
+
+	var tags []Tag
+	if err := db.Where("release_id = ?", re.ID).Find(&tags).Error; err != nil {
+		return err
+	}
+	if err := deleteTags(db, tags, time.Now()); err != nil {
+		return err
+	}
+
+	return db.Unscoped().Delete(re).Error
 }
 
-func (re *Release) DiffPackages(other *Release) ([]diff.PackageDiff, []diff.PackageDiff, []diff.PackageDiff, []diff.PackageDiff) {
+// DiffPackages diffs the packages of re against other, comparing versions with compare.
+func (re *Release) DiffPackages(other *Release, compare diff.Comparator) ([]diff.PackageDiff, []diff.PackageDiff, []diff.PackageDiff, []diff.PackageDiff) {
 	thisPackagesMap := make(diff.Package, len(re.Packages))
 	for _, pkg := range re.Packages {
 		thisPackagesMap[pkg.Name] = pkg.Version
@@ -39,5 +411,5 @@ func (re *Release) DiffPackages(other *Release) ([]diff.PackageDiff, []diff.Pack
 		otherPackagesMap[pkg.Name] = pkg.Version
 	}
 
-	return diff.DiffPackages(thisPackagesMap, otherPackagesMap)
+	return diff.DiffPackagesWith(compare, thisPackagesMap, otherPackagesMap)
 }
@@ -0,0 +1,210 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// benchPackageCount is the size of the releases used by benchmarks, roughly
+// that of a desktop image.
+const benchPackageCount = 5000
+
+// openTestDB returns an empty, migrated database: a SQLite file, or the
+// PostgreSQL database given by DIFFER_TEST_POSTGRES_DSN, which is wiped.
+func openTestDB(tb testing.TB) *gorm.DB {
+	dialector := sqlite.Open(filepath.Join(tb.TempDir(), "test.db"))
+	dsn, onPostgres := os.LookupEnv("DIFFER_TEST_POSTGRES_DSN")
+	if onPostgres {
+		dialector = postgres.Open(dsn)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{
+		TranslateError: true,
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if onPostgres {
+		if sqlDB, err := db.DB(); err == nil {
+			tb.Cleanup(func() { sqlDB.Close() })
+		}
+		if err := db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public").Error; err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	if err := db.AutoMigrate(&Image{}, &Release{}, &Tag{}, &TagAssignment{}, &Webhook{}, &WebhookDelivery{}, &Vulnerability{}, &AffectedRange{}, &VulnerabilityImport{}, &Manifest{}, &ReleaseManifest{}); err != nil {
+		tb.Fatal(err)
+	}
+	if err := MigrateReleasePackages(db); err != nil {
+		tb.Fatal(err)
+	}
+
+	return db
+}
+
+func newTestImage(tb testing.TB, db *gorm.DB) *Image {
+	image := &Image{Name: "bench", URL: "https://example.com/bench"}
+	if err := NewImage(db, image); err != nil {
+		tb.Fatal(err)
+	}
+
+	return image
+}
+
+// makePackages returns count packages, of which the ones below changed have
+// a version unique to generation.
+func makePackages(count, changed, generation int) []Package {
+	pkgs := make([]Package, 0, count)
+	for i := 0; i < count; i++ {
+		version := "1.0-1"
+		if i < changed {
+			version = fmt.Sprintf("1.%d-1", generation)
+		}
+		pkgs = append(pkgs, Package{Name: fmt.Sprintf("package-%d", i), Version: version, Arch: "amd64"})
+	}
+
+	return pkgs
+}
+
+func TestNewReleaseSharesPackages(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+
+	for generation, digest := range []string{"sha256:first", "sha256:second"} {
+		pkgs := append(makePackages(10, 2, generation), Package{Name: "package-0", Version: fmt.Sprintf("1.%d-1", generation), Arch: "amd64"})
+		_, err := image.NewRelease(db, &Release{Digest: digest, ImageID: image.ID, Date: time.Now(), Packages: pkgs})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var count int64
+	db.Model(&Package{}).Count(&count)
+	if count != 12 {
+		t.Fatalf("expected 12 stored packages, got %d", count)
+	}
+
+	release, err := image.GetReleaseByDigest(db, "sha256:second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(release.Packages) != 10 {
+		t.Fatalf("expected release to have 10 packages, got %d", len(release.Packages))
+	}
+}
+
+func TestReleaseMetadata(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+
+	metadata := ReleaseMetadata{Version: "2.1.3", BuildID: "42", GitCommit: "0c1d2e", Kernel: "6.8.0-1", BaseSnapshot: "20240101", Notes: "# 2.1.3"}
+	for i, digest := range []string{"sha256:first", "sha256:second"} {
+		release := &Release{Digest: digest, ImageID: image.ID, Date: time.Now().Add(time.Duration(i) * time.Hour), ReleaseMetadata: metadata}
+		if i == 1 {
+			release.Version = "2.1.4"
+		}
+		if _, err := image.NewRelease(db, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	duplicate := &Release{Digest: "sha256:third", ImageID: image.ID, Date: time.Now(), ReleaseMetadata: ReleaseMetadata{Version: "2.1.3"}}
+	if _, err := image.NewRelease(db, duplicate); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected duplicated version to conflict, got %v", err)
+	}
+
+	// Writes racing past checkVersion are caught by the unique index, while
+	// releases without version don't conflict
+	err := db.Create(&Release{Digest: "sha256:third", ImageID: image.ID, Date: time.Now(), ReleaseMetadata: ReleaseMetadata{Version: "2.1.3"}}).Error
+	if err = versionConflict(db, err, image.ID, 0, "2.1.3"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected the version index to conflict, got %v", err)
+	}
+	for _, digest := range []string{"sha256:unversioned", "sha256:unversioned-again"} {
+		if err := db.Create(&Release{Digest: digest, ImageID: image.ID, Date: time.Now()}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	release, err := image.GetReleaseByVersion(db, "2.1.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.Digest != "sha256:first" || release.ReleaseMetadata != metadata {
+		t.Fatalf("unexpected release for version 2.1.3: %+v", release)
+	}
+	if _, err := image.GetReleaseByVersion(db, "9.9.9"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected unknown version not to be found, got %v", err)
+	}
+
+	taken, cleared := "2.1.4", ""
+	if err := release.Update(db, ReleaseUpdate{Version: &taken}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected version of another release to conflict, got %v", err)
+	}
+	if err := release.Update(db, ReleaseUpdate{Version: &metadata.Version, Notes: &cleared}); err != nil {
+		t.Fatal(err)
+	}
+	if release.Version != "2.1.3" || release.Notes != "" || release.Kernel != metadata.Kernel {
+		t.Fatalf("unexpected metadata after update: %+v", release.ReleaseMetadata)
+	}
+}
+
+func BenchmarkNewRelease(b *testing.B) {
+	db := openTestDB(b)
+	image := newTestImage(b, db)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Most packages are shared with the previous release, as in practice
+		release := &Release{
+			Digest:   fmt.Sprintf("sha256:%d", i),
+			ImageID:  image.ID,
+			Date:     time.Now(),
+			Packages: makePackages(benchPackageCount, benchPackageCount/20, i),
+		}
+		if _, err := image.NewRelease(db, release); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetReleaseByDigest(b *testing.B) {
+	db := openTestDB(b)
+	image := newTestImage(b, db)
+
+	release := &Release{
+		Digest:   "sha256:bench",
+		ImageID:  image.ID,
+		Date:     time.Now(),
+		Packages: makePackages(benchPackageCount, 0, 0),
+	}
+	if _, err := image.NewRelease(db, release); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		found, err := image.GetReleaseByDigest(db, release.Digest)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(found.Packages) != benchPackageCount {
+			b.Fatalf("expected %d packages, got %d", benchPackageCount, len(found.Packages))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetentionPolicy decides which releases of an image are kept by the garbage
+// collector. A release is kept if it satisfies any of the enabled rules, and
+// an image without any enabled rule keeps all of its releases.
+type RetentionPolicy struct {
+	// KeepLast keeps the N most recent releases that are not yanked, along
+	// with the yanked releases newer than the oldest of them, which don't
+	// count toward N. Zero disables the rule.
+	KeepLast int `json:"keep_last,omitempty"`
+	// MaxAgeDays keeps releases newer than the given number of days. Zero
+	// disables the rule.
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+}
+
+// Enabled reports whether the policy has any rule set.
+func (rp RetentionPolicy) Enabled() bool {
+	return rp.KeepLast > 0 || rp.MaxAgeDays > 0
+}
+
+// keeps reports whether release, preceded by newer non-yanked releases, must
+// be retained at instant now.
+func (rp RetentionPolicy) keeps(release *Release, newer int, now time.Time) bool {
+	if rp.KeepLast > 0 && newer < rp.KeepLast {
+		return true
+	}
+	if rp.MaxAgeDays > 0 && release.Date.After(now.AddDate(0, 0, -rp.MaxAgeDays)) {
+		return true
+	}
+
+	return false
+}
+
+// protectedReleases returns the IDs of releases that must never be collected,
+// regardless of the retention policy: the latest one and tagged ones.
+func (im *Image) protectedReleases(db *gorm.DB) (map[uint]bool, error) {
+	protected := map[uint]bool{}
+	latest, err := im.GetLatestRelease(db)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil {
+		protected[latest.ID] = true
+	}
+
+	tags, err := im.ListTags(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		protected[tag.ReleaseID] = true
+	}
+
+	return protected, nil
+}
+
+// ApplyRetention deletes the releases of the image that fall outside of its
+// retention policy at instant now, returning the digests of deleted releases.
+// The latest release and tagged releases are always kept. Releases must be
+// loaded from newest to oldest, as done by GetImageByName.
+func (im *Image) ApplyRetention(db *gorm.DB, now time.Time) ([]string, error) {
+	if !im.Retention.Enabled() {
+		return nil, nil
+	}
+
+	protected, err := im.protectedReleases(db)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []string{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		newer := 0
+		for i := range im.Releases {
+			release := &im.Releases[i]
+			kept := protected[release.ID] || im.Retention.keeps(release, newer, now)
+			if !release.Yanked {
+				newer++
+			}
+			if kept {
+				continue
+			}
+
+			if err := release.delete(tx); err != nil {
+				return err
+			}
+			deleted = append(deleted, release.Digest)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,53 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionSkipsYanked(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	yanked := true
+	for i := range 5 {
+		release, err := image.NewRelease(db, &Release{Digest: fmt.Sprintf("sha256:%d", i), ImageID: image.ID, Date: start.AddDate(0, 0, i), Packages: makePackages(1, 1, i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 || i >= 3 {
+			if err := release.Update(db, ReleaseUpdate{Yanked: &yanked}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The two newest releases are yanked, so they don't count toward the
+	// releases kept but are kept with them, unlike the oldest yanked release
+	loaded, err := GetImageByName(db, image.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.Retention = RetentionPolicy{KeepLast: 2}
+	deleted, err := loaded.ApplyRetention(db, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sha256:0"}; !reflect.DeepEqual(deleted, want) {
+		t.Fatalf("expected retention to delete %v, got %v", want, deleted)
+	}
+	for _, digest := range []string{"sha256:1", "sha256:3", "sha256:4"} {
+		if _, err := image.GetReleaseByDigest(db, digest); err != nil {
+			t.Errorf("expected release %s to be kept, got %v", digest, err)
+		}
+	}
+}
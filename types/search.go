@@ -0,0 +1,273 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Ways of matching package names in a PackageQuery.
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchGlob   = "glob"
+)
+
+// PackageQuery selects a page of packages shipped by at least one release.
+type PackageQuery struct {
+	Name string `form:"name"`
+	// Match is how Name is matched: MatchExact (the default), MatchPrefix, or
+	// MatchGlob, where "*" matches any sequence of characters and "?" any
+	// single character.
+	Match   string `form:"match"`
+	Version string `form:"version"`
+	Arch    string `form:"arch"`
+	// Image restricts the search to the releases of the named image.
+	Image  string `form:"image"`
+	Limit  int    `form:"limit"`
+	Cursor string `form:"cursor"`
+}
+
+// ReleaseRef identifies a release in search results and histories.
+type ReleaseRef struct {
+	Image  string    `json:"image,omitempty"`
+	Digest string    `json:"digest"`
+	Date   time.Time `json:"date"`
+	Yanked bool      `json:"yanked"`
+}
+
+// PackageMatch is a package found by SearchPackages, alongside the releases
+// shipping it, from newest to oldest.
+type PackageMatch struct {
+	Name     string       `json:"name"`
+	Version  string       `json:"version"`
+	Arch     string       `json:"arch,omitempty"`
+	License  string       `json:"license,omitempty"`
+	Releases []ReleaseRef `json:"releases"`
+}
+
+// SearchPackages returns the page of packages selected by query, sorted by
+// name, and the cursor of the next page, empty on the last one.
+func SearchPackages(db *gorm.DB, query PackageQuery) ([]PackageMatch, string, error) {
+	if query.Name == "" {
+		return nil, "", invalidQuery("a package name is required")
+	}
+
+	tx := db.Model(&Package{})
+	switch query.Match {
+	case "", MatchExact:
+		tx = tx.Where("name = ?", query.Name)
+	case MatchPrefix:
+		tx = tx.Where(nameMatches(db), escapePattern(db, query.Name)+anyString(db))
+	case MatchGlob:
+		tx = tx.Where(nameMatches(db), globPattern(db, query.Name))
+	default:
+		return nil, "", invalidQuery("unsupported match %s", query.Match)
+	}
+	if query.Version != "" {
+		tx = tx.Where("version = ?", query.Version)
+	}
+	if query.Arch != "" {
+		tx = tx.Where("arch = ?", query.Arch)
+	}
+
+	// Only packages shipped by a release, of the requested image if any, are
+	// of interest. Orphans are left until the next garbage collection.
+	var imageID uint
+	shipped := db.Table("release_packages").Select("1").
+		Joins("JOIN releases ON releases.id = release_packages.release_id").
+		Where("release_packages.package_id = packages.id")
+	if query.Image != "" {
+		image, err := FindImage(db, query.Image)
+		if err != nil {
+			return nil, "", err
+		}
+		imageID = image.ID
+		shipped = shipped.Where("releases.image_id = ?", imageID)
+	}
+	tx = tx.Where("EXISTS (?)", shipped)
+
+	pkgs, next, err := listPage(tx, "name", query.Limit, query.Cursor, map[string]sortColumn[Package]{
+		"name": {
+			name:   "name",
+			cursor: func(pkg *Package) cursor { return cursor{Key: pkg.Name, ID: pkg.ID} },
+			value:  func(c cursor) (any, error) { return c.Key, nil },
+		},
+	})
+	if err != nil || len(pkgs) == 0 {
+		return []PackageMatch{}, next, err
+	}
+
+	ids := make([]uint, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		ids = append(ids, pkg.ID)
+	}
+	var rows []struct {
+		PackageID uint
+		ReleaseRef
+	}
+	releases := db.Table("release_packages").
+		Select("release_packages.package_id, images.name AS image, releases.digest, releases.date, releases.yanked").
+		Joins("JOIN releases ON releases.id = release_packages.release_id").
+		Joins("JOIN images ON images.id = releases.image_id").
+		Where("release_packages.package_id IN ?", ids)
+	if imageID != 0 {
+		releases = releases.Where("releases.image_id = ?", imageID)
+	}
+	if err := releases.Order("releases.date DESC, releases.id DESC").Scan(&rows).Error; err != nil {
+		return nil, "", err
+	}
+
+	shippedBy := make(map[uint][]ReleaseRef, len(pkgs))
+	for _, row := range rows {
+		shippedBy[row.PackageID] = append(shippedBy[row.PackageID], row.ReleaseRef)
+	}
+	matches := make([]PackageMatch, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		matches = append(matches, PackageMatch{
+			Name:     pkg.Name,
+			Version:  pkg.Version,
+			Arch:     pkg.Arch,
+			License:  pkg.License,
+			Releases: shippedBy[pkg.ID],
+		})
+	}
+
+	return matches, next, nil
+}
+
+// VersionSpan is a run of consecutive releases of an image shipping the same
+// version of a package.
+type VersionSpan struct {
+	Version      string     `json:"version"`
+	Arch         string     `json:"arch,omitempty"`
+	FirstRelease ReleaseRef `json:"first_release"`
+	LastRelease  ReleaseRef `json:"last_release"`
+	ReleaseCount int        `json:"release_count"`
+}
+
+// PackageHistory returns the versions of the named package shipped by the
+// non-yanked releases of the image, from oldest to newest. A new span starts
+// whenever the version changes, and releases not shipping the package at all
+// leave a gap between spans. Each architecture has its own spans, restricted
+// to arch if not empty.
+func (im *Image) PackageHistory(db *gorm.DB, name, arch string) ([]VersionSpan, error) {
+	var releases []Release
+	err := db.Select("id", "digest", "date", "yanked").
+		Where("image_id = ? AND yanked = ?", im.ID, false).
+		Order("date ASC, id ASC").Find(&releases).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions of the package by architecture, then by release
+	var rows []struct {
+		ReleaseID uint
+		Version   string
+		Arch      string
+	}
+	shipped := db.Table("release_packages").
+		Select("release_packages.release_id, packages.version, packages.arch").
+		Joins("JOIN packages ON packages.id = release_packages.package_id").
+		Joins("JOIN releases ON releases.id = release_packages.release_id").
+		Where("releases.image_id = ? AND releases.yanked = ? AND packages.name = ?", im.ID, false, name)
+	if arch != "" {
+		shipped = shipped.Where("packages.arch = ?", arch)
+	}
+	if err := shipped.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, notFound("package %s was never shipped by %s", name, im.Name)
+	}
+
+	versions := map[string]map[uint]string{}
+	arches := []string{}
+	for _, row := range rows {
+		if versions[row.Arch] == nil {
+			versions[row.Arch] = map[uint]string{}
+			arches = append(arches, row.Arch)
+		}
+		versions[row.Arch][row.ReleaseID] = row.Version
+	}
+	slices.Sort(arches)
+
+	spans := []VersionSpan{}
+	for _, arch := range arches {
+		var current *VersionSpan
+		for _, release := range releases {
+			version, ok := versions[arch][release.ID]
+			if !ok {
+				current = nil
+				continue
+			}
+
+			ref := ReleaseRef{Digest: release.Digest, Date: release.Date}
+			if current != nil && current.Version == version {
+				current.LastRelease = ref
+				current.ReleaseCount++
+				continue
+			}
+			spans = append(spans, VersionSpan{Version: version, Arch: arch, FirstRelease: ref, LastRelease: ref, ReleaseCount: 1})
+			current = &spans[len(spans)-1]
+		}
+	}
+
+	return spans, nil
+}
+
+// nameMatches returns the condition matching names against a pattern built
+// by escapePattern, globPattern and anyString. SQLite's LIKE ignores case, so
+// GLOB is used there instead.
+func nameMatches(db *gorm.DB) string {
+	if db.Dialector.Name() == "sqlite" {
+		return "name GLOB ?"
+	}
+	return `name LIKE ? ESCAPE '\'`
+}
+
+// escapePattern escapes the wildcards of the pattern syntax of the database
+// from s.
+func escapePattern(db *gorm.DB, s string) string {
+	if db.Dialector.Name() == "sqlite" {
+		return strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]").Replace(s)
+	}
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// anyString returns the wildcard matching any sequence of characters.
+func anyString(db *gorm.DB) string {
+	if db.Dialector.Name() == "sqlite" {
+		return "*"
+	}
+	return "%"
+}
+
+// globPattern translates glob, where only "*" and "?" are wildcards, to the
+// pattern syntax of the database.
+func globPattern(db *gorm.DB, glob string) string {
+	var pattern strings.Builder
+	for _, r := range glob {
+		switch {
+		case r == '*':
+			pattern.WriteString(anyString(db))
+		case r == '?' && db.Dialector.Name() == "sqlite":
+			pattern.WriteString("?")
+		case r == '?':
+			pattern.WriteString("_")
+		default:
+			pattern.WriteString(escapePattern(db, string(r)))
+		}
+	}
+
+	return pattern.String()
+}
@@ -0,0 +1,171 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// newSearchFixture stores a "desktop" and a "core" image, whose releases
+// ship the listed packages, one release per day from 2024-01-01.
+func newSearchFixture(t *testing.T) (*gorm.DB, *Image) {
+	db := openTestDB(t)
+	desktop := &Image{Name: "desktop", URL: "https://example.com/desktop"}
+	core := &Image{Name: "core", URL: "https://example.com/core"}
+	for _, image := range []*Image{desktop, core} {
+		if err := NewImage(db, image); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	releases := []struct {
+		image    *Image
+		packages []Package
+	}{
+		{desktop, []Package{{Name: "openssl", Version: "3.0.10"}, {Name: "mesa", Version: "23.1", Arch: "amd64"}, {Name: "mesa", Version: "23.1", Arch: "i386"}}},
+		{desktop, []Package{{Name: "openssl", Version: "3.0.11"}, {Name: "mesa", Version: "23.1", Arch: "amd64"}, {Name: "mesa", Version: "23.2", Arch: "i386"}}},
+		{desktop, []Package{{Name: "openssl", Version: "3.0.11"}, {Name: "mesa", Version: "23.2", Arch: "amd64"}}},
+		{desktop, []Package{{Name: "openssl", Version: "3.0.11"}}},
+		{desktop, []Package{{Name: "openssl", Version: "3.0.11"}, {Name: "mesa", Version: "23.2", Arch: "amd64"}}},
+		{core, []Package{{Name: "openssl", Version: "3.0.11"}, {Name: "openssl-dev", Version: "3.0.11"}, {Name: "lib_x", Version: "1"}, {Name: "libax", Version: "1"}}},
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, release := range releases {
+		_, err := release.image.NewRelease(db, &Release{
+			Digest:   fmt.Sprintf("sha256:%d", i),
+			ImageID:  release.image.ID,
+			Date:     start.AddDate(0, 0, i),
+			Packages: release.packages,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, desktop
+}
+
+// searchAll walks every page of the results of query, describing each match
+// as "name version@image/digest,...".
+func searchAll(t *testing.T, db *gorm.DB, query PackageQuery) []string {
+	t.Helper()
+	results := []string{}
+	for {
+		matches, next, err := SearchPackages(db, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range matches {
+			releases := []string{}
+			for _, release := range match.Releases {
+				releases = append(releases, release.Image+"/"+release.Digest)
+			}
+			results = append(results, match.Name+" "+match.Version+"@"+strings.Join(releases, ","))
+		}
+		if next == "" {
+			return results
+		}
+		query.Cursor = next
+	}
+}
+
+func TestSearchPackages(t *testing.T) {
+	db, _ := newSearchFixture(t)
+
+	cases := []struct {
+		query    PackageQuery
+		expected []string
+	}{
+		{PackageQuery{Name: "openssl", Version: "3.0.11"}, []string{"openssl 3.0.11@core/sha256:5,desktop/sha256:4,desktop/sha256:3,desktop/sha256:2,desktop/sha256:1"}},
+		{PackageQuery{Name: "openssl", Version: "3.0.11", Image: "core"}, []string{"openssl 3.0.11@core/sha256:5"}},
+		{PackageQuery{Name: "openssl", Match: MatchPrefix, Image: "core"}, []string{"openssl 3.0.11@core/sha256:5", "openssl-dev 3.0.11@core/sha256:5"}},
+		{PackageQuery{Name: "OpenSSL"}, []string{}},
+		{PackageQuery{Name: "lib_", Match: MatchPrefix}, []string{"lib_x 1@core/sha256:5"}},
+		{PackageQuery{Name: "lib?x", Match: MatchGlob}, []string{"lib_x 1@core/sha256:5", "libax 1@core/sha256:5"}},
+		{PackageQuery{Name: "*ssl*", Match: MatchGlob, Version: "3.0.10"}, []string{"openssl 3.0.10@desktop/sha256:0"}},
+		{PackageQuery{Name: "mesa", Arch: "i386", Limit: 1}, []string{"mesa 23.1@desktop/sha256:0", "mesa 23.2@desktop/sha256:1"}},
+	}
+	for _, c := range cases {
+		if results := searchAll(t, db, c.query); !reflect.DeepEqual(results, c.expected) {
+			t.Errorf("%+v: expected %v, got %v", c.query, c.expected, results)
+		}
+	}
+
+	for _, query := range []PackageQuery{{}, {Name: "x", Match: "regexp"}} {
+		if _, _, err := SearchPackages(db, query); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%+v: expected an invalid query error, got %v", query, err)
+		}
+	}
+	if _, _, err := SearchPackages(db, PackageQuery{Name: "openssl", Image: "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestPackageHistory(t *testing.T) {
+	db, desktop := newSearchFixture(t)
+
+	describe := func(spans []VersionSpan) []string {
+		described := []string{}
+		for _, span := range spans {
+			described = append(described, fmt.Sprintf("%s/%s %s..%s (%d)", span.Version, span.Arch, span.FirstRelease.Digest, span.LastRelease.Digest, span.ReleaseCount))
+		}
+		return described
+	}
+
+	spans, err := desktop.PackageHistory(db, "mesa", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"23.1/amd64 sha256:0..sha256:1 (2)",
+		"23.2/amd64 sha256:2..sha256:2 (1)",
+		"23.2/amd64 sha256:4..sha256:4 (1)",
+		"23.1/i386 sha256:0..sha256:0 (1)",
+		"23.2/i386 sha256:1..sha256:1 (1)",
+	}
+	if !reflect.DeepEqual(describe(spans), expected) {
+		t.Errorf("expected %v, got %v", expected, describe(spans))
+	}
+
+	// Yanked releases are not part of the history
+	yanked := true
+	release, _ := desktop.GetReleaseByDigest(db, "sha256:3")
+	if err := release.Update(db, ReleaseUpdate{Yanked: &yanked}); err != nil {
+		t.Fatal(err)
+	}
+	spans, err = desktop.PackageHistory(db, "mesa", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"23.1/amd64 sha256:0..sha256:1 (2)", "23.2/amd64 sha256:2..sha256:4 (2)"}
+	if !reflect.DeepEqual(describe(spans), expected) {
+		t.Errorf("expected %v, got %v", expected, describe(spans))
+	}
+
+	if _, err := desktop.PackageHistory(db, "lib_x", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	// Packages only shipped by yanked releases are not found either
+	for _, digest := range []string{"sha256:0", "sha256:1"} {
+		release, _ := desktop.GetReleaseByDigest(db, digest)
+		if err := release.Update(db, ReleaseUpdate{Yanked: &yanked}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := desktop.PackageHistory(db, "mesa", "i386"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
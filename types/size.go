@@ -0,0 +1,101 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"cmp"
+	"slices"
+)
+
+// TotalInstalledSize returns the sum of the installed sizes of pkgs, leaving
+// out the packages whose size is unknown.
+func TotalInstalledSize(pkgs []Package) int64 {
+	var total int64
+	for _, pkg := range pkgs {
+		total += pkg.InstalledSize
+	}
+
+	return total
+}
+
+// SizeChange is a package whose installed size differs between two releases.
+// The version and size are empty on the side of the release not shipping the
+// package, for added and removed packages.
+type SizeChange struct {
+	Name            string `json:"name"`
+	PreviousVersion string `json:"previous_version,omitempty"`
+	NewVersion      string `json:"new_version,omitempty"`
+	PreviousSize    int64  `json:"previous_size"`
+	NewSize         int64  `json:"new_size"`
+	Delta           int64  `json:"delta"`
+}
+
+// SizeDiff is the difference in installed size between two releases.
+type SizeDiff struct {
+	PreviousTotal int64        `json:"previous_total"`
+	NewTotal      int64        `json:"new_total"`
+	Delta         int64        `json:"delta"`
+	Packages      []SizeChange `json:"packages"`
+}
+
+// DiffSizes returns the installed size of re and other, and the packages
+// whose size changed, sorted by name. Packages are matched by name, as in
+// DiffPackages, and those shipped by both releases are skipped when their
+// size is unknown in either, since an unknown size doesn't tell how much it
+// changed.
+func (re *Release) DiffSizes(other *Release) SizeDiff {
+	result := SizeDiff{
+		PreviousTotal: TotalInstalledSize(re.Packages),
+		NewTotal:      TotalInstalledSize(other.Packages),
+		Packages:      []SizeChange{},
+	}
+	result.Delta = result.NewTotal - result.PreviousTotal
+
+	previous := make(map[string]Package, len(re.Packages))
+	for _, pkg := range re.Packages {
+		previous[pkg.Name] = pkg
+	}
+	shipped := make(map[string]bool, len(other.Packages))
+	for _, pkg := range other.Packages {
+		shipped[pkg.Name] = true
+		old, ok := previous[pkg.Name]
+		if ok && (old.InstalledSize == 0 || pkg.InstalledSize == 0) {
+			continue
+		}
+		change := SizeChange{Name: pkg.Name, NewVersion: pkg.Version, NewSize: pkg.InstalledSize}
+		if ok {
+			change.PreviousVersion, change.PreviousSize = old.Version, old.InstalledSize
+		}
+		if change.Delta = change.NewSize - change.PreviousSize; change.Delta != 0 {
+			result.Packages = append(result.Packages, change)
+		}
+	}
+	for _, pkg := range re.Packages {
+		if !shipped[pkg.Name] && pkg.InstalledSize != 0 {
+			result.Packages = append(result.Packages, SizeChange{
+				Name:            pkg.Name,
+				PreviousVersion: pkg.Version,
+				PreviousSize:    pkg.InstalledSize,
+				Delta:           -pkg.InstalledSize,
+			})
+		}
+	}
+	slices.SortFunc(result.Packages, func(a, b SizeChange) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return result
+}
+
+// SortBySizeChange sorts changes by decreasing absolute delta, then by name,
+// so that the packages weighing the most on the difference come first.
+func SortBySizeChange(changes []SizeChange) {
+	slices.SortFunc(changes, func(a, b SizeChange) int {
+		return cmp.Or(cmp.Compare(max(b.Delta, -b.Delta), max(a.Delta, -a.Delta)), cmp.Compare(a.Name, b.Name))
+	})
+}
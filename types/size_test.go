@@ -0,0 +1,82 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSizes(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+
+	releases := []*Release{
+		{Digest: "sha256:first", Packages: []Package{
+			{Name: "bash", Version: "5.2", InstalledSize: 7000},
+			{Name: "zlib", Version: "1.3"},
+			{Name: "redis", Version: "7.2", InstalledSize: 4000},
+			{Name: "tar", Version: "1.34", InstalledSize: 3000},
+			{Name: "vim", Version: "9.0", InstalledSize: 500},
+		}},
+		// The size of zlib 1.3 was unknown, and is filled in, while the
+		// known size of bash 5.2 is kept
+		{Digest: "sha256:second", Packages: []Package{
+			{Name: "bash", Version: "5.2", InstalledSize: 9000},
+			{Name: "zlib", Version: "1.3", InstalledSize: 200},
+			{Name: "redis", Version: "7.4", InstalledSize: 3500},
+			{Name: "tar", Version: "1.35"},
+			{Name: "curl", Version: "8.5", InstalledSize: 1000},
+		}},
+	}
+	for i, release := range releases {
+		release.ImageID = image.ID
+		release.Date = time.Now().Add(time.Duration(i) * time.Hour)
+		if _, err := image.NewRelease(db, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := image.GetReleaseByDigest(db, "sha256:first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := image.GetReleaseByDigest(db, "sha256:second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.InstalledSize != 14700 || second.InstalledSize != 11700 {
+		t.Errorf("expected installed sizes of 14700 and 11700, got %d and %d", first.InstalledSize, second.InstalledSize)
+	}
+
+	// tar is skipped, its new size being unknown
+	expected := SizeDiff{
+		PreviousTotal: 14700,
+		NewTotal:      11700,
+		Delta:         -3000,
+		Packages: []SizeChange{
+			{Name: "curl", NewVersion: "8.5", NewSize: 1000, Delta: 1000},
+			{Name: "redis", PreviousVersion: "7.2", NewVersion: "7.4", PreviousSize: 4000, NewSize: 3500, Delta: -500},
+			{Name: "vim", PreviousVersion: "9.0", PreviousSize: 500, Delta: -500},
+		},
+	}
+	sizes := first.DiffSizes(second)
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, sizes)
+	}
+
+	SortBySizeChange(sizes.Packages)
+	order := []string{}
+	for _, change := range sizes.Packages {
+		order = append(order, change.Name)
+	}
+	if !reflect.DeepEqual(order, []string{"curl", "redis", "vim"}) {
+		t.Errorf("expected the biggest size change first, got %v", order)
+	}
+}
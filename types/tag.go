@@ -0,0 +1,179 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// tagNamePattern restricts tag names so that they can't be mistaken for
+// digests, which always contain the algorithm followed by a colon.
+var tagNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// Tag is a named, movable pointer to a release of an image, typically used
+// as a channel such as "stable" or "nightly". Tags can be used wherever a
+// release digest is expected.
+type Tag struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	ImageID   uint      `json:"-" gorm:"uniqueIndex:idx_tags_image_name"`
+	Name      string    `json:"name" gorm:"uniqueIndex:idx_tags_image_name"`
+	ReleaseID uint      `json:"-" gorm:"index"`
+	Digest    string    `json:"digest"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TagAssignment records a tag being moved to a release, or removed when
+// Digest is empty.
+type TagAssignment struct {
+	ID       uint      `json:"-" gorm:"primaryKey"`
+	ImageID  uint      `json:"-" gorm:"index:idx_tag_assignments_tag"`
+	Tag      string    `json:"-" gorm:"index:idx_tag_assignments_tag"`
+	Digest   string    `json:"digest,omitempty"`
+	Previous string    `json:"previous,omitempty"`
+	Date     time.Time `json:"date"`
+}
+
+// ValidateTagName checks that name can be used as a tag, which excludes
+// digests and LatestRef.
+func ValidateTagName(name string) error {
+	if name == LatestRef || !tagNamePattern.MatchString(name) {
+		return invalidQuery("invalid tag name %q: tags are made of up to 128 letters, digits, dots, dashes and underscores, and can't be %q", name, LatestRef)
+	}
+
+	return nil
+}
+
+// ListTags returns the tags of the image, sorted by name.
+func (im *Image) ListTags(db *gorm.DB) ([]Tag, error) {
+	tags := []Tag{}
+	err := db.Where("image_id = ?", im.ID).Order("name").Find(&tags).Error
+	return tags, err
+}
+
+// FindTag returns the tag of the image with the given name.
+func (im *Image) FindTag(db *gorm.DB, name string) (*Tag, error) {
+	var tag Tag
+	err := db.First(&tag, "image_id = ? AND name = ?", im.ID, name).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, notFound("image %s has no tag %s", im.Name, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+// GetTaggedRelease returns the release pointed to by a tag, without its
+// packages. A tag pointing to a yanked release doesn't resolve, yanked
+// releases being only reachable by digest.
+func (im *Image) GetTaggedRelease(db *gorm.DB, name string) (*Release, error) {
+	tag, err := im.FindTag(db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var release Release
+	if err := db.First(&release, tag.ReleaseID).Error; err != nil {
+		return nil, err
+	}
+	if release.Yanked {
+		return nil, conflict("tag %s points to yanked release %s", name, release.Digest)
+	}
+
+	return &release, nil
+}
+
+// SetTag points the named tag of the image at the release with the given
+// digest at instant now, creating the tag if needed. Every move is recorded
+// in the history of the tag.
+func (im *Image) SetTag(db *gorm.DB, name, digest string, now time.Time) (*Tag, error) {
+	if err := ValidateTagName(name); err != nil {
+		return nil, err
+	}
+
+	var release Release
+	err := db.Select("id", "digest").First(&release, "digest = ? AND image_id = ?", digest, im.ID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, notFound("no release found with digest %s", digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tag := Tag{ImageID: im.ID, Name: name}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&tag).FirstOrInit(&tag).Error; err != nil {
+			return err
+		}
+		if tag.ID != 0 && tag.ReleaseID == release.ID {
+			return nil
+		}
+
+		previous := tag.Digest
+		tag.ReleaseID = release.ID
+		tag.Digest = release.Digest
+		tag.UpdatedAt = now.UTC()
+		if err := tx.Save(&tag).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&TagAssignment{ImageID: im.ID, Tag: name, Digest: release.Digest, Previous: previous, Date: now.UTC()}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+// DeleteTag removes the named tag of the image at instant now, keeping its
+// history.
+func (im *Image) DeleteTag(db *gorm.DB, name string, now time.Time) error {
+	tag, err := im.FindTag(db, name)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		return deleteTags(tx, []Tag{*tag}, now)
+	})
+}
+
+// TagHistory returns every assignment of the named tag of the image, from
+// newest to oldest, including those of a tag since removed.
+func (im *Image) TagHistory(db *gorm.DB, name string) ([]TagAssignment, error) {
+	history := []TagAssignment{}
+	err := db.Where("image_id = ? AND tag = ?", im.ID, name).Order("date DESC, id DESC").Find(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, notFound("image %s never had a tag %s", im.Name, name)
+	}
+
+	return history, nil
+}
+
+// deleteTags removes tags, recording their removal at instant now.
+func deleteTags(db *gorm.DB, tags []Tag, now time.Time) error {
+	for _, tag := range tags {
+		if err := db.Delete(&tag).Error; err != nil {
+			return err
+		}
+		err := db.Create(&TagAssignment{ImageID: tag.ImageID, Tag: tag.Name, Previous: tag.Digest, Date: now.UTC()}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTags(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		release := &Release{Digest: fmt.Sprintf("sha256:%d", i), ImageID: image.ID, Date: start.AddDate(0, 0, i), Packages: makePackages(3, 1, i)}
+		if _, err := image.NewRelease(db, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{LatestRef, "sha256:0", "", "-stable"} {
+		if _, err := image.SetTag(db, name, "sha256:0", start); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("expected tag name %q to be rejected, got %v", name, err)
+		}
+	}
+	if _, err := image.SetTag(db, "stable", "sha256:missing", start); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected missing release to be reported, got %v", err)
+	}
+
+	moves := []struct{ tag, digest string }{{"stable", "sha256:0"}, {"testing", "sha256:1"}, {"stable", "sha256:1"}, {"stable", "sha256:1"}}
+	for i, move := range moves {
+		if _, err := image.SetTag(db, move.tag, move.digest, start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tags, err := image.ListTags(db)
+	if err != nil || len(tags) != 2 || tags[0].Name != "stable" || tags[0].Digest != "sha256:1" {
+		t.Fatalf("unexpected tags %+v (%v)", tags, err)
+	}
+
+	// Moving a tag to the release it already points to isn't recorded
+	history, err := image.TagHistory(db, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, assignment := range history {
+		got = append(got, assignment.Previous+">"+assignment.Digest)
+	}
+	if want := []string{"sha256:0>sha256:1", ">sha256:0"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected history %v, got %v", want, got)
+	}
+
+	release, err := image.ResolveRelease(db, "stable")
+	if err != nil || release.Digest != "sha256:1" || len(release.Packages) != 3 {
+		t.Fatalf("unexpected release for stable %v (%v)", release, err)
+	}
+	if _, err := image.ResolveRelease(db, "nightly"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected unknown tag not to resolve, got %v", err)
+	}
+
+	// Only the latest and tagged releases survive a retention policy keeping
+	// nothing else
+	image.Retention = RetentionPolicy{MaxAgeDays: 1}
+	loaded, err := GetImageByName(db, image.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.Retention = image.Retention
+	deleted, err := loaded.ApplyRetention(db, start.AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sha256:2", "sha256:0"}; !reflect.DeepEqual(deleted, want) {
+		t.Fatalf("expected retention to delete %v, got %v", want, deleted)
+	}
+
+	// Deleting a tagged release removes its tags, keeping their history
+	if err := image.DeleteRelease(db, "sha256:1"); err != nil {
+		t.Fatal(err)
+	}
+	if tags, _ := image.ListTags(db); len(tags) != 0 {
+		t.Fatalf("expected tags of deleted release to be removed, got %+v", tags)
+	}
+	history, err = image.TagHistory(db, "testing")
+	if err != nil || len(history) != 2 || history[0].Digest != "" || history[0].Previous != "sha256:1" {
+		t.Fatalf("unexpected history of removed tag %+v (%v)", history, err)
+	}
+	if err := image.DeleteTag(db, "testing", start); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected removed tag not to be found, got %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/vanilla-os/differ/diff"
+	"gorm.io/gorm"
+)
+
+// Vulnerability is a security advisory imported from a vulnerability
+// database, such as a CVE.
+type Vulnerability struct {
+	ID      string   `json:"id" gorm:"primaryKey"`
+	Aliases []string `json:"aliases,omitempty" gorm:"serializer:json"`
+	Summary string   `json:"summary,omitempty"`
+	// Severity is the lowercase severity or urgency assigned by the
+	// database, empty when unknown.
+	Severity string          `json:"severity,omitempty"`
+	Ranges   []AffectedRange `json:"-"`
+}
+
+// AffectedRange is a range of versions of a package affected by a
+// vulnerability. Bounds are compared with the version comparator of the
+// image, and empty ones are open.
+type AffectedRange struct {
+	ID              uint   `json:"-" gorm:"primaryKey"`
+	VulnerabilityID string `json:"-" gorm:"index"`
+	Package         string `json:"-" gorm:"index"`
+	// Introduced is the first affected version.
+	Introduced string `json:"-"`
+	// Fixed is the first version no longer affected, and LastAffected the
+	// last affected one. Both are empty when no version is fixed.
+	Fixed        string `json:"-"`
+	LastAffected string `json:"-"`
+}
+
+// Affects reports whether version falls in the range.
+func (r *AffectedRange) Affects(version string, compare diff.Comparator) bool {
+	if r.Introduced != "" && compare(version, r.Introduced) < 0 {
+		return false
+	}
+	if r.Fixed != "" && compare(version, r.Fixed) >= 0 {
+		return false
+	}
+	if r.LastAffected != "" && compare(version, r.LastAffected) > 0 {
+		return false
+	}
+
+	return true
+}
+
+// VulnerabilityImport describes the vulnerability database currently
+// imported.
+type VulnerabilityImport struct {
+	ID uint `json:"-" gorm:"primaryKey"`
+	// Format is the format of the imported file, and Scope the ecosystem or
+	// distribution release its advisories were restricted to.
+	Format          string    `json:"format"`
+	Scope           string    `json:"scope,omitempty"`
+	Vulnerabilities int       `json:"vulnerabilities"`
+	ImportedAt      time.Time `json:"imported_at"`
+}
+
+// VulnerabilityMatch is a vulnerability affecting a version of a package.
+type VulnerabilityMatch struct {
+	ID       string   `json:"id"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Summary  string   `json:"summary,omitempty"`
+	Severity string   `json:"severity,omitempty"`
+	Package  string   `json:"package"`
+	Version  string   `json:"version"`
+	// FixedVersion is the first version of the package fixing the
+	// vulnerability, if any.
+	FixedVersion string `json:"fixed_version,omitempty"`
+}
+
+// VulnerabilityChanges lists the vulnerabilities fixed and introduced by the
+// package changes between two releases. Fixed ones are reported with the old
+// version of their package, and introduced ones with the new version.
+type VulnerabilityChanges struct {
+	Fixed      []VulnerabilityMatch `json:"fixed"`
+	Introduced []VulnerabilityMatch `json:"introduced"`
+}
+
+// ReplaceVulnerabilities replaces the stored vulnerabilities with vulns,
+// imported as described by info.
+func ReplaceVulnerabilities(db *gorm.DB, info *VulnerabilityImport, vulns []Vulnerability) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []any{&AffectedRange{}, &Vulnerability{}, &VulnerabilityImport{}} {
+			if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		// Ranges are inserted on their own so that batches stay within the
+		// limits on query parameters
+		ranges := []AffectedRange{}
+		for _, vuln := range vulns {
+			for _, r := range vuln.Ranges {
+				r.VulnerabilityID = vuln.ID
+				ranges = append(ranges, r)
+			}
+		}
+		if len(vulns) > 0 {
+			if err := tx.Omit("Ranges").CreateInBatches(vulns, packageBatchSize).Error; err != nil {
+				return err
+			}
+		}
+		if len(ranges) > 0 {
+			if err := tx.CreateInBatches(ranges, packageBatchSize).Error; err != nil {
+				return err
+			}
+		}
+		info.Vulnerabilities = len(vulns)
+		return tx.Create(info).Error
+	})
+}
+
+// GetVulnerabilityImport returns the description of the imported
+// vulnerability database, or nil if none was imported.
+func GetVulnerabilityImport(db *gorm.DB) (*VulnerabilityImport, error) {
+	var imports []VulnerabilityImport
+	if err := db.Order("id DESC").Limit(1).Find(&imports).Error; err != nil {
+		return nil, err
+	}
+	if len(imports) == 0 {
+		return nil, nil
+	}
+
+	return &imports[0], nil
+}
+
+// MatchVulnerabilities returns the vulnerabilities affecting packages, sorted
+// by ID and package name.
+func MatchVulnerabilities(db *gorm.DB, compare diff.Comparator, packages []Package) ([]VulnerabilityMatch, error) {
+	names := []string{}
+	for _, pkg := range packages {
+		names = append(names, pkg.Name)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	ranges := map[string][]AffectedRange{}
+	ids := []string{}
+	for start := 0; start < len(names); start += packageBatchSize {
+		var found []AffectedRange
+		if err := db.Where("package IN ?", names[start:min(start+packageBatchSize, len(names))]).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range found {
+			ranges[r.Package] = append(ranges[r.Package], r)
+			ids = append(ids, r.VulnerabilityID)
+		}
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	vulns := map[string]*Vulnerability{}
+	for start := 0; start < len(ids); start += packageBatchSize {
+		var found []Vulnerability
+		if err := db.Where("id IN ?", ids[start:min(start+packageBatchSize, len(ids))]).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for i := range found {
+			vulns[found[i].ID] = &found[i]
+		}
+	}
+
+	matches := []VulnerabilityMatch{}
+	for _, pkg := range packages {
+		seen := map[string]bool{}
+		for _, r := range ranges[pkg.Name] {
+			vuln := vulns[r.VulnerabilityID]
+			if vuln == nil || seen[vuln.ID] || !r.Affects(pkg.Version, compare) {
+				continue
+			}
+			seen[vuln.ID] = true
+			matches = append(matches, VulnerabilityMatch{
+				ID:           vuln.ID,
+				Aliases:      vuln.Aliases,
+				Summary:      vuln.Summary,
+				Severity:     vuln.Severity,
+				Package:      pkg.Name,
+				Version:      pkg.Version,
+				FixedVersion: r.Fixed,
+			})
+		}
+	}
+	slices.SortFunc(matches, func(a, b VulnerabilityMatch) int {
+		return cmp.Or(cmp.Compare(a.ID, b.ID), cmp.Compare(a.Package, b.Package), cmp.Compare(a.Version, b.Version))
+	})
+
+	return slices.CompactFunc(matches, func(a, b VulnerabilityMatch) bool {
+		return a.ID == b.ID && a.Package == b.Package && a.Version == b.Version
+	}), nil
+}
+
+// DiffVulnerabilities returns the vulnerabilities fixed and introduced by
+// changing the packages oldPackages into newPackages, both holding only the
+// changed packages.
+func DiffVulnerabilities(db *gorm.DB, compare diff.Comparator, oldPackages, newPackages []Package) (*VulnerabilityChanges, error) {
+	before, err := MatchVulnerabilities(db, compare, oldPackages)
+	if err != nil {
+		return nil, err
+	}
+	after, err := MatchVulnerabilities(db, compare, newPackages)
+	if err != nil {
+		return nil, err
+	}
+
+	// A vulnerability is only fixed or introduced if no version of its
+	// package is still, or already, affected
+	affected := func(matches []VulnerabilityMatch, match VulnerabilityMatch) bool {
+		return slices.ContainsFunc(matches, func(m VulnerabilityMatch) bool {
+			return m.ID == match.ID && m.Package == match.Package
+		})
+	}
+	changes := &VulnerabilityChanges{Fixed: []VulnerabilityMatch{}, Introduced: []VulnerabilityMatch{}}
+	for _, match := range before {
+		if !affected(after, match) {
+			changes.Fixed = append(changes.Fixed, match)
+		}
+	}
+	for _, match := range after {
+		if !affected(before, match) {
+			changes.Introduced = append(changes.Introduced, match)
+		}
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,85 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vanilla-os/differ/diff"
+)
+
+// matchIDs returns the vulnerability and package of each match.
+func matchIDs(matches []VulnerabilityMatch) []string {
+	ids := []string{}
+	for _, match := range matches {
+		ids = append(ids, match.ID+"/"+match.Package+"@"+match.Version)
+	}
+	return ids
+}
+
+func TestVulnerabilities(t *testing.T) {
+	db := openTestDB(t)
+
+	if info, err := GetVulnerabilityImport(db); err != nil || info != nil {
+		t.Fatalf("expected no vulnerability database, got %+v (%v)", info, err)
+	}
+
+	vulns := []Vulnerability{
+		{ID: "CVE-1", Severity: "high", Ranges: []AffectedRange{{Package: "openssl", Fixed: "3.0.11"}}},
+		{ID: "CVE-2", Ranges: []AffectedRange{{Package: "openssl", Introduced: "3.0.11", LastAffected: "3.0.12"}}},
+		{ID: "CVE-3", Ranges: []AffectedRange{{Package: "curl"}, {Package: "libcurl", Introduced: "8.0"}}},
+	}
+	// Importing replaces the previous database
+	if err := ReplaceVulnerabilities(db, &VulnerabilityImport{Format: "osv"}, []Vulnerability{{ID: "CVE-0", Ranges: []AffectedRange{{Package: "openssl"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplaceVulnerabilities(db, &VulnerabilityImport{Format: "debian", Scope: "bookworm", ImportedAt: time.Now()}, vulns); err != nil {
+		t.Fatal(err)
+	}
+	info, err := GetVulnerabilityImport(db)
+	if err != nil || info == nil || info.Format != "debian" || info.Vulnerabilities != 3 {
+		t.Fatalf("unexpected vulnerability database %+v (%v)", info, err)
+	}
+
+	tests := []struct {
+		packages []Package
+		matches  []string
+	}{
+		{[]Package{{Name: "openssl", Version: "3.0.10"}}, []string{"CVE-1/openssl@3.0.10"}},
+		{[]Package{{Name: "openssl", Version: "3.0.11"}}, []string{"CVE-2/openssl@3.0.11"}},
+		{[]Package{{Name: "openssl", Version: "3.0.13"}}, []string{}},
+		{[]Package{{Name: "libcurl", Version: "7.88"}, {Name: "curl", Version: "7.88"}}, []string{"CVE-3/curl@7.88"}},
+	}
+	for _, test := range tests {
+		matches, err := MatchVulnerabilities(db, diff.CompareVersions, test.packages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := matchIDs(matches); len(ids) != len(test.matches) || (len(ids) > 0 && ids[0] != test.matches[0]) {
+			t.Errorf("%+v: expected %v, got %v", test.packages, test.matches, ids)
+		}
+	}
+
+	// Upgrading openssl fixes CVE-1 and introduces CVE-2, removing curl
+	// fixes CVE-3 and adding libcurl 8 introduces it again
+	changes, err := DiffVulnerabilities(db, diff.CompareVersions,
+		[]Package{{Name: "openssl", Version: "3.0.10"}, {Name: "curl", Version: "7.88"}},
+		[]Package{{Name: "openssl", Version: "3.0.11"}, {Name: "libcurl", Version: "8.1"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed, introduced := matchIDs(changes.Fixed), matchIDs(changes.Introduced)
+	if len(fixed) != 2 || fixed[0] != "CVE-1/openssl@3.0.10" || fixed[1] != "CVE-3/curl@7.88" || changes.Fixed[0].Severity != "high" || changes.Fixed[0].FixedVersion != "3.0.11" {
+		t.Errorf("unexpected fixed vulnerabilities %v", fixed)
+	}
+	if len(introduced) != 2 || introduced[0] != "CVE-2/openssl@3.0.11" || introduced[1] != "CVE-3/libcurl@8.1" {
+		t.Errorf("unexpected introduced vulnerabilities %v", introduced)
+	}
+}
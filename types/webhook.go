@@ -0,0 +1,235 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Events of the activity of images, notified to webhooks and streamed to the
+// clients of /events.
+const (
+	EventImageCreated   = "image.created"
+	EventReleaseCreated = "release.created"
+	EventReleaseYanked  = "release.yanked"
+	EventTagMoved       = "tag.moved"
+)
+
+// WebhookEvents lists the events webhooks can subscribe to.
+var WebhookEvents = []string{EventImageCreated, EventReleaseCreated}
+
+// Statuses of webhook deliveries.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL notified of the events of one image or, when ImageID is
+// zero, of every image.
+type Webhook struct {
+	ID      uint `json:"id" gorm:"primaryKey"`
+	ImageID uint `json:"-" gorm:"index"`
+	// Image is the name of the image, filled in when webhooks are loaded.
+	Image string `json:"image,omitempty" gorm:"-"`
+	URL   string `json:"url"`
+	// Secret signs the payloads sent to the webhook, and is never shown.
+	Secret string `json:"-"`
+	// Events lists the events the webhook subscribes to, every event when
+	// empty.
+	Events    []string  `json:"events" gorm:"serializer:json"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery records the notification of an event to a webhook, and the
+// outcome of its last attempt.
+type WebhookDelivery struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	WebhookID uint   `json:"webhook_id" gorm:"index"`
+	Event     string `json:"event"`
+	// Payload is the JSON body sent to the webhook. It is left out of
+	// listings.
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Status is DeliveryPending until the webhook answers with a 2xx
+	// status, or the last attempt fails.
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// ResponseStatus and Error describe the outcome of the last attempt.
+	ResponseStatus int    `json:"response_status,omitempty"`
+	Error          string `json:"error,omitempty"`
+	// RedeliveryOf is the ID of the delivery this one repeats, if any.
+	RedeliveryOf uint      `json:"redelivery_of,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// DeliveryQuery selects a page of the deliveries of a webhook, newest first.
+type DeliveryQuery struct {
+	Limit  int    `form:"limit"`
+	Cursor string `form:"cursor"`
+}
+
+// NewWebhook validates and stores hook.
+func NewWebhook(db *gorm.DB, hook *Webhook) error {
+	target, err := url.Parse(hook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return invalidQuery("invalid webhook URL %q, expected an absolute http or https URL", hook.URL)
+	}
+	if hook.Secret == "" {
+		return invalidQuery("webhooks require a secret to sign their payloads")
+	}
+	for _, event := range hook.Events {
+		if !slices.Contains(WebhookEvents, event) {
+			return invalidQuery("unknown event %q, expected one of %s", event, strings.Join(WebhookEvents, ", "))
+		}
+	}
+	if hook.Events == nil {
+		hook.Events = []string{}
+	}
+
+	return db.Create(hook).Error
+}
+
+// ListWebhooks returns every webhook, oldest first.
+func ListWebhooks(db *gorm.DB) ([]Webhook, error) {
+	hooks := []Webhook{}
+	if err := db.Order("id").Find(&hooks).Error; err != nil {
+		return nil, err
+	}
+
+	return hooks, fillImageNames(db, hooks)
+}
+
+// GetWebhook returns the webhook with the given ID.
+func GetWebhook(db *gorm.DB, id uint) (*Webhook, error) {
+	var hook Webhook
+	err := db.First(&hook, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, notFound("no webhook found with ID %d", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := []Webhook{hook}
+	return &hooks[0], fillImageNames(db, hooks)
+}
+
+// SubscribedWebhooks returns the webhooks notified of event for the image
+// with the given ID.
+func SubscribedWebhooks(db *gorm.DB, imageID uint, event string) ([]Webhook, error) {
+	hooks := []Webhook{}
+	if err := db.Where("image_id IN ?", []uint{0, imageID}).Order("id").Find(&hooks).Error; err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(hooks, func(hook Webhook) bool {
+		return len(hook.Events) > 0 && !slices.Contains(hook.Events, event)
+	}), nil
+}
+
+// fillImageNames sets the Image of hooks from their ImageID.
+func fillImageNames(db *gorm.DB, hooks []Webhook) error {
+	ids := []uint{}
+	for _, hook := range hooks {
+		if hook.ImageID != 0 {
+			ids = append(ids, hook.ImageID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	images := []Image{}
+	if err := db.Select("id", "name").Find(&images, ids).Error; err != nil {
+		return err
+	}
+	names := map[uint]string{}
+	for _, image := range images {
+		names[image.ID] = image.Name
+	}
+	for i := range hooks {
+		hooks[i].Image = names[hooks[i].ImageID]
+	}
+
+	return nil
+}
+
+// Delete removes the webhook and its deliveries.
+func (w *Webhook) Delete(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return deleteWebhooks(tx, []uint{w.ID})
+	})
+}
+
+func deleteWebhooks(db *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := db.Where("webhook_id IN ?", ids).Delete(&WebhookDelivery{}).Error; err != nil {
+		return err
+	}
+
+	return db.Delete(&Webhook{}, ids).Error
+}
+
+// NewDelivery records a pending delivery of event to the webhook.
+func (w *Webhook) NewDelivery(db *gorm.DB, event string, payload []byte, redeliveryOf uint) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{
+		WebhookID:    w.ID,
+		Event:        event,
+		Payload:      payload,
+		Status:       DeliveryPending,
+		RedeliveryOf: redeliveryOf,
+	}
+
+	return delivery, db.Create(delivery).Error
+}
+
+// ListDeliveries returns the page of deliveries to the webhook selected by
+// query, without their payloads, and the cursor of the next page, empty on
+// the last one.
+func (w *Webhook) ListDeliveries(db *gorm.DB, query DeliveryQuery) ([]WebhookDelivery, string, error) {
+	tx := db.Model(&WebhookDelivery{}).Omit("payload").Where("webhook_id = ?", w.ID)
+	return listPage(tx, "-created", query.Limit, query.Cursor, map[string]sortColumn[WebhookDelivery]{
+		"created": {
+			name:   "id",
+			cursor: func(d *WebhookDelivery) cursor { return cursor{ID: d.ID} },
+		},
+	})
+}
+
+// GetDelivery returns the delivery to the webhook with the given ID.
+func (w *Webhook) GetDelivery(db *gorm.DB, id uint) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := db.First(&delivery, "id = ? AND webhook_id = ?", id, w.ID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, notFound("webhook %d has no delivery %d", w.ID, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// Record saves the outcome of the last attempt of the delivery.
+func (d *WebhookDelivery) Record(db *gorm.DB) error {
+	return db.Model(d).Updates(map[string]any{
+		"status":          d.Status,
+		"attempts":        d.Attempts,
+		"response_status": d.ResponseStatus,
+		"error":           d.Error,
+	}).Error
+}
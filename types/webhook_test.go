@@ -0,0 +1,83 @@
+package types
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWebhooks(t *testing.T) {
+	db := openTestDB(t)
+	image := newTestImage(t, db)
+
+	invalid := []Webhook{
+		{URL: "example.com/hook", Secret: "secret"},
+		{URL: "ftp://example.com/hook", Secret: "secret"},
+		{URL: "https://example.com/hook"},
+		{URL: "https://example.com/hook", Secret: "secret", Events: []string{"release.deleted"}},
+	}
+	for _, hook := range invalid {
+		if err := NewWebhook(db, &hook); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("expected webhook %+v to be rejected, got %v", hook, err)
+		}
+	}
+
+	hooks := []Webhook{
+		{URL: "https://example.com/global", Secret: "secret"},
+		{ImageID: image.ID, URL: "https://example.com/images", Secret: "secret", Events: []string{EventImageCreated}},
+		{ImageID: image.ID, URL: "https://example.com/releases", Secret: "secret", Events: []string{EventReleaseCreated}},
+		{ImageID: image.ID + 1, URL: "https://example.com/other", Secret: "secret"},
+	}
+	for i := range hooks {
+		if err := NewWebhook(db, &hooks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subscribed, err := SubscribedWebhooks(db, image.ID, EventReleaseCreated)
+	if err != nil || len(subscribed) != 2 || subscribed[0].ID != hooks[0].ID || subscribed[1].ID != hooks[2].ID {
+		t.Fatalf("unexpected subscribed webhooks %+v (%v)", subscribed, err)
+	}
+
+	hook, err := GetWebhook(db, hooks[2].ID)
+	if err != nil || hook.Image != image.Name || hook.Secret != "secret" {
+		t.Fatalf("unexpected webhook %+v (%v)", hook, err)
+	}
+	for range 3 {
+		if _, err := hook.NewDelivery(db, EventReleaseCreated, []byte(`{}`), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliveries, next, err := hook.ListDeliveries(db, DeliveryQuery{Limit: 2})
+	if err != nil || len(deliveries) != 2 || next == "" || deliveries[0].ID < deliveries[1].ID || deliveries[0].Payload != nil {
+		t.Fatalf("unexpected deliveries %+v (%v)", deliveries, err)
+	}
+	if _, err := hooks[0].GetDelivery(db, deliveries[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deliveries of other webhooks not to be found, got %v", err)
+	}
+
+	// Deleting an image removes its webhooks and their deliveries
+	loaded, err := GetImageByName(db, image.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Delete(db); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, _ := ListWebhooks(db); len(remaining) != 2 {
+		t.Fatalf("expected webhooks of deleted image to be removed, got %+v", remaining)
+	}
+	var count int64
+	if db.Model(&WebhookDelivery{}).Count(&count); count != 0 {
+		t.Fatalf("expected deliveries of deleted webhooks to be removed, %d remain", count)
+	}
+	if _, err := GetWebhook(db, hooks[2].ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted webhook not to be found, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package vulndb
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+// debianIssue is an entry of the Debian Security Tracker dump, which maps
+// source packages to their issues, by ID.
+type debianIssue struct {
+	Description string `json:"description"`
+	Releases    map[string]struct {
+		Status       string `json:"status"`
+		FixedVersion string `json:"fixed_version"`
+		Urgency      string `json:"urgency"`
+	} `json:"releases"`
+}
+
+// ReadDebian reads the JSON dump of the Debian Security Tracker, keeping the
+// issues open or fixed in release. Issues whose status is undetermined are
+// skipped, as well as those never affecting the release, marked as fixed in
+// version 0.
+func ReadDebian(r io.Reader, release string) ([]types.Vulnerability, error) {
+	if release == "" {
+		return nil, errors.New("Debian Security Tracker dumps require the codename of a release")
+	}
+
+	var dump map[string]map[string]debianIssue
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return nil, err
+	}
+
+	// Packages are sorted so that the description of issues affecting
+	// several of them doesn't depend on the order of the map
+	pkgs := make([]string, 0, len(dump))
+	for pkg := range dump {
+		pkgs = append(pkgs, pkg)
+	}
+	slices.Sort(pkgs)
+
+	vulns := vulnerabilities{}
+	for _, pkg := range pkgs {
+		for id, issue := range dump[pkg] {
+			status, ok := issue.Releases[release]
+			if !ok {
+				continue
+			}
+
+			affected := types.AffectedRange{Package: pkg}
+			switch {
+			case status.Status == "open":
+			case status.Status == "resolved" && status.FixedVersion != "" && status.FixedVersion != "0":
+				affected.Fixed = status.FixedVersion
+			default:
+				continue
+			}
+
+			vulns.add(types.Vulnerability{
+				ID:       id,
+				Summary:  issue.Description,
+				Severity: normalizeSeverity(status.Urgency),
+			}, affected)
+		}
+	}
+
+	return vulns.list(), nil
+}
@@ -0,0 +1,160 @@
+package vulndb
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+// osvRecord holds the fields of OSV records used by Differ.
+type osvRecord struct {
+	ID               string         `json:"id"`
+	Aliases          []string       `json:"aliases"`
+	Summary          string         `json:"summary"`
+	Details          string         `json:"details"`
+	Withdrawn        string         `json:"withdrawn"`
+	DatabaseSpecific map[string]any `json:"database_specific"`
+	Affected         []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string              `json:"type"`
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		Versions          []string       `json:"versions"`
+		EcosystemSpecific map[string]any `json:"ecosystem_specific"`
+	} `json:"affected"`
+}
+
+// ReadOSV reads a JSON document holding one OSV record or an array of them.
+// Only the packages of ecosystem are kept, unless it is empty. Withdrawn
+// records are skipped, as well as git commit ranges.
+func ReadOSV(r io.Reader, ecosystem string) ([]types.Vulnerability, error) {
+	reader := bufio.NewReader(r)
+	var records []osvRecord
+	first, err := firstByte(reader)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(reader)
+	if first == '[' {
+		err = decoder.Decode(&records)
+	} else {
+		records = make([]osvRecord, 1)
+		err = decoder.Decode(&records[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	vulns := vulnerabilities{}
+	for _, record := range records {
+		if record.ID == "" || record.Withdrawn != "" {
+			continue
+		}
+
+		vuln := types.Vulnerability{ID: record.ID, Aliases: record.Aliases, Summary: record.Summary}
+		if vuln.Summary == "" {
+			vuln.Summary, _, _ = strings.Cut(strings.TrimSpace(record.Details), "\n")
+		}
+		if severity, ok := record.DatabaseSpecific["severity"].(string); ok {
+			vuln.Severity = normalizeSeverity(severity)
+		}
+
+		for _, affected := range record.Affected {
+			if ecosystem != "" && affected.Package.Ecosystem != ecosystem {
+				continue
+			}
+			if urgency, ok := affected.EcosystemSpecific["urgency"].(string); ok && vuln.Severity == "" {
+				vuln.Severity = normalizeSeverity(urgency)
+			}
+
+			ranges := []types.AffectedRange{}
+			for _, versions := range affected.Ranges {
+				if versions.Type == "ECOSYSTEM" || versions.Type == "SEMVER" {
+					ranges = append(ranges, osvRanges(versions.Events)...)
+				}
+			}
+			// Affected versions are only listed one by one when no range
+			// comparable to package versions describes them
+			if len(ranges) == 0 {
+				for _, version := range affected.Versions {
+					ranges = append(ranges, types.AffectedRange{Introduced: version, LastAffected: version})
+				}
+			}
+			for i := range ranges {
+				ranges[i].Package = affected.Package.Name
+			}
+			vulns.add(vuln, ranges...)
+		}
+	}
+
+	return vulns.list(), nil
+}
+
+// osvRanges returns the ranges described by the events of an OSV range. An
+// introduced event opens a range, closed by the following fixed or
+// last_affected event.
+func osvRanges(events []map[string]string) []types.AffectedRange {
+	var ranges []types.AffectedRange
+	var current *types.AffectedRange
+	for _, event := range events {
+		if version, ok := event["introduced"]; ok {
+			if current != nil {
+				ranges = append(ranges, *current)
+			}
+			// Version 0 stands for every version before the next event
+			if version == "0" {
+				version = ""
+			}
+			current = &types.AffectedRange{Introduced: version}
+			continue
+		}
+
+		if current == nil {
+			current = &types.AffectedRange{}
+		}
+		if version, ok := event["fixed"]; ok {
+			current.Fixed = version
+		} else if version, ok := event["last_affected"]; ok {
+			current.LastAffected = version
+		} else {
+			continue
+		}
+		ranges = append(ranges, *current)
+		current = nil
+	}
+	if current != nil {
+		ranges = append(ranges, *current)
+	}
+
+	return ranges
+}
+
+// firstByte returns the first byte of r that isn't whitespace, without
+// consuming it.
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return b, r.UnreadByte()
+		}
+	}
+}
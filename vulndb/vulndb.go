@@ -0,0 +1,144 @@
+// Package vulndb reads the security advisories of vulnerability databases,
+// from the dumps they publish, so that they can be matched against the
+// packages of releases.
+//
+// Two formats are supported: OSV records (https://ossf.github.io/osv-schema/),
+// as a JSON file holding one record or an array of them, or as the zip
+// archives of https://osv.dev, and the JSON dump of the Debian Security
+// Tracker (https://security-tracker.debian.org/tracker/data/json).
+package vulndb
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+// Format is the format of a vulnerability database dump.
+type Format string
+
+const (
+	OSV    Format = "osv"
+	Debian Format = "debian"
+)
+
+// Formats lists every supported format.
+var Formats = []Format{OSV, Debian}
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	format := Format(name)
+	if !slices.Contains(Formats, format) {
+		return "", fmt.Errorf("unknown vulnerability database format %q, expected osv or debian", name)
+	}
+
+	return format, nil
+}
+
+// Load reads the vulnerabilities of the dump at path, in format. For OSV
+// dumps, scope restricts advisories to one ecosystem, such as "Debian:12",
+// when not empty. For Debian dumps, it is the required codename of the
+// release whose status is read, such as "bookworm". OSV zip archives are
+// recognized by their extension.
+func Load(path string, format Format, scope string) ([]types.Vulnerability, error) {
+	if format == Debian {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		return ReadDebian(file, scope)
+	}
+
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		return ReadOSV(file, scope)
+	}
+
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	vulns := vulnerabilities{}
+	for _, entry := range archive.File {
+		if !strings.HasSuffix(entry.Name, ".json") {
+			continue
+		}
+		file, err := entry.Open()
+		if err != nil {
+			return nil, err
+		}
+		records, err := ReadOSV(file, scope)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		for _, vuln := range records {
+			vulns.add(vuln, vuln.Ranges...)
+		}
+	}
+
+	return vulns.list(), nil
+}
+
+// vulnerabilities collects vulnerabilities by ID, merging the ranges of
+// those listed several times, and their severity when only known later.
+type vulnerabilities map[string]*types.Vulnerability
+
+func (vs vulnerabilities) add(vuln types.Vulnerability, ranges ...types.AffectedRange) {
+	if len(ranges) == 0 {
+		return
+	}
+	if existing, ok := vs[vuln.ID]; ok {
+		existing.Ranges = append(existing.Ranges, ranges...)
+		if existing.Severity == "" {
+			existing.Severity = vuln.Severity
+		}
+		return
+	}
+
+	vuln.Ranges = slices.Clone(ranges)
+	vs[vuln.ID] = &vuln
+}
+
+// list returns the vulnerabilities sorted by ID.
+func (vs vulnerabilities) list() []types.Vulnerability {
+	list := make([]types.Vulnerability, 0, len(vs))
+	for _, vuln := range vs {
+		list = append(list, *vuln)
+	}
+	slices.SortFunc(list, func(a, b types.Vulnerability) int { return strings.Compare(a.ID, b.ID) })
+
+	return list
+}
+
+// normalizeSeverity returns severity in lowercase, without the asterisks the
+// Debian tracker appends to some urgencies, and empty when unknown.
+func normalizeSeverity(severity string) string {
+	severity = strings.ToLower(strings.TrimRight(strings.TrimSpace(severity), "*"))
+	if severity == "not yet assigned" {
+		return ""
+	}
+
+	return severity
+}
@@ -0,0 +1,171 @@
+package vulndb
+
+/*
+ * 	License: GPL-3.0-or-later
+ * 	Authors:
+ * 		Mateus Melchiades <matbme@duck.com>
+ * 	Copyright: 2023
+ */
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vanilla-os/differ/types"
+)
+
+const osvRecords = `[
+	{
+		"id": "DSA-5000-1",
+		"aliases": ["CVE-2024-0001"],
+		"details": "openssl - security update\nMore details.",
+		"affected": [
+			{
+				"package": {"ecosystem": "Debian:12", "name": "openssl"},
+				"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "3.0.11-1"}, {"introduced": "3.1.0"}]}],
+				"ecosystem_specific": {"urgency": "high**"}
+			},
+			{
+				"package": {"ecosystem": "Debian:11", "name": "openssl"},
+				"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.1.1w-0"}]}]
+			}
+		]
+	},
+	{
+		"id": "GHSA-xxxx",
+		"summary": "Path traversal",
+		"database_specific": {"severity": "MODERATE"},
+		"affected": [
+			{
+				"package": {"ecosystem": "Debian:12", "name": "tar"},
+				"ranges": [{"type": "GIT", "events": [{"introduced": "abc"}]}],
+				"versions": ["1.34"]
+			},
+			{
+				"package": {"ecosystem": "Debian:12", "name": "cpio"},
+				"versions": ["2.13", "2.14"]
+			}
+		]
+	},
+	{
+		"id": "DSA-4000-1",
+		"withdrawn": "2024-01-01T00:00:00Z",
+		"affected": [{"package": {"ecosystem": "Debian:12", "name": "bash"}, "versions": ["5.2"]}]
+	}
+]`
+
+func TestReadOSV(t *testing.T) {
+	vulns, err := ReadOSV(strings.NewReader(osvRecords), "Debian:12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []types.Vulnerability{
+		{
+			ID:       "DSA-5000-1",
+			Aliases:  []string{"CVE-2024-0001"},
+			Summary:  "openssl - security update",
+			Severity: "high",
+			Ranges: []types.AffectedRange{
+				{Package: "openssl", Fixed: "3.0.11-1"},
+				{Package: "openssl", Introduced: "3.1.0"},
+			},
+		},
+		{
+			ID:       "GHSA-xxxx",
+			Summary:  "Path traversal",
+			Severity: "moderate",
+			Ranges: []types.AffectedRange{
+				{Package: "tar", Introduced: "1.34", LastAffected: "1.34"},
+				{Package: "cpio", Introduced: "2.13", LastAffected: "2.13"},
+				{Package: "cpio", Introduced: "2.14", LastAffected: "2.14"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(vulns, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, vulns)
+	}
+
+	// Single records and every ecosystem
+	vulns, err = ReadOSV(strings.NewReader(`{"id": "OSV-1", "affected": [{"package": {"name": "a"}, "versions": ["1"]}, {"package": {"name": "b"}, "versions": ["2"]}]}`), "")
+	if err != nil || len(vulns) != 1 || len(vulns[0].Ranges) != 2 {
+		t.Fatalf("unexpected vulnerabilities %+v (%v)", vulns, err)
+	}
+
+	// The severity of any affected package applies to the vulnerability
+	vulns, err = ReadOSV(strings.NewReader(`{"id": "OSV-2", "affected": [{"package": {"name": "a"}, "versions": ["1"]}, {"package": {"name": "b"}, "versions": ["2"], "ecosystem_specific": {"urgency": "low"}}]}`), "")
+	if err != nil || len(vulns) != 1 || vulns[0].Severity != "low" {
+		t.Fatalf("unexpected vulnerabilities %+v (%v)", vulns, err)
+	}
+}
+
+func TestReadDebian(t *testing.T) {
+	dump := `{
+		"openssl": {
+			"CVE-2024-0001": {"description": "Buffer overflow", "releases": {
+				"bookworm": {"status": "resolved", "fixed_version": "3.0.11-1~deb12u1", "urgency": "high"},
+				"trixie": {"status": "resolved", "fixed_version": "3.0.11-1", "urgency": "high"}
+			}},
+			"CVE-2024-0002": {"releases": {"bookworm": {"status": "open", "urgency": "not yet assigned"}}},
+			"CVE-2024-0003": {"releases": {"bookworm": {"status": "resolved", "fixed_version": "0", "urgency": "unimportant"}}},
+			"CVE-2024-0004": {"releases": {"bookworm": {"status": "undetermined", "urgency": "low"}}}
+		},
+		"libssl": {
+			"CVE-2024-0001": {"description": "Buffer overflow in libssl", "releases": {
+				"bookworm": {"status": "open", "urgency": "low**"}
+			}}
+		}
+	}`
+
+	vulns, err := ReadDebian(strings.NewReader(dump), "bookworm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.Vulnerability{
+		{
+			ID:       "CVE-2024-0001",
+			Summary:  "Buffer overflow in libssl",
+			Severity: "low",
+			Ranges: []types.AffectedRange{
+				{Package: "libssl"},
+				{Package: "openssl", Fixed: "3.0.11-1~deb12u1"},
+			},
+		},
+		{ID: "CVE-2024-0002", Ranges: []types.AffectedRange{{Package: "openssl"}}},
+	}
+	if !reflect.DeepEqual(vulns, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, vulns)
+	}
+
+	if _, err := ReadDebian(strings.NewReader(dump), ""); err == nil {
+		t.Fatal("expected Debian dumps to require a release")
+	}
+}
+
+func TestLoadZip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "all.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(file)
+	for name, content := range map[string]string{
+		"DSA-1.json": `{"id": "DSA-1", "affected": [{"package": {"ecosystem": "Debian:12", "name": "a"}, "versions": ["1"]}]}`,
+		"DSA-2.json": `{"id": "DSA-2", "affected": [{"package": {"ecosystem": "Debian:11", "name": "b"}, "versions": ["1"]}]}`,
+		"README":     `not an advisory`,
+	} {
+		w, _ := archive.Create(name)
+		w.Write([]byte(content))
+	}
+	archive.Close()
+	file.Close()
+
+	vulns, err := Load(path, OSV, "Debian:12")
+	if err != nil || len(vulns) != 1 || vulns[0].ID != "DSA-1" {
+		t.Fatalf("unexpected vulnerabilities %+v (%v)", vulns, err)
+	}
+}